@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"limit-order-book/engine"
+)
+
+// runCandles runs the candles subcommand. It throws away each symbol's
+// stored candles of the given intervals and rebuilds them from its trade
+// history.
+func runCandles(args []string) error {
+	fs := flag.NewFlagSet("candles", flag.ContinueOnError)
+	kind := fs.String("storage", "postgres", "storage backend: sqlite or postgres")
+	symbol := fs.String("symbol", "", "symbol to rebuild; empty means every symbol in storage")
+	names := fs.String("intervals", engine.DefaultIntervals, "comma-separated candle intervals to rebuild")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *kind != "postgres" && *kind != "sqlite" {
+		return fmt.Errorf("storage backend %q keeps candles in memory; they are rebuilt on startup", *kind)
+	}
+
+	intervals, err := engine.ParseIntervals(*names)
+	if err != nil {
+		return err
+	}
+	backend, err := openStorage(*kind, false, false)
+	if err != nil {
+		return err
+	}
+	defer backend.close()
+
+	symbols := []string{*symbol}
+	if *symbol == "" {
+		instruments, err := backend.symbols.LoadSymbols()
+		if err != nil {
+			return err
+		}
+		if len(instruments) == 0 {
+			return errors.New("no symbols in storage; pass -symbol")
+		}
+		symbols = symbols[:0]
+		for _, i := range instruments {
+			symbols = append(symbols, i.Symbol)
+		}
+	}
+
+	candles := engine.NewCandleAggregator(backend.candles, intervals)
+	for _, name := range symbols {
+		if err := candles.Rebuild(name, backend.trades, true); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		fmt.Printf("%s: rebuilt\n", name)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"limit-order-book/engine"
+)
+
+// runCheck runs the check subcommand. It restores each symbol's book from
+// storage and checks its invariants. Given a journal, it also rebuilds the
+// book from the journal and compares the two.
+func runCheck(args []string) error {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	kind := fs.String("storage", "postgres", "storage backend: sqlite, postgres or json")
+	symbol := fs.String("symbol", "", "symbol to check; empty means every symbol in storage")
+	journalDir := fs.String("journal", "", "journal directory to compare storage against")
+	snapshotDir := fs.String("snapshots", "", "snapshot directory to recover from along with -journal")
+	config := fs.String("config", "", "JSON file with the symbols' instrument specs")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	backend, err := openStorage(*kind, false, false)
+	if err != nil {
+		return err
+	}
+	defer backend.close()
+
+	names := []string{*symbol}
+	if *symbol == "" {
+		instruments, err := backend.symbols.LoadSymbols()
+		if err != nil {
+			return err
+		}
+		if len(instruments) == 0 {
+			return errors.New("no symbols in storage; pass -symbol")
+		}
+		names = names[:0]
+		for _, i := range instruments {
+			names = append(names, i.Symbol)
+		}
+	}
+
+	failed := false
+	for _, name := range names {
+		problems, err := checkSymbol(name, backend.newStorage(name), *journalDir, *snapshotDir, *config)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if len(problems) == 0 {
+			fmt.Printf("%s: ok\n", name)
+			continue
+		}
+		failed = true
+		for _, problem := range problems {
+			fmt.Printf("%s: %s\n", name, problem)
+		}
+	}
+	if failed {
+		return errors.New("check found problems")
+	}
+	return nil
+}
+
+func checkSymbol(symbol string, store engine.Storage, journalDir string, snapshotDir string, config string) ([]string, error) {
+	stored, err := store.RestoreOrderBook()
+	if err != nil {
+		return nil, err
+	}
+	problems := []string{}
+	for _, problem := range stored.CheckInvariants() {
+		problems = append(problems, "stored book: "+problem)
+	}
+	if journalDir == "" {
+		return problems, nil
+	}
+
+	recovered, err := recoverBook(symbol, journalDir, snapshotDir, config)
+	if err != nil {
+		return nil, err
+	}
+	for _, problem := range recovered.CheckInvariants() {
+		problems = append(problems, "journal book: "+problem)
+	}
+	for _, diff := range engine.DiffBooks(recovered.ToDTO(), stored.ToDTO()) {
+		problems = append(problems, "journal vs storage: "+diff)
+	}
+	return problems, nil
+}
@@ -0,0 +1,113 @@
+package engine
+
+import (
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrOrderNotFound = errors.New("order not found")
+	ErrInvalidAmend  = errors.New("amended size must be greater than the filled size")
+	ErrWouldCross    = errors.New("post-only order would cross the book")
+)
+
+// AmendOrder changes the price and total size of a resting order.
+//
+// Shrinking an order at its current price keeps its place in the level's
+// queue. Any other change takes the order off the book and sends it back
+// in as a new order with the same id: it loses time priority and, if the
+// new price crosses, matches straight away. newSize is the new total size
+// including whatever has already been filled. The new price and size are
+// checked against the instrument like those of a new order.
+func (ob *OrderBook) AmendOrder(id uuid.UUID, newPrice int, newSize int) (OrderResult, error) {
+	if err := ob.begin(); err != nil {
+		return OrderResult{}, err
+	}
+
+	order := ob.orders[id]
+	if order == nil {
+		return OrderResult{}, ErrOrderNotFound
+	}
+
+	if err := ob.instrument.checkPrice(newPrice); err != nil {
+		return OrderResult{}, err
+	}
+	if err := ob.instrument.checkSize(newSize); err != nil {
+		return OrderResult{}, err
+	}
+
+	filled := order.Size - order.Remaining - order.Hidden
+	if newSize <= filled {
+		return OrderResult{}, ErrInvalidAmend
+	}
+
+	if order.PostOnly && !(newPrice == order.Price && newSize <= order.Size) {
+		if touch := ob.bestOpposite(order.Side); touch != nil && order.crossesAt(newPrice, touch.Price) {
+			return OrderResult{}, ErrWouldCross
+		}
+	}
+
+	return ob.execute(&JournalEntry{Type: AmendCommand, OrderID: &id, Price: newPrice, Size: newSize})
+}
+
+func (ob *OrderBook) amendOrder(id uuid.UUID, newPrice int, newSize int) (OrderResult, error) {
+	order := ob.orders[id]
+	filled := order.Size - order.Remaining - order.Hidden
+
+	if newPrice == order.Price && newSize <= order.Size {
+		if err := ob.reduceOrder(order, order.Size-newSize); err != nil {
+			return OrderResult{}, err
+		}
+		return OrderResult{
+			Id:       order.Id,
+			Price:    order.Price,
+			Filled:   filled,
+			Unfilled: order.Remaining + order.Hidden,
+			Resting:  true,
+		}, ob.health()
+	}
+
+	replaced := *order
+	if _, err := ob.RemoveOrder(*order); err != nil {
+		return OrderResult{}, err
+	}
+
+	replaced.Price = newPrice
+	replaced.Size = newSize
+	replaced.Remaining = newSize - filled
+	replaced.Hidden = 0
+	replaced.Time = ob.clock.Now()
+
+	firstTrade := len(ob.trades)
+	result, err := ob.executeOrder(&replaced, false)
+	if err != nil {
+		return OrderResult{}, err
+	}
+	if err := ob.activateStops(firstTrade); err != nil {
+		return OrderResult{}, err
+	}
+	return result, ob.health()
+}
+
+// reduceOrder takes size off a resting order without moving it in its
+// level's queue. An iceberg gives up hidden reserve before visible size.
+// If storage refuses the change the order is put back as it was.
+func (ob *OrderBook) reduceOrder(order *Order, size int) error {
+	previous := *order
+	fromHidden := min(size, order.Hidden)
+	order.Hidden -= fromHidden
+	order.Remaining -= size - fromHidden
+	order.parentLevel.Volume -= size - fromHidden
+	order.Size -= size
+
+	err := ob.persist("update order", func() error {
+		return ob.storage.UpdateOrder(order.ToDTO())
+	})
+	if err != nil {
+		order.parentLevel.Volume += size - fromHidden
+		*order = previous
+		return err
+	}
+	return nil
+}
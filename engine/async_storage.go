@@ -0,0 +1,334 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type MutationOp string
+
+const (
+	OpReset           MutationOp = "reset"
+	OpInsertLevel     MutationOp = "insert_level"
+	OpInsertOrder     MutationOp = "insert_order"
+	OpDeleteOrder     MutationOp = "delete_order"
+	OpUpdateOrder     MutationOp = "update_order"
+	OpInsertTrade     MutationOp = "insert_trade"
+	OpInsertStopOrder MutationOp = "insert_stop_order"
+	OpDeleteStopOrder MutationOp = "delete_stop_order"
+)
+
+// Mutation is one storage write, made by the command with sequence number
+// Seq.
+type Mutation struct {
+	Seq   uint64     `json:"seq"`
+	Op    MutationOp `json:"op"`
+	Side  Side       `json:"side,omitempty"`
+	Level *LevelDTO  `json:"level,omitempty"`
+	Order *OrderDTO  `json:"order,omitempty"`
+	Trade *Trade     `json:"trade,omitempty"`
+}
+
+// Apply makes the write on storage.
+func (m Mutation) Apply(storage Storage) error {
+	switch m.Op {
+	case OpReset:
+		return storage.ResetOrderBook()
+	case OpInsertLevel:
+		return storage.InsertLevel(m.Side, m.Level)
+	case OpInsertOrder:
+		return storage.InsertOrder(m.Order)
+	case OpDeleteOrder:
+		return storage.DeleteOrder(m.Order)
+	case OpUpdateOrder:
+		return storage.UpdateOrder(m.Order)
+	case OpInsertTrade:
+		return storage.InsertTrade(m.Trade)
+	case OpInsertStopOrder:
+		return storage.InsertStopOrder(m.Order)
+	case OpDeleteStopOrder:
+		return storage.DeleteStopOrder(m.Order)
+	}
+	return fmt.Errorf("unknown mutation %q", m.Op)
+}
+
+// BatchStorage is a Storage that can write the mutations of many commands
+// at once, all or nothing, faster than one by one.
+type BatchStorage interface {
+	Storage
+	WriteBatch(mutations []Mutation) error
+}
+
+// WriteBatch writes mutations to storage in a single unit of work, through
+// its own WriteBatch if it has one.
+func WriteBatch(storage Storage, mutations []Mutation) error {
+	if batch, ok := storage.(BatchStorage); ok {
+		return batch.WriteBatch(mutations)
+	}
+
+	if err := storage.Begin(mutations[len(mutations)-1].Seq); err != nil {
+		return err
+	}
+	for _, m := range mutations {
+		if err := m.Apply(storage); err != nil {
+			storage.Rollback()
+			return err
+		}
+	}
+	return storage.Commit()
+}
+
+var errAsyncClosed = errors.New("async storage is closed")
+
+type AsyncConfig struct {
+	// QueueSize bounds the commands waiting to be written. Commit blocks
+	// once it is full.
+	QueueSize int
+	// BatchSize is the number of mutations that triggers a write before
+	// FlushInterval is up.
+	BatchSize     int
+	FlushInterval time.Duration
+}
+
+// AsyncStorage takes storage writes off the matching path. The mutations
+// of each command are queued when it commits and a background writer
+// writes them to the backend in batches. DurableSeq tells how far the
+// backend has got.
+//
+// A failed batch is retried every FlushInterval. Meanwhile Commit returns
+// the error, which degrades the book, so nothing more is queued until a
+// resync or reset, whose leading reset makes everything queued before it
+// moot.
+type AsyncStorage struct {
+	backend Storage
+	config  AsyncConfig
+
+	// Only used by the book's goroutine.
+	seq     uint64
+	open    bool
+	pending []Mutation
+
+	units   chan []Mutation
+	flushes chan chan struct{}
+	durable atomic.Uint64
+	mu      sync.Mutex
+	err     error
+
+	quit      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+}
+
+// NewAsyncStorage starts the writer for backend.
+func NewAsyncStorage(backend Storage, config AsyncConfig) *AsyncStorage {
+	if config.QueueSize <= 0 {
+		config.QueueSize = 1024
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 500
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = 10 * time.Millisecond
+	}
+
+	a := &AsyncStorage{
+		backend: backend,
+		config:  config,
+		units:   make(chan []Mutation, config.QueueSize),
+		flushes: make(chan chan struct{}),
+		quit:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+// DurableSeq is the sequence number of the last command the backend holds.
+func (a *AsyncStorage) DurableSeq() uint64 {
+	return a.durable.Load()
+}
+
+// Flush waits until everything queued so far is written and returns the
+// writer's error, if it is failing.
+func (a *AsyncStorage) Flush() error {
+	done := make(chan struct{})
+	select {
+	case a.flushes <- done:
+		<-done
+	case <-a.stopped:
+	}
+	return a.failure()
+}
+
+// Close writes out what is queued and stops the writer.
+func (a *AsyncStorage) Close() error {
+	a.closeOnce.Do(func() { close(a.quit) })
+	<-a.stopped
+	return a.failure()
+}
+
+func (a *AsyncStorage) failure() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.err
+}
+
+func (a *AsyncStorage) setFailure(err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.err = err
+}
+
+func (a *AsyncStorage) Begin(seq uint64) error {
+	a.seq, a.open, a.pending = seq, true, nil
+	return nil
+}
+
+func (a *AsyncStorage) Commit() error {
+	unit := a.pending
+	a.open, a.pending = false, nil
+	if len(unit) == 0 {
+		return nil
+	}
+	if err := a.failure(); err != nil && unit[0].Op != OpReset {
+		return err
+	}
+	return a.enqueue(unit)
+}
+
+func (a *AsyncStorage) Rollback() error {
+	a.open, a.pending = false, nil
+	return nil
+}
+
+func (a *AsyncStorage) enqueue(unit []Mutation) error {
+	select {
+	case a.units <- unit:
+		return nil
+	case <-a.quit:
+		return errAsyncClosed
+	}
+}
+
+func (a *AsyncStorage) write(m Mutation) error {
+	m.Seq = a.seq
+	if a.open {
+		a.pending = append(a.pending, m)
+		return nil
+	}
+	return a.enqueue([]Mutation{m})
+}
+
+func (a *AsyncStorage) ResetOrderBook() error {
+	return a.write(Mutation{Op: OpReset})
+}
+
+// RestoreOrderBook flushes the queue first, so the backend is up to date.
+func (a *AsyncStorage) RestoreOrderBook() (*OrderBook, error) {
+	if err := a.Flush(); err != nil {
+		return nil, err
+	}
+	return a.backend.RestoreOrderBook()
+}
+
+func (a *AsyncStorage) InsertLevel(side Side, l *LevelDTO) error {
+	return a.write(Mutation{Op: OpInsertLevel, Side: side, Level: l})
+}
+
+func (a *AsyncStorage) InsertTrade(t *Trade) error {
+	trade := *t
+	return a.write(Mutation{Op: OpInsertTrade, Trade: &trade})
+}
+
+func (a *AsyncStorage) InsertOrder(o *OrderDTO) error {
+	return a.write(Mutation{Op: OpInsertOrder, Order: o})
+}
+
+func (a *AsyncStorage) DeleteOrder(o *OrderDTO) error {
+	return a.write(Mutation{Op: OpDeleteOrder, Order: o})
+}
+
+func (a *AsyncStorage) UpdateOrder(o *OrderDTO) error {
+	return a.write(Mutation{Op: OpUpdateOrder, Order: o})
+}
+
+func (a *AsyncStorage) InsertStopOrder(o *OrderDTO) error {
+	return a.write(Mutation{Op: OpInsertStopOrder, Order: o})
+}
+
+func (a *AsyncStorage) DeleteStopOrder(o *OrderDTO) error {
+	return a.write(Mutation{Op: OpDeleteStopOrder, Order: o})
+}
+
+func (a *AsyncStorage) run() {
+	defer close(a.stopped)
+	ticker := time.NewTicker(a.config.FlushInterval)
+	defer ticker.Stop()
+
+	var batch []Mutation
+	add := func(unit []Mutation) {
+		// A reset wipes the book in storage, so whatever is still
+		// waiting, even a failed batch, need not be written.
+		if unit[0].Op == OpReset {
+			batch = nil
+		}
+		batch = append(batch, unit...)
+	}
+	drain := func() {
+		for {
+			select {
+			case unit := <-a.units:
+				add(unit)
+			default:
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case unit := <-a.units:
+			add(unit)
+			if len(batch) >= a.config.BatchSize && a.failure() == nil {
+				batch = a.flush(batch)
+			}
+		case <-ticker.C:
+			batch = a.flush(batch)
+		case done := <-a.flushes:
+			drain()
+			batch = a.flush(batch)
+			close(done)
+		case <-a.quit:
+			drain()
+			a.flush(batch)
+			return
+		}
+	}
+}
+
+// flush writes batch and returns what is left to write: nothing, or the
+// whole batch if it failed.
+func (a *AsyncStorage) flush(batch []Mutation) []Mutation {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	if err := WriteBatch(a.backend, batch); err != nil {
+		err = fmt.Errorf("%w: batch of %d: %w", ErrStorage, len(batch), err)
+		if a.failure() == nil {
+			Logger.Printf("Async storage failed, retrying: %s", err)
+		}
+		a.setFailure(err)
+		return batch
+	}
+
+	if a.failure() != nil {
+		Logger.Printf("Async storage recovered")
+	}
+	a.setFailure(nil)
+	a.durable.Store(batch[len(batch)-1].Seq)
+	return nil
+}
@@ -0,0 +1,67 @@
+package engine
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAsyncStorageWritesWhatSyncStorageWrites(t *testing.T) {
+	sync := &failingStorage{}
+	backend := &failingStorage{}
+	async := NewAsyncStorage(backend, AsyncConfig{BatchSize: 4, FlushInterval: time.Hour})
+	defer async.Close()
+
+	for _, storage := range []Storage{sync, async} {
+		ob := NewOrderBook()
+		ob.SetIDGenerator(&SequentialIDs{})
+		ob.AddStorage(storage)
+		ob.ProcessOrder(Sell, 40, 1)
+		ob.ProcessOrder(Sell, 41, 2)
+		ob.PlaceOrder(OrderRequest{Side: Buy, Type: StopLimit, StopPrice: 41, Price: 42, Size: 1})
+		ob.ProcessOrder(Buy, 41, 2)
+
+		if storage == async {
+			if err := async.Flush(); err != nil || async.DurableSeq() != ob.Seq() || ob.DurableSeq() != ob.Seq() {
+				t.Fatalf("tests - flush should make every command durable. expected=%d, got=%d (%v)", ob.Seq(), async.DurableSeq(), err)
+			}
+		}
+	}
+
+	if !reflect.DeepEqual(backend.committed, sync.committed) {
+		t.Fatalf("tests - async storage should make the same writes. expected=%v, got=%v", sync.committed, backend.committed)
+	}
+}
+
+func TestAsyncStorageFailureDegradesUntilResync(t *testing.T) {
+	backend := &failingStorage{failOn: map[string]bool{"InsertTrade": true}}
+	async := NewAsyncStorage(backend, AsyncConfig{FlushInterval: time.Hour})
+	defer async.Close()
+
+	ob := NewOrderBook()
+	ob.AddStorage(async)
+	ob.ProcessOrder(Sell, 40, 1)
+	ob.ProcessOrder(Buy, 40, 1)
+
+	if err := async.Flush(); !errors.Is(err, ErrStorage) || async.DurableSeq() != 0 {
+		t.Fatalf("tests - failed batch should not be durable. expected=%v, got=%v", ErrStorage, err)
+	}
+
+	if _, err := ob.ProcessOrder(Buy, 30, 1); !errors.Is(err, ErrDegraded) {
+		t.Fatalf("tests - writer failure should degrade the book. expected=%v, got=%v", ErrDegraded, err)
+	}
+
+	backend.failOn = nil
+	if err := ob.Resync(); err != nil {
+		t.Fatalf("tests - resync should be accepted. expected=%v, got=%v", nil, err)
+	}
+	if err := async.Flush(); err != nil || async.DurableSeq() != ob.Seq() {
+		t.Fatalf("tests - resync should be written. expected=%d, got=%d (%v)", ob.Seq(), async.DurableSeq(), err)
+	}
+
+	expected := []string{"InsertLevel", "InsertOrder", "InsertTrade"}
+	if !reflect.DeepEqual(backend.committed, expected) {
+		t.Fatalf("tests - failed batch should be superseded by the resync. expected=%v, got=%v", expected, backend.committed)
+	}
+}
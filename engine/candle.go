@@ -0,0 +1,371 @@
+package engine
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Interval is a candle width, named like "1m".
+type Interval struct {
+	Name     string
+	Duration time.Duration
+}
+
+// DefaultIntervals are the candles kept unless configured otherwise.
+const DefaultIntervals = "1s,1m,5m,1h,1d"
+
+// ParseInterval reads a whole number of seconds, minutes, hours or days:
+// "1s", "5m", "1h", "1d".
+func ParseInterval(name string) (Interval, error) {
+	duration, err := time.ParseDuration(name)
+	if days, ok := strings.CutSuffix(name, "d"); ok {
+		var n int
+		n, err = strconv.Atoi(days)
+		duration = time.Duration(n) * 24 * time.Hour
+	}
+	if err != nil || duration < time.Second || duration%time.Second != 0 {
+		return Interval{}, fmt.Errorf("invalid interval %q, use a whole number of s, m, h or d", name)
+	}
+	return Interval{Name: name, Duration: duration}, nil
+}
+
+// ParseIntervals reads a comma-separated list of intervals.
+func ParseIntervals(names string) ([]Interval, error) {
+	var intervals []Interval
+	for _, name := range strings.Split(names, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		interval, err := ParseInterval(name)
+		if err != nil {
+			return nil, err
+		}
+		intervals = append(intervals, interval)
+	}
+	return intervals, nil
+}
+
+// Candle sums up the trades of one symbol over one interval starting at
+// Start. Notional is the sum of price times size, from which VWAP follows.
+type Candle struct {
+	Symbol   string
+	Interval string
+	Start    time.Time
+	Open     int
+	High     int
+	Low      int
+	Close    int
+	Volume   int
+	Notional int
+	Trades   int
+}
+
+func (c *Candle) add(t Trade) {
+	if c.Trades == 0 {
+		c.Open, c.High, c.Low = t.Price, t.Price, t.Price
+	}
+	c.High = max(c.High, t.Price)
+	c.Low = min(c.Low, t.Price)
+	c.Close = t.Price
+	c.Volume += t.Size
+	c.Notional += t.Price * t.Size
+	c.Trades++
+}
+
+// VWAP is the volume-weighted average price.
+func (c *Candle) VWAP() float64 {
+	if c.Volume == 0 {
+		return 0
+	}
+	return float64(c.Notional) / float64(c.Volume)
+}
+
+// CandleQuery selects the latest Limit candles of one symbol and interval
+// starting in [From, To). Zero times leave that end open.
+type CandleQuery struct {
+	Symbol   string
+	Interval string
+	From, To time.Time
+	Limit    int
+}
+
+// CandleStore persists candles. SaveCandles replaces candles with the same
+// symbol, interval and start. LoadCandles returns them oldest first.
+type CandleStore interface {
+	SaveCandles(candles []Candle) error
+	LoadCandles(q CandleQuery) ([]Candle, error)
+	DeleteCandles(symbol, interval string) error
+}
+
+type candleKey struct {
+	symbol   string
+	interval string
+	start    time.Time
+}
+
+// CandleAggregator keeps the candles of every symbol up to date as trades
+// come in. Candles live in its store; the ones changed since the last
+// flush are held in memory until then.
+type CandleAggregator struct {
+	intervals []Interval
+	store     CandleStore
+
+	mu    sync.Mutex
+	open  map[candleKey]*Candle // the latest candle per symbol and interval, start left zero
+	dirty map[candleKey]*Candle
+
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+func NewCandleAggregator(store CandleStore, intervals []Interval) *CandleAggregator {
+	return &CandleAggregator{
+		intervals: intervals,
+		store:     store,
+		open:      make(map[candleKey]*Candle),
+		dirty:     make(map[candleKey]*Candle),
+	}
+}
+
+func (a *CandleAggregator) Intervals() []Interval {
+	return a.intervals
+}
+
+// AddTrades adds trades of symbol, in the order they were made.
+func (a *CandleAggregator) AddTrades(symbol string, trades []Trade) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, t := range trades {
+		for _, interval := range a.intervals {
+			a.add(symbol, interval, t)
+		}
+	}
+}
+
+func (a *CandleAggregator) add(symbol string, interval Interval, t Trade) {
+	start := t.Time.UTC().Truncate(interval.Duration)
+	latest := candleKey{symbol: symbol, interval: interval.Name}
+	candle := a.open[latest]
+	switch {
+	case candle == nil || start.After(candle.Start):
+		candle = &Candle{Symbol: symbol, Interval: interval.Name, Start: start}
+		a.open[latest] = candle
+	case start.Before(candle.Start):
+		Logger.Printf("Dropping %s trade %s from before the open %s candle", symbol, t.ID, interval.Name)
+		return
+	}
+	candle.add(t)
+	a.dirty[candleKey{symbol, interval.Name, start}] = candle
+}
+
+// Flush saves the candles changed since the last flush.
+func (a *CandleAggregator) Flush() error {
+	a.mu.Lock()
+	candles := make([]Candle, 0, len(a.dirty))
+	for _, candle := range a.dirty {
+		candles = append(candles, *candle)
+	}
+	a.dirty = make(map[candleKey]*Candle)
+	a.mu.Unlock()
+
+	if len(candles) == 0 {
+		return nil
+	}
+	if err := a.store.SaveCandles(candles); err != nil {
+		// Keep them for the next flush, unless they changed since.
+		a.mu.Lock()
+		for _, c := range candles {
+			key := candleKey{c.Symbol, c.Interval, c.Start}
+			if _, ok := a.dirty[key]; !ok {
+				candle := c
+				a.dirty[key] = &candle
+			}
+		}
+		a.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Candles answers q from the store and the candles not yet flushed.
+func (a *CandleAggregator) Candles(q CandleQuery) ([]Candle, error) {
+	candles, err := a.store.LoadCandles(q)
+	if err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	for key, candle := range a.dirty {
+		if key.symbol != q.Symbol || key.interval != q.Interval ||
+			(!q.From.IsZero() && key.start.Before(q.From)) || (!q.To.IsZero() && !key.start.Before(q.To)) {
+			continue
+		}
+		i, found := slices.BinarySearchFunc(candles, key.start, func(c Candle, start time.Time) int {
+			return c.Start.Compare(start)
+		})
+		if found {
+			candles[i] = *candle
+		} else {
+			candles = slices.Insert(candles, i, *candle)
+		}
+	}
+	a.mu.Unlock()
+
+	if q.Limit > 0 && len(candles) > q.Limit {
+		candles = candles[len(candles)-q.Limit:]
+	}
+	return candles, nil
+}
+
+// Rebuild recomputes the candles of symbol from its trade history, from
+// the start of its latest stored candle of each interval on, or from the
+// beginning with full. It must run before trades of symbol are added.
+func (a *CandleAggregator) Rebuild(symbol string, history TradeHistory, full bool) error {
+	if full {
+		for _, interval := range a.intervals {
+			if err := a.store.DeleteCandles(symbol, interval.Name); err != nil {
+				return err
+			}
+		}
+	}
+
+	since := make(map[string]time.Time)
+	var from time.Time
+	for i, interval := range a.intervals {
+		latest, err := a.store.LoadCandles(CandleQuery{Symbol: symbol, Interval: interval.Name, Limit: 1})
+		if err != nil {
+			return err
+		}
+		if len(latest) > 0 {
+			since[interval.Name] = latest[0].Start
+		}
+		if i == 0 || since[interval.Name].Before(from) {
+			from = since[interval.Name]
+		}
+	}
+
+	a.mu.Lock()
+	for _, interval := range a.intervals {
+		delete(a.open, candleKey{symbol: symbol, interval: interval.Name})
+	}
+	a.mu.Unlock()
+
+	// History pages in seq order, the order the trades were made.
+	q := TradeQuery{Symbol: symbol, From: from, Limit: 1000}
+	for {
+		trades, err := history.QueryTrades(q)
+		if err != nil {
+			return err
+		}
+		a.mu.Lock()
+		for _, t := range trades {
+			for _, interval := range a.intervals {
+				if !t.Time.Before(since[interval.Name]) {
+					a.add(symbol, interval, t)
+				}
+			}
+		}
+		a.mu.Unlock()
+
+		if len(trades) < q.Limit {
+			break
+		}
+		q.After = &TradeCursor{Seq: trades[len(trades)-1].Seq}
+	}
+	return a.Flush()
+}
+
+// Watch adds the trades of book, as symbol, from the sequencer as each
+// command makes them. Rebuild symbol first.
+func (a *CandleAggregator) Watch(symbol string, book *Sequencer) {
+	book.Watch(func(prev, next *Snapshot) {
+		a.AddTrades(symbol, NewTrades(prev.View, next.View))
+	})
+}
+
+// Start flushes every interval until Close.
+func (a *CandleAggregator) Start(interval time.Duration) {
+	a.stop = make(chan struct{})
+	a.stopped = make(chan struct{})
+	go func() {
+		defer close(a.stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := a.Flush(); err != nil {
+					Logger.Printf("Failed to save candles: %s", err)
+				}
+			case <-a.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops flushing and saves what is left.
+func (a *CandleAggregator) Close() error {
+	if a.stop != nil {
+		close(a.stop)
+		<-a.stopped
+		a.stop = nil
+	}
+	return a.Flush()
+}
+
+// MemoryCandleStore keeps candles in memory, for storage that has nowhere
+// else to put them. They are lost on restart.
+type MemoryCandleStore struct {
+	mu      sync.Mutex
+	candles map[candleKey]Candle
+}
+
+func (m *MemoryCandleStore) SaveCandles(candles []Candle) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.candles == nil {
+		m.candles = make(map[candleKey]Candle)
+	}
+	for _, c := range candles {
+		m.candles[candleKey{c.Symbol, c.Interval, c.Start}] = c
+	}
+	return nil
+}
+
+func (m *MemoryCandleStore) LoadCandles(q CandleQuery) ([]Candle, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	candles := []Candle{}
+	for key, c := range m.candles {
+		if key.symbol != q.Symbol || key.interval != q.Interval ||
+			(!q.From.IsZero() && key.start.Before(q.From)) || (!q.To.IsZero() && !key.start.Before(q.To)) {
+			continue
+		}
+		candles = append(candles, c)
+	}
+	slices.SortFunc(candles, func(a, b Candle) int { return a.Start.Compare(b.Start) })
+	if q.Limit > 0 && len(candles) > q.Limit {
+		candles = candles[len(candles)-q.Limit:]
+	}
+	return candles, nil
+}
+
+func (m *MemoryCandleStore) DeleteCandles(symbol, interval string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key := range m.candles {
+		if key.symbol == symbol && key.interval == interval {
+			delete(m.candles, key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestParseIntervals(t *testing.T) {
+	intervals, err := ParseIntervals(DefaultIntervals)
+	if err != nil {
+		t.Fatalf("tests - default intervals should parse. expected=%v, got=%v", nil, err)
+	}
+	expected := []time.Duration{time.Second, time.Minute, 5 * time.Minute, time.Hour, 24 * time.Hour}
+	for i, interval := range intervals {
+		if interval.Duration != expected[i] {
+			t.Fatalf("tests - wrong duration for %s. expected=%s, got=%s", interval.Name, expected[i], interval.Duration)
+		}
+	}
+
+	for _, name := range []string{"0s", "500ms", "1.5s", "d", "-1d", "1w"} {
+		if _, err := ParseInterval(name); err == nil {
+			t.Fatalf("tests - %s should be refused. expected=error, got=%v", name, nil)
+		}
+	}
+}
+
+func TestCandleAggregatorBuildsCandles(t *testing.T) {
+	intervals, _ := ParseIntervals("1m,5m")
+	store := &MemoryCandleStore{}
+	candles := NewCandleAggregator(store, intervals)
+
+	start := time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC)
+	trade := func(offset time.Duration, price, size int) Trade {
+		return Trade{ID: uuid.New(), Price: price, Size: size, Time: start.Add(offset)}
+	}
+	candles.AddTrades("BTC-USD", []Trade{
+		trade(0, 40, 1),
+		trade(10*time.Second, 44, 2),
+		trade(20*time.Second, 38, 1),
+		trade(30*time.Second, 41, 1),
+		trade(time.Minute, 45, 5),
+	})
+	// Older than the open candle, so it is dropped.
+	candles.AddTrades("BTC-USD", []Trade{trade(59*time.Second, 1, 1)})
+
+	// Unsaved candles are answered from memory.
+	got, err := candles.Candles(CandleQuery{Symbol: "BTC-USD", Interval: "1m"})
+	if err != nil || len(got) != 2 {
+		t.Fatalf("tests - wrong number of 1m candles. expected=%d, got=%d, %v", 2, len(got), err)
+	}
+	first := Candle{Symbol: "BTC-USD", Interval: "1m", Start: start, Open: 40, High: 44, Low: 38, Close: 41, Volume: 5, Notional: 40 + 88 + 38 + 41, Trades: 4}
+	if got[0] != first {
+		t.Fatalf("tests - wrong first candle. expected=%+v, got=%+v", first, got[0])
+	}
+	if vwap := got[0].VWAP(); vwap != 41.4 {
+		t.Fatalf("tests - wrong vwap. expected=%v, got=%v", 41.4, vwap)
+	}
+
+	if err := candles.Flush(); err != nil {
+		t.Fatalf("tests - flush failed. expected=%v, got=%v", nil, err)
+	}
+	candles.AddTrades("BTC-USD", []Trade{trade(time.Minute+time.Second, 47, 1)})
+
+	// Saved candles are overlaid with the ones changed since.
+	got, _ = candles.Candles(CandleQuery{Symbol: "BTC-USD", Interval: "1m", Limit: 1})
+	if len(got) != 1 || got[0].Close != 47 || got[0].Volume != 6 {
+		t.Fatalf("tests - latest candle should include the new trade. expected=%v, got=%+v", "close 47, volume 6", got)
+	}
+	stored, _ := store.LoadCandles(CandleQuery{Symbol: "BTC-USD", Interval: "5m"})
+	if len(stored) != 2 || stored[0].Start != start.Truncate(5*time.Minute) || stored[1].Volume != 5 {
+		t.Fatalf("tests - wrong stored 5m candles. expected=%v, got=%+v", "03:00 and 03:05", stored)
+	}
+}
+
+func TestCandleAggregatorWatchesBook(t *testing.T) {
+	intervals, _ := ParseIntervals("1m")
+	candles := NewCandleAggregator(&MemoryCandleStore{}, intervals)
+
+	ob := NewOrderBook()
+	start := time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC)
+	ob.SetClock(FixedClock(start))
+	book := NewSequencer(ob, 64)
+	defer book.Close()
+	candles.Watch("BTC-USD", book)
+
+	for _, price := range []int{52, 50, 51} {
+		book.ProcessOrder(Sell, price, 1)
+	}
+	book.ProcessOrder(Buy, 52, 3)
+
+	got, _ := candles.Candles(CandleQuery{Symbol: "BTC-USD", Interval: "1m"})
+	if len(got) != 1 {
+		t.Fatalf("tests - wrong number of candles. expected=%d, got=%d", 1, len(got))
+	}
+	if c := got[0]; c.Open != 50 || c.Close != 52 || c.Volume != 3 || c.Trades != 3 {
+		t.Fatalf("tests - candle should follow the sweep. expected=%v, got=%+v", "50 to 52, 3 trades", c)
+	}
+}
@@ -0,0 +1,270 @@
+package engine
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CheckReport lists what is wrong with a book: broken invariants in
+// memory, and differences between memory and what storage restores.
+type CheckReport struct {
+	Seq        uint64   `json:"seq"`
+	Invariants []string `json:"invariants"`
+	Storage    []string `json:"storage"`
+}
+
+func (r *CheckReport) OK() bool {
+	return len(r.Invariants) == 0 && len(r.Storage) == 0
+}
+
+// Check checks the book's invariants and compares it with what storage
+// restores. A book without storage is only checked in memory.
+func (ob *OrderBook) Check() (*CheckReport, error) {
+	report := &CheckReport{Seq: ob.seq, Invariants: ob.CheckInvariants(), Storage: []string{}}
+	if _, ok := ob.storage.(*NilStorage); ok {
+		return report, nil
+	}
+
+	stored, err := ob.storage.RestoreOrderBook()
+	if err != nil {
+		return nil, fmt.Errorf("%w: restore: %w", ErrStorage, err)
+	}
+	report.Storage = DiffBooks(ob.ToDTO(), stored.ToDTO())
+	for _, problem := range stored.CheckInvariants() {
+		report.Storage = append(report.Storage, "stored book: "+problem)
+	}
+	return report, nil
+}
+
+// CheckInvariants returns a description of everything wrong with the
+// book's structure: level volumes and counts that don't add up, broken
+// order and level links, stale best bid and ask, and a crossed book. An
+// empty result means the book is sound.
+func (ob *OrderBook) CheckInvariants() []string {
+	problems := []string{}
+	report := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	seen := make(map[uuid.UUID]bool)
+	for _, side := range []Side{Buy, Sell} {
+		better := func(a, b int) bool { return a > b }
+		best := ob.highestBid
+		if side == Sell {
+			better = func(a, b int) bool { return a < b }
+			best = ob.lowestAsk
+		}
+
+		var want *Level
+		for _, level := range ob.levels[side] {
+			if want == nil || better(level.Price, want.Price) {
+				want = level
+			}
+		}
+		if best != want {
+			report("%s best level is %s, should be %s", side, levelPrice(best), levelPrice(want))
+		}
+
+		linked := 0
+		for level, prev := best, (*Level)(nil); level != nil; prev, level = level, level.nextLevel {
+			if linked++; linked > len(ob.levels[side]) {
+				report("%s levels are linked in a loop", side)
+				break
+			}
+			if ob.levels[side][level.Price] != level {
+				report("%s level %d is linked but not in the book", side, level.Price)
+			}
+			if prev != nil && !better(prev.Price, level.Price) {
+				report("%s level %d is linked after %d", side, level.Price, prev.Price)
+			}
+		}
+		if linked < len(ob.levels[side]) {
+			report("%s has %d levels but only %d are linked", side, len(ob.levels[side]), linked)
+		}
+
+		for price, level := range ob.levels[side] {
+			if level.Price != price {
+				report("%s level %d is filed under %d", side, level.Price, price)
+			}
+			ob.checkLevel(side, level, seen, report)
+		}
+	}
+
+	for id, order := range ob.orders {
+		if !seen[id] {
+			report("order %s is not in a level", id)
+		}
+		if order.Id != id {
+			report("order %s is filed under %s", order.Id, id)
+		}
+	}
+
+	for _, order := range ob.stops.list() {
+		if _, ok := ob.orders[order.Id]; ok {
+			report("stop order %s is also resting", order.Id)
+		}
+	}
+
+	if ob.highestBid != nil && ob.lowestAsk != nil && ob.highestBid.Price >= ob.lowestAsk.Price {
+		report("book is crossed: bid %d, ask %d", ob.highestBid.Price, ob.lowestAsk.Price)
+	}
+
+	return problems
+}
+
+func (ob *OrderBook) checkLevel(side Side, level *Level, seen map[uuid.UUID]bool, report func(string, ...any)) {
+	volume, count := 0, 0
+	var prev *Order
+	for order := level.headOrder; order != nil; prev, order = order, order.nextOrder {
+		if seen[order.Id] {
+			report("order %s is linked twice", order.Id)
+			break
+		}
+		seen[order.Id] = true
+		count++
+		volume += order.Remaining
+
+		if ob.orders[order.Id] != order {
+			report("order %s in %s level %d is not in the book", order.Id, side, level.Price)
+		}
+		if order.prevOrder != prev {
+			report("order %s in %s level %d has a wrong previous order", order.Id, side, level.Price)
+		}
+		if order.parentLevel != level {
+			report("order %s in %s level %d points at another level", order.Id, side, level.Price)
+		}
+		if order.Side != side || order.Price != level.Price {
+			report("order %s (%s %d) is in %s level %d", order.Id, order.Side, order.Price, side, level.Price)
+		}
+		if order.Remaining <= 0 {
+			report("order %s in %s level %d has nothing remaining", order.Id, side, level.Price)
+		}
+	}
+
+	if level.tailOrder != prev {
+		report("%s level %d has a wrong tail order", side, level.Price)
+	}
+	if count == 0 {
+		report("%s level %d is empty", side, level.Price)
+	}
+	if level.Count != count {
+		report("%s level %d has count %d, orders add up to %d", side, level.Price, level.Count, count)
+	}
+	if level.Volume != volume {
+		report("%s level %d has volume %d, orders add up to %d", side, level.Price, level.Volume, volume)
+	}
+}
+
+func levelPrice(level *Level) string {
+	if level == nil {
+		return "none"
+	}
+	return fmt.Sprint(level.Price)
+}
+
+// DiffBooks returns the differences between two copies of a book, such as
+// the one in memory and the one restored from storage. Differences are
+// described as "want ..., got ...".
+func DiffBooks(want, got *OrderBookDTO) []string {
+	diffs := []string{}
+	report := func(format string, args ...any) {
+		diffs = append(diffs, fmt.Sprintf(format, args...))
+	}
+
+	for _, side := range []Side{Buy, Sell} {
+		for _, price := range unionKeys(want.Levels[side], got.Levels[side]) {
+			w, g := want.Levels[side][price], got.Levels[side][price]
+			switch {
+			case g == nil:
+				report("%s level %d: missing", side, price)
+			case w == nil:
+				report("%s level %d: unexpected", side, price)
+			default:
+				if w.Volume != g.Volume {
+					report("%s level %d: want volume %d, got %d", side, price, w.Volume, g.Volume)
+				}
+				if w.Count != g.Count {
+					report("%s level %d: want count %d, got %d", side, price, w.Count, g.Count)
+				}
+				if !slices.Equal(w.Orders, g.Orders) {
+					report("%s level %d: want orders %v, got %v", side, price, w.Orders, g.Orders)
+				}
+			}
+		}
+	}
+
+	diffOrders("order", want.Orders, got.Orders, report)
+	diffOrders("stop order", want.Stops, got.Stops, report)
+
+	if len(want.Trades) != len(got.Trades) {
+		report("trades: want %d, got %d", len(want.Trades), len(got.Trades))
+	}
+	for i := range min(len(want.Trades), len(got.Trades)) {
+		w, g := want.Trades[i], got.Trades[i]
+		if w.ID != g.ID || w.Seq != g.Seq || w.Price != g.Price || w.Size != g.Size ||
+			!w.Time.Truncate(time.Microsecond).Equal(g.Time.Truncate(time.Microsecond)) ||
+			w.BuyOrderID != g.BuyOrderID || w.SellOrderID != g.SellOrderID {
+			report("trade %d: want %s, got %s", i, w.ID, g.ID)
+			break
+		}
+	}
+
+	return diffs
+}
+
+func diffOrders(kind string, want, got map[uuid.UUID]*OrderDTO, report func(string, ...any)) {
+	for _, id := range unionKeys(want, got) {
+		w, g := want[id], got[id]
+		switch {
+		case g == nil:
+			report("%s %s: missing", kind, id)
+		case w == nil:
+			report("%s %s: unexpected", kind, id)
+		default:
+			for _, field := range []struct {
+				name      string
+				want, got any
+			}{
+				{"side", w.Side, g.Side},
+				{"type", w.Type, g.Type},
+				{"time in force", w.TimeInForce, g.TimeInForce},
+				{"post only", w.PostOnly, g.PostOnly},
+				{"size", w.Size, g.Size},
+				{"remaining", w.Remaining, g.Remaining},
+				{"display size", w.DisplaySize, g.DisplaySize},
+				{"hidden", w.Hidden, g.Hidden},
+				{"price", w.Price, g.Price},
+				{"stop price", w.StopPrice, g.StopPrice},
+			} {
+				if field.want != field.got {
+					report("%s %s: want %s %v, got %v", kind, id, field.name, field.want, field.got)
+				}
+			}
+			// Postgres keeps timestamps to the microsecond.
+			if !w.Time.Truncate(time.Microsecond).Equal(g.Time.Truncate(time.Microsecond)) {
+				report("%s %s: want time %s, got %s", kind, id, w.Time, g.Time)
+			}
+		}
+	}
+}
+
+// unionKeys returns the keys of both maps, sorted so reports are stable.
+func unionKeys[K int | uuid.UUID, V any](a, b map[K]V) []K {
+	var keys []K
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j])
+	})
+	return keys
+}
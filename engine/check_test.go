@@ -0,0 +1,97 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+)
+
+func checkedBook() *OrderBook {
+	ob := NewOrderBook()
+	ob.ProcessOrder(Buy, 40, 2)
+	ob.ProcessOrder(Buy, 40, 3)
+	ob.ProcessOrder(Buy, 39, 1)
+	ob.ProcessOrder(Sell, 42, 4)
+	ob.ProcessOrder(Sell, 43, 1)
+	ob.ProcessOrder(Sell, 40, 1)
+	return ob
+}
+
+func TestCheckInvariantsOnSoundBook(t *testing.T) {
+	ob := checkedBook()
+	if problems := ob.CheckInvariants(); len(problems) != 0 {
+		t.Fatalf("tests - sound book should pass. expected=%v, got=%v", nil, problems)
+	}
+
+	if problems := ob.ToDTO().ToOrderBook().CheckInvariants(); len(problems) != 0 {
+		t.Fatalf("tests - restored book should pass. expected=%v, got=%v", nil, problems)
+	}
+}
+
+func TestCheckInvariantsFindsBrokenBook(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(ob *OrderBook)
+		problem string
+	}{
+		{"volume", func(ob *OrderBook) { ob.highestBid.Volume++ }, "BUY level 40 has volume 5"},
+		{"count", func(ob *OrderBook) { ob.lowestAsk.Count = 2 }, "SELL level 42 has count 2"},
+		{"best bid", func(ob *OrderBook) { ob.highestBid = ob.levels[Buy][39] }, "BUY best level is 39, should be 40"},
+		{"crossed", func(ob *OrderBook) { ob.lowestAsk.Price = 40; ob.levels[Sell][40] = ob.lowestAsk }, "book is crossed"},
+		{"unlinked", func(ob *OrderBook) { ob.highestBid.nextLevel = nil }, "BUY has 2 levels but only 1 are linked"},
+		{"tail", func(ob *OrderBook) { ob.highestBid.tailOrder = ob.highestBid.headOrder }, "BUY level 40 has a wrong tail order"},
+		{"lost order", func(ob *OrderBook) {
+			delete(ob.orders, ob.lowestAsk.headOrder.Id)
+		}, "is not in the book"},
+	}
+
+	for _, test := range tests {
+		ob := checkedBook()
+		test.corrupt(ob)
+		problems := ob.CheckInvariants()
+		if !containsProblem(problems, test.problem) {
+			t.Fatalf("tests - %s should be found. expected=%q, got=%v", test.name, test.problem, problems)
+		}
+	}
+}
+
+func TestCheckComparesWithStorage(t *testing.T) {
+	ob := checkedBook()
+	stored := ob.ToDTO()
+	ob.AddStorage(&restoringStorage{book: stored})
+
+	report, err := ob.Check()
+	if err != nil || !report.OK() {
+		t.Fatalf("tests - book matching storage should pass. expected=%v, got=%+v, %v", nil, report, err)
+	}
+
+	stored = ob.ToDTO()
+	stored.Levels[Buy][40].Volume = 7
+	order := stored.Orders[stored.Levels[Sell][42].Orders[0]]
+	order.Remaining = 1
+	stored.Trades = nil
+	ob.AddStorage(&restoringStorage{book: stored})
+
+	report, err = ob.Check()
+	if err != nil {
+		t.Fatalf("tests - check should restore from storage. expected=%v, got=%v", nil, err)
+	}
+	for _, problem := range []string{
+		"BUY level 40: want volume 4, got 7",
+		"want remaining 4, got 1",
+		"trades: want 1, got 0",
+		"stored book: BUY level 40 has volume 7",
+	} {
+		if !containsProblem(report.Storage, problem) {
+			t.Fatalf("tests - storage difference should be found. expected=%q, got=%v", problem, report.Storage)
+		}
+	}
+}
+
+func containsProblem(problems []string, problem string) bool {
+	for _, p := range problems {
+		if strings.Contains(p, problem) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package engine
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Clock tells the engine the time for order and trade timestamps.
+type Clock interface {
+	Now() time.Time
+}
+
+// IDGenerator hands out the ids of new orders and trades.
+type IDGenerator interface {
+	NewID() uuid.UUID
+}
+
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now().UTC()
+}
+
+// FixedClock always reads the same time.
+type FixedClock time.Time
+
+func (c FixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+// TickingClock reads Start, then a nanosecond later on every call.
+// Commands run on one started at the time in their journal entry, so the
+// timestamps they produce keep the order things happened in, and a replay
+// produces the same ones.
+type TickingClock struct {
+	Start time.Time
+	ticks int64
+}
+
+func (c *TickingClock) Now() time.Time {
+	now := c.Start.Add(time.Duration(c.ticks))
+	c.ticks++
+	return now
+}
+
+type RandomIDs struct{}
+
+func (RandomIDs) NewID() uuid.UUID {
+	return uuid.New()
+}
+
+// SequentialIDs derives ids from a namespace and a counter, so the same
+// namespace always yields the same ids in the same order.
+type SequentialIDs struct {
+	Namespace uuid.UUID
+	next      uint64
+}
+
+func (s *SequentialIDs) NewID() uuid.UUID {
+	var name [8]byte
+	binary.BigEndian.PutUint64(name[:], s.next)
+	s.next++
+	return uuid.NewSHA1(s.Namespace, name[:])
+}
@@ -0,0 +1,41 @@
+package engine
+
+import "github.com/google/uuid"
+
+// closedOrderLimit is how many closed orders a book remembers.
+const closedOrderLimit = 100_000
+
+// closedOrders remembers the orders that left the book, so their final
+// state can be looked up, up to a limit. Past it the order closed longest
+// ago is forgotten first. It lives in memory only: a restored book has
+// forgotten every order closed before.
+type closedOrders struct {
+	limit  int
+	orders map[uuid.UUID]*Order
+	ids    []uuid.UUID // in the order they closed, from next on
+	next   int
+}
+
+func newClosedOrders(limit int) *closedOrders {
+	return &closedOrders{
+		limit:  limit,
+		orders: make(map[uuid.UUID]*Order),
+	}
+}
+
+func (co *closedOrders) add(order *Order) {
+	if _, ok := co.orders[order.Id]; !ok {
+		if len(co.ids) < co.limit {
+			co.ids = append(co.ids, order.Id)
+		} else {
+			delete(co.orders, co.ids[co.next])
+			co.ids[co.next] = order.Id
+			co.next = (co.next + 1) % co.limit
+		}
+	}
+	co.orders[order.Id] = order
+}
+
+func (co *closedOrders) get(id uuid.UUID) *Order {
+	return co.orders[id]
+}
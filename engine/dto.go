@@ -2,53 +2,79 @@ package engine
 
 import (
 	"math"
+	"sort"
 	"time"
 
 	"github.com/google/uuid"
 )
 
 type OrderBookDTO struct {
-	Levels     map[Side]map[int]*LevelDTO `json:"levels"`
-	Orders 	   map[uuid.UUID]*OrderDTO `json:"orders"`
-	Trades     []Trade `json:"trades"`
+	Levels map[Side]map[int]*LevelDTO `json:"levels"`
+	Orders map[uuid.UUID]*OrderDTO    `json:"orders"`
+	Stops  map[uuid.UUID]*OrderDTO    `json:"stops"`
+	Trades []Trade                    `json:"trades"`
 }
 
 type LevelDTO struct {
-	Price   int       `json:"price"`
-	Volume  int       `json:"volume"`
-	Count   int       `json:"count"`
-	Orders  []uuid.UUID `json:"orders"`
-	TailOrder uuid.UUID `json:"tailOrder"`
+	Price     int         `json:"price"`
+	Volume    int         `json:"volume"`
+	Count     int         `json:"count"`
+	Orders    []uuid.UUID `json:"orders"`
+	TailOrder uuid.UUID   `json:"tailOrder"`
 }
 
 type OrderDTO struct {
-	Id        uuid.UUID `json:"id"`
-	Side      Side      `json:"side"`
-	Size      int       `json:"size"`
-	Remaining int       `json:"remaining"`
-	Price     int       `json:"price"`
-	Time      time.Time `json:"time"`
-	NextID    *uuid.UUID `json:"next_id,omitempty"`
-	PrevID    *uuid.UUID `json:"prev_id,omitempty"`
+	Id          uuid.UUID   `json:"id"`
+	Side        Side        `json:"side"`
+	Type        OrderType   `json:"type"`
+	TimeInForce TimeInForce `json:"time_in_force"`
+	PostOnly    bool        `json:"post_only,omitempty"`
+	Size        int         `json:"size"`
+	Remaining   int         `json:"remaining"`
+	DisplaySize int         `json:"display_size,omitempty"`
+	Hidden      int         `json:"hidden,omitempty"`
+	Price       int         `json:"price"`
+	StopPrice   int         `json:"stop_price,omitempty"`
+	Time        time.Time   `json:"time"`
+	NextID      *uuid.UUID  `json:"next_id,omitempty"`
+	PrevID      *uuid.UUID  `json:"prev_id,omitempty"`
 }
 
 func (dto *OrderBookDTO) ToOrderBook() *OrderBook {
 	ob := &OrderBook{
-		levels: map[Side]map[int]*Level{Buy: {}, Sell: {}},
-		orders: make(map[uuid.UUID]*Order),
-		trades: dto.Trades,
+		levels:     map[Side]map[int]*Level{Buy: {}, Sell: {}},
+		orders:     make(map[uuid.UUID]*Order),
+		stops:      newStopBook(),
+		closed:     newClosedOrders(closedOrderLimit),
+		instrument: DefaultInstrument(""),
+		clock:      SystemClock{},
+		ids:        RandomIDs{},
+		trades:     dto.Trades,
 	}
 
 	for id, odto := range dto.Orders {
-		o := &Order{
-			Id:        odto.Id,
-			Side:      odto.Side,
-			Size:      odto.Size,
-			Remaining: odto.Remaining,
-			Price:     odto.Price,
-			Time:      odto.Time,
+		ob.orders[id] = odto.toOrder()
+	}
+
+	// Fills aren't stored, but every trade is, in the order it was made.
+	for _, t := range dto.Trades {
+		if o := ob.orders[t.BuyOrderID]; o != nil {
+			o.Fills = append(o.Fills, t.ID)
+		}
+		if o := ob.orders[t.SellOrderID]; o != nil {
+			o.Fills = append(o.Fills, t.ID)
 		}
-		ob.orders[id] = o
+	}
+
+	stops := make([]*Order, 0, len(dto.Stops))
+	for _, odto := range dto.Stops {
+		stops = append(stops, odto.toOrder())
+	}
+	sort.Slice(stops, func(i, j int) bool {
+		return stops[i].Time.Before(stops[j].Time)
+	})
+	for _, o := range stops {
+		ob.stops.add(o)
 	}
 
 	for id, odto := range dto.Orders {
@@ -79,13 +105,18 @@ func (dto *OrderBookDTO) ToOrderBook() *OrderBook {
 				if ob.lowestAsk == nil {
 					ob.lowestAsk = lvl
 				} else if lvl.Price < ob.lowestAsk.Price {
-					ob. lowestAsk = lvl
+					ob.lowestAsk = lvl
 				}
 			}
 
 			var prev *Order
 			for _, oid := range ldto.Orders {
 				o := ob.orders[oid]
+				if o == nil {
+					// Storage lost the order; leave it out rather than
+					// crash, and let the checks report the level.
+					continue
+				}
 				if prev == nil {
 					lvl.headOrder = o
 				} else {
@@ -101,6 +132,9 @@ func (dto *OrderBookDTO) ToOrderBook() *OrderBook {
 		}
 	}
 
+	linkLevels(ob.levels[Buy], func(a, b int) bool { return a > b })
+	linkLevels(ob.levels[Sell], func(a, b int) bool { return a < b })
+
 	highestBidPrice := math.MinInt
 	var highestBid *Level
 	for price, level := range ob.levels[Buy] {
@@ -112,7 +146,6 @@ func (dto *OrderBookDTO) ToOrderBook() *OrderBook {
 	}
 	ob.highestBid = highestBid
 
-
 	lowestAskPrice := math.MaxInt
 	var lowestAsk *Level
 	for price, level := range ob.levels[Sell] {
@@ -123,6 +156,37 @@ func (dto *OrderBookDTO) ToOrderBook() *OrderBook {
 	}
 	ob.lowestAsk = lowestAsk
 
-
 	return ob
 }
+
+func (odto *OrderDTO) toOrder() *Order {
+	return &Order{
+		Id:          odto.Id,
+		Side:        odto.Side,
+		Type:        odto.Type,
+		TimeInForce: odto.TimeInForce,
+		PostOnly:    odto.PostOnly,
+		Size:        odto.Size,
+		Remaining:   odto.Remaining,
+		DisplaySize: odto.DisplaySize,
+		Hidden:      odto.Hidden,
+		Price:       odto.Price,
+		StopPrice:   odto.StopPrice,
+		Time:        odto.Time,
+	}
+}
+
+// linkLevels rebuilds the nextLevel chain of one side, best price first.
+func linkLevels(levels map[int]*Level, better func(a, b int) bool) {
+	prices := make([]int, 0, len(levels))
+	for price := range levels {
+		prices = append(prices, price)
+	}
+	sort.Slice(prices, func(i, j int) bool {
+		return better(prices[i], prices[j])
+	})
+
+	for i := 0; i+1 < len(prices); i++ {
+		levels[prices[i]].nextLevel = levels[prices[i+1]]
+	}
+}
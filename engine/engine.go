@@ -3,7 +3,7 @@ package engine
 import (
 	"fmt"
 	"log"
-	"time"
+	"sort"
 
 	"github.com/google/uuid"
 )
@@ -16,7 +16,17 @@ type OrderBook struct {
 	lowestAsk  *Level
 	highestBid *Level
 	trades     []Trade
+	stops      *stopBook
+	closed     *closedOrders
+	instrument Instrument
 	storage    Storage
+	journal    Journal
+	snapshots  SnapshotStore
+	clock      Clock
+	ids        IDGenerator
+	seq        uint64
+	written    bool
+	degraded   error
 }
 
 type Side int
@@ -40,9 +50,14 @@ func NewOrderBook() *OrderBook {
 	}
 
 	ob := &OrderBook{
-		levels: levels,
-		orders: make(map[uuid.UUID]*Order),
-		storage: &NilStorage{},
+		levels:     levels,
+		orders:     make(map[uuid.UUID]*Order),
+		stops:      newStopBook(),
+		closed:     newClosedOrders(closedOrderLimit),
+		instrument: DefaultInstrument(""),
+		storage:    &NilStorage{},
+		clock:      SystemClock{},
+		ids:        RandomIDs{},
 	}
 
 	return ob
@@ -52,25 +67,97 @@ func (ob *OrderBook) AddStorage(storage Storage) {
 	ob.storage = storage
 }
 
+// SetClock and SetIDGenerator replace the sources of timestamps and ids,
+// which otherwise are the system clock and random UUIDs.
+func (ob *OrderBook) SetClock(clock Clock) {
+	ob.clock = clock
+}
+
+func (ob *OrderBook) SetIDGenerator(ids IDGenerator) {
+	ob.ids = ids
+}
+
+// SetInstrument sets the trading rules incoming orders are checked
+// against.
+func (ob *OrderBook) SetInstrument(instrument Instrument) {
+	ob.instrument = instrument.WithDefaults()
+}
+
+func (ob *OrderBook) Instrument() Instrument {
+	return ob.instrument
+}
+
+// RestoreOrderBook rebuilds the book on startup. A book with a journal is
+// rebuilt from its latest snapshot and the journal after it; otherwise,
+// or if there is neither, it is loaded from storage. A journal that can't
+// be read leaves the book degraded, since entries appended after what
+// couldn't be read would not replay.
 func (ob *OrderBook) RestoreOrderBook() {
+	var journalErr error
+	if ob.journal != nil {
+		entries, err := ob.journal.Entries()
+		if err != nil {
+			journalErr = fmt.Errorf("%w: journal: %w", ErrStorage, err)
+			Logger.Printf("Failed to read journal. Restoring from storage instead, order book is degraded. %s", err)
+		} else if ob.recover(entries) {
+			return
+		}
+	}
+
 	restoredOrderBook, err := ob.storage.RestoreOrderBook()
 	if err != nil {
 		Logger.Printf("Failed to restore OrderBook from storage. Continue with new OrderBook. %s", err)
 	} else {
-		ob.levels = restoredOrderBook.levels
-		ob.orders = restoredOrderBook.orders
-		ob.trades = restoredOrderBook.trades
-		ob.highestBid = restoredOrderBook.highestBid
-		ob.lowestAsk = restoredOrderBook.lowestAsk
+		ob.load(restoredOrderBook)
+		if journalErr == nil {
+			ob.startJournal()
+		}
+	}
+	if journalErr != nil {
+		ob.degraded = journalErr
 	}
 }
 
+func (ob *OrderBook) load(restoredOrderBook *OrderBook) {
+	ob.levels = restoredOrderBook.levels
+	ob.orders = restoredOrderBook.orders
+	ob.trades = restoredOrderBook.trades
+	ob.stops = restoredOrderBook.stops
+	ob.closed = restoredOrderBook.closed
+	ob.highestBid = restoredOrderBook.highestBid
+	ob.lowestAsk = restoredOrderBook.lowestAsk
+}
+
+// ResetOrderBook empties the book, in storage first so a failure leaves
+// the book as it was. A reset that goes through also clears the degraded
+// state, since storage and memory are both empty again. Unlike other
+// commands it is accepted while the book is degraded.
 func (ob *OrderBook) ResetOrderBook() error {
+	ob.written = false
+	_, err := ob.execute(&JournalEntry{Type: ResetCommand})
+	return err
+}
+
+func (ob *OrderBook) resetOrderBook() error {
+	if err := ob.storage.ResetOrderBook(); err != nil {
+		err = fmt.Errorf("%w: reset: %w", ErrStorage, err)
+		if !ob.written {
+			return err
+		}
+		// Already journaled, so the reset stands and storage is behind.
+		ob.degraded = err
+	} else {
+		ob.degraded = nil
+	}
+
 	ob.levels = map[Side]map[int]*Level{Buy: {}, Sell: {}}
 	ob.orders = make(map[uuid.UUID]*Order)
 	ob.trades = []Trade{}
-	return ob.storage.ResetOrderBook()
-
+	ob.stops = newStopBook()
+	ob.closed = newClosedOrders(closedOrderLimit)
+	ob.highestBid = nil
+	ob.lowestAsk = nil
+	return ob.health()
 }
 
 func (ob *OrderBook) NewLevel(order *Order, side Side) *Level {
@@ -83,8 +170,8 @@ func (ob *OrderBook) NewLevel(order *Order, side Side) *Level {
 	}
 
 	order.parentLevel = newLevel
-	order.prevOrder = newLevel.tailOrder
-	newLevel.tailOrder.nextOrder = order
+	order.prevOrder = nil
+	order.nextOrder = nil
 
 	switch side {
 	case Buy:
@@ -120,6 +207,27 @@ func (ob *OrderBook) NewLevel(order *Order, side Side) *Level {
 	return newLevel
 }
 
+// unlinkLevel removes an empty level from the sorted chain of its side.
+func (ob *OrderBook) unlinkLevel(side Side, level *Level) {
+	delete(ob.levels[side], level.Price)
+
+	best := &ob.lowestAsk
+	if side == Buy {
+		best = &ob.highestBid
+	}
+
+	if *best == level {
+		*best = level.nextLevel
+		return
+	}
+	for current := *best; current != nil; current = current.nextLevel {
+		if current.nextLevel == level {
+			current.nextLevel = level.nextLevel
+			return
+		}
+	}
+}
+
 func (ob *OrderBook) createOrder(id uuid.UUID, side Side, price int, size int, remaining int) Order {
 	return Order{
 		Id:        id,
@@ -127,189 +235,495 @@ func (ob *OrderBook) createOrder(id uuid.UUID, side Side, price int, size int, r
 		Size:      size,
 		Remaining: remaining,
 		Price:     price,
-		Time:      time.Now().UTC(),
+		Time:      ob.clock.Now(),
 	}
 }
 
-func (ob *OrderBook) AddOrder(order Order) uuid.UUID {
+// AddOrder rests an order at the back of its price level. If storage
+// refuses the order it is taken off the book again and the error returned.
+func (ob *OrderBook) AddOrder(order Order) (uuid.UUID, error) {
 	level, ok := ob.levels[order.Side][order.Price]
 	if ok {
 		order.parentLevel = level
 		order.prevOrder = level.tailOrder
-		order.nextOrder = &order
+		order.nextOrder = nil
 		level.tailOrder.nextOrder = &order
 		level.tailOrder = &order
 		level.Volume += order.Remaining
 		level.Count++
 	} else {
 		newLevel := ob.NewLevel(&order, order.Side)
-		ob.storage.InsertLevel(order.Side, newLevel.ToDTO())
 		ob.levels[order.Side][newLevel.Price] = newLevel
+		err := ob.persist("insert level", func() error {
+			return ob.storage.InsertLevel(order.Side, newLevel.ToDTO())
+		})
+		if err != nil {
+			ob.detachOrder(order)
+			return uuid.Nil, err
+		}
 	}
 
 	ob.orders[order.Id] = &order
-	ob.storage.InsertOrder(order.ToDTO())
-	return order.Id
-
+	err := ob.persist("insert order", func() error {
+		return ob.storage.InsertOrder(order.ToDTO())
+	})
+	if err != nil {
+		delete(ob.orders, order.Id)
+		ob.detachOrder(order)
+		return uuid.Nil, err
+	}
+	return order.Id, nil
 }
 
-func (ob *OrderBook) RemoveOrder(order Order) *Order {
+// RemoveOrder takes a resting order off the book and returns the order now
+// at the head of its level, or nil if the level is gone. Storage is
+// written first, so on error the book is left as it was.
+func (ob *OrderBook) RemoveOrder(order Order) (*Order, error) {
+	err := ob.persist("delete order", func() error {
+		return ob.storage.DeleteOrder(order.ToDTO())
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	delete(ob.orders, order.Id)
+	return ob.detachOrder(order), nil
+}
+
+// detachOrder unlinks an order from its level's queue, dropping the level
+// once it is empty, and returns the order now at the head of the level.
+func (ob *OrderBook) detachOrder(order Order) *Order {
 	parentLevel := order.parentLevel
 	parentLevel.Volume -= order.Remaining
 	parentLevel.Count--
 
-	err := ob.storage.DeleteOrder(ob.ToDTO(), order.ToDTO())
-	if err != nil {
-		Logger.Fatalf("Failed to DeleteOrder: %s", err)
-	}
-
 	if parentLevel.Count > 0 {
-		if parentLevel.headOrder.Id == order.Id {
+		if order.prevOrder != nil {
+			order.prevOrder.nextOrder = order.nextOrder
+		} else {
 			parentLevel.headOrder = order.nextOrder
-		} else if parentLevel.tailOrder.Id == order.Id {
-			parentLevel.tailOrder = order.prevOrder
+		}
+		if order.nextOrder != nil {
+			order.nextOrder.prevOrder = order.prevOrder
 		} else {
-			A := order.prevOrder
-			C := order.nextOrder
-			A.nextOrder = C
-			C.prevOrder = A
+			parentLevel.tailOrder = order.prevOrder
 		}
 		return parentLevel.headOrder
-	} else {
-		delete(ob.levels[order.Side], order.parentLevel.Price)
-		if order.Side == Buy {
-			if ob.highestBid == parentLevel {
-				ob.highestBid = parentLevel.nextLevel
+	}
+
+	ob.unlinkLevel(order.Side, parentLevel)
+	return nil
+}
+
+// OrderRequest describes an incoming order before it is matched.
+// Price is ignored for market orders, and market orders never rest
+// whatever their TimeInForce.
+//
+// Stop and StopLimit orders wait off the book until a trade prints at or
+// through StopPrice, then enter as a market or limit order respectively.
+//
+// An order with a DisplaySize smaller than its Size is an iceberg: once it
+// rests, only DisplaySize of it is shown on the book and the rest is kept
+// in reserve.
+//
+// A PostOnly order never takes liquidity. If it would cross the touch it
+// is rejected, or, when Reprice is set, moved one tick behind the touch.
+type OrderRequest struct {
+	Side        Side        `json:"side"`
+	Type        OrderType   `json:"type"`
+	TimeInForce TimeInForce `json:"time_in_force"`
+	PostOnly    bool        `json:"post_only,omitempty"`
+	Reprice     bool        `json:"reprice,omitempty"`
+	Price       int         `json:"price"`
+	StopPrice   int         `json:"stop_price,omitempty"`
+	Size        int         `json:"size"`
+	DisplaySize int         `json:"display_size,omitempty"`
+}
+
+// OrderResult reports what happened to an incoming order. Unfilled is
+// the size left after matching; it rests on the book only when Resting
+// is set, otherwise it has been dropped. Rejected is set when a
+// fill-or-kill order could not be filled in full or a post-only order
+// would have crossed, and nothing traded. Price is the price the order
+// was accepted at, which differs from the request for repriced
+// post-only orders. Pending is set for stop orders waiting for their
+// trigger.
+type OrderResult struct {
+	Id       uuid.UUID `json:"id"`
+	Price    int       `json:"price"`
+	Filled   int       `json:"filled"`
+	Unfilled int       `json:"unfilled"`
+	Resting  bool      `json:"resting"`
+	Rejected bool      `json:"rejected"`
+	Pending  bool      `json:"pending"`
+}
+
+func (ob *OrderBook) ProcessOrder(incomingSide Side, incomingPrice int, incomingSize int) (uuid.UUID, error) {
+	result, err := ob.PlaceOrder(OrderRequest{
+		Side:  incomingSide,
+		Type:  Limit,
+		Price: incomingPrice,
+		Size:  incomingSize,
+	})
+	return result.Id, err
+}
+
+// PlaceOrder checks an incoming order against the instrument, returning a
+// *RejectError if it breaks the rules, and otherwise matches it, rests it
+// or parks it as a stop.
+//
+// A storage failure returns an error wrapping ErrStorage with the book
+// untouched, or one wrapping ErrDegraded if the order had already been
+// partly persisted; the result then still reports what happened in memory.
+func (ob *OrderBook) PlaceOrder(req OrderRequest) (OrderResult, error) {
+	if err := ob.begin(); err != nil {
+		return OrderResult{}, err
+	}
+	if err := ob.instrument.checkOrder(req); err != nil {
+		return OrderResult{}, err
+	}
+	return ob.execute(&JournalEntry{Type: PlaceCommand, Order: &req})
+}
+
+func (ob *OrderBook) placeOrder(req OrderRequest) (OrderResult, error) {
+	incomingOrder := ob.createOrder(ob.ids.NewID(), req.Side, req.Price, req.Size, req.Size)
+	incomingOrder.Type = req.Type
+	incomingOrder.TimeInForce = req.TimeInForce
+	incomingOrder.PostOnly = req.PostOnly
+	incomingOrder.StopPrice = req.StopPrice
+	if req.DisplaySize > 0 && req.DisplaySize < req.Size {
+		incomingOrder.DisplaySize = req.DisplaySize
+	}
+	if incomingOrder.Type == Market || incomingOrder.Type == Stop {
+		incomingOrder.Price = 0
+	}
+
+	if incomingOrder.isStop() {
+		err := ob.persist("insert stop order", func() error {
+			return ob.storage.InsertStopOrder(incomingOrder.ToDTO())
+		})
+		if err != nil {
+			return OrderResult{}, err
+		}
+		ob.stops.add(&incomingOrder)
+		return OrderResult{
+			Id:       incomingOrder.Id,
+			Price:    incomingOrder.Price,
+			Unfilled: incomingOrder.Size,
+			Pending:  true,
+		}, nil
+	}
+
+	firstTrade := len(ob.trades)
+	result, err := ob.executeOrder(&incomingOrder, req.Reprice)
+	if err != nil {
+		return OrderResult{}, err
+	}
+	if err := ob.activateStops(firstTrade); err != nil {
+		return OrderResult{}, err
+	}
+	return result, ob.health()
+}
+
+// executeOrder runs an active order through the book: post-only and
+// fill-or-kill checks, matching, and resting whatever is left if its
+// type and time-in-force allow it. It only fails if storage refused the
+// order's first write, in which case nothing has changed.
+func (ob *OrderBook) executeOrder(incomingOrder *Order, reprice bool) (OrderResult, error) {
+	rejected := OrderResult{
+		Id:       incomingOrder.Id,
+		Price:    incomingOrder.Price,
+		Unfilled: incomingOrder.Size,
+		Rejected: true,
+	}
+
+	if incomingOrder.PostOnly {
+		if incomingOrder.Type == Market {
+			ob.closeOrder(incomingOrder, Rejected)
+			return rejected, nil
+		}
+		if touch := ob.bestOpposite(incomingOrder.Side); touch != nil && incomingOrder.crosses(touch.Price) {
+			if !reprice {
+				ob.closeOrder(incomingOrder, Rejected)
+				return rejected, nil
 			}
-		} else {
-			if ob.lowestAsk == parentLevel {
-				ob.lowestAsk = parentLevel.nextLevel
+			incomingOrder.Price = ob.behindTouch(incomingOrder.Side, touch.Price)
+			if !ob.instrument.priceInRange(incomingOrder.Price) {
+				ob.closeOrder(incomingOrder, Rejected)
+				return rejected, nil
 			}
 		}
+	}
 
+	if incomingOrder.TimeInForce == FOK && ob.availableVolume(incomingOrder) < incomingOrder.Size {
+		ob.closeOrder(incomingOrder, Rejected)
+		return rejected, nil
 	}
-	return nil
-}
 
-func (ob *OrderBook) ProcessOrder(incomingSide Side, incomingPrice int, incomingSize int) uuid.UUID {
-	incomingOrderId := uuid.New()
-	incomingRemaining := incomingSize
-	incomingOrder := ob.createOrder(incomingOrderId, incomingSide, incomingPrice, incomingSize, incomingRemaining)
+	if err := ob.matchOrder(incomingOrder); err != nil {
+		return OrderResult{}, err
+	}
 
-	var currentBestLevel *Level
+	result := OrderResult{
+		Id:       incomingOrder.Id,
+		Price:    incomingOrder.Price,
+		Filled:   incomingOrder.Size - incomingOrder.Remaining,
+		Unfilled: incomingOrder.Remaining,
+	}
 
-	if incomingOrder.Side == Buy {
-		currentBestLevel = ob.lowestAsk
+	if incomingOrder.Remaining > 0 && incomingOrder.rests() {
+		incomingOrder.splitReserve()
+		if _, err := ob.AddOrder(*incomingOrder); err != nil {
+			return OrderResult{}, err
+		}
+		result.Resting = true
+	} else if incomingOrder.Remaining == 0 {
+		ob.closeOrder(incomingOrder, Filled)
 	} else {
-		currentBestLevel = ob.highestBid
+		ob.closeOrder(incomingOrder, Cancelled)
 	}
+	return result, nil
+}
 
-	if currentBestLevel == nil {
-		ob.AddOrder(incomingOrder)
-	} else {
-		var currentLevel *Level
-		for true {
-			if incomingOrder.Side == Buy {
-				currentLevel = ob.lowestAsk
-				if ob.lowestAsk == nil || incomingOrder.Price < ob.lowestAsk.Price {
-					break
-				}
-			} else {
-				currentLevel = ob.highestBid
-				if ob.highestBid == nil || incomingOrder.Price > ob.highestBid.Price {
-					break
-				}
+// closeOrder records an order that has left the book, or never rested on
+// it, so its final state can still be looked up for a while.
+func (ob *OrderBook) closeOrder(order *Order, status OrderStatus) {
+	order.Status = status
+	if status == Filled {
+		order.Remaining = 0
+		order.Hidden = 0
+	}
+	ob.closed.add(order)
+}
+
+// behindTouch returns the price one tick behind the opposite touch, the
+// most aggressive price a post-only order can rest at without crossing.
+func (ob *OrderBook) behindTouch(side Side, touchPrice int) int {
+	if side == Buy {
+		return touchPrice - ob.instrument.TickSize
+	}
+	return touchPrice + ob.instrument.TickSize
+}
+
+func (ob *OrderBook) bestOpposite(side Side) *Level {
+	if side == Buy {
+		return ob.lowestAsk
+	}
+	return ob.highestBid
+}
+
+// availableVolume sums the opposite side's volume the order could trade
+// against, stopping as soon as it covers the order's size. Only visible
+// volume counts; iceberg reserves are not relied on.
+func (ob *OrderBook) availableVolume(order *Order) int {
+	volume := 0
+	for level := ob.bestOpposite(order.Side); level != nil && order.crosses(level.Price); level = level.nextLevel {
+		volume += level.Volume
+		if volume >= order.Size {
+			break
+		}
+	}
+	return volume
+}
+
+// matchOrder fills the incoming order against the opposite side of the
+// book, best level first and in FIFO order within a level, until it is
+// filled, the opposite side is empty or its limit price no longer crosses.
+//
+// It only fails if storage refuses the first trade. After that, storage
+// failures degrade the book and matching carries on in memory.
+func (ob *OrderBook) matchOrder(incomingOrder *Order) error {
+	for incomingOrder.Remaining > 0 {
+		currentLevel := ob.bestOpposite(incomingOrder.Side)
+		if currentLevel == nil || !incomingOrder.crosses(currentLevel.Price) {
+			break
+		}
+
+		existingOrder := currentLevel.headOrder
+		for existingOrder != nil && incomingOrder.Remaining > 0 {
+			tradeSize := min(existingOrder.Remaining, incomingOrder.Remaining)
+			trade := ob.newTrade(incomingOrder, existingOrder, tradeSize)
+			err := ob.persist("insert trade", func() error {
+				return ob.storage.InsertTrade(&trade)
+			})
+			if err != nil {
+				return err
 			}
-			existingOrder := currentLevel.headOrder
-			for existingOrder != nil && incomingOrder.Remaining > 0 {
-				if existingOrder.Remaining <= incomingOrder.Remaining {
-					var trade Trade
-					if incomingOrder.Side == Buy {
-						trade = Trade{
-							ID:       uuid.New(),
-							Price:    existingOrder.Price,
-							Size:     existingOrder.Remaining,
-							Time:     time.Now().UTC(),
-							BuyOrderID:  incomingOrder.Id,
-							SellOrderID: existingOrder.Id,
-						}
-					} else {
-						trade = Trade{
-							ID:       uuid.New(),
-							Price:    existingOrder.Price,
-							Size:     existingOrder.Remaining,
-							Time:     time.Now().UTC(),
-							BuyOrderID:  existingOrder.Id,
-							SellOrderID: incomingOrder.Id,
-						}
-					}
-					ob.trades = append(ob.trades, trade)
-					ob.storage.InsertTrade(&trade)
-
-					incomingOrder.Remaining -= existingOrder.Remaining
-					existingOrder = ob.RemoveOrder(*existingOrder)
-				} else {
-					var trade Trade
-					if incomingOrder.Side == Buy {
-						trade = Trade{
-							ID:       uuid.New(),
-							Price:    existingOrder.Price,
-							Size:     incomingOrder.Remaining,
-							Time:     time.Now().UTC(),
-							BuyOrderID:  incomingOrder.Id,
-							SellOrderID: existingOrder.Id,
-						}
-					} else {
-						trade = Trade{
-							ID:       uuid.New(),
-							Price:    existingOrder.Price,
-							Size:     incomingOrder.Remaining,
-							Time:     time.Now().UTC(),
-							BuyOrderID:  existingOrder.Id,
-							SellOrderID: incomingOrder.Id,
-						}
-					}
-					ob.trades = append(ob.trades, trade)
-					ob.storage.InsertTrade(&trade)
-
-					existingOrder.parentLevel.Volume -= incomingOrder.Remaining
-					existingOrder.Remaining -= incomingOrder.Remaining
-					incomingOrder.Remaining = 0
-					ob.storage.UpdateOrder(ob.ToDTO(), existingOrder.ToDTO())
-				}
+			ob.trades = append(ob.trades, trade)
+			incomingOrder.Fills = append(incomingOrder.Fills, trade.ID)
+			existingOrder.Fills = append(existingOrder.Fills, trade.ID)
+
+			incomingOrder.Remaining -= tradeSize
+			if existingOrder.Remaining == tradeSize && existingOrder.Hidden > 0 {
+				existingOrder = ob.replenishOrder(*existingOrder)
+			} else if existingOrder.Remaining == tradeSize {
+				filledOrder := existingOrder
+				existingOrder, _ = ob.RemoveOrder(*existingOrder)
+				ob.closeOrder(filledOrder, Filled)
+			} else {
+				existingOrder.parentLevel.Volume -= tradeSize
+				existingOrder.Remaining -= tradeSize
+				ob.persist("update order", func() error {
+					return ob.storage.UpdateOrder(existingOrder.ToDTO())
+				})
 			}
+		}
+	}
+	return nil
+}
 
-			if currentLevel.Count == 0 {
-				delete(ob.levels[incomingOrder.Side], currentLevel.Price)
-				if incomingOrder.Side == Buy {
-					ob.lowestAsk = currentLevel.nextLevel
-					currentLevel = ob.lowestAsk
-				} else {
-					ob.highestBid = currentLevel.nextLevel
-					currentLevel = ob.highestBid
-				}
-			}
+// replenishOrder shows the next slice of an iceberg whose visible part has
+// just been filled. The slice joins the back of its level's queue with a
+// fresh timestamp, so it loses time priority. It returns the order now at
+// the head of the level. It only runs after a trade has been written, so
+// storage errors here degrade the book rather than fail.
+func (ob *OrderBook) replenishOrder(order Order) *Order {
+	ob.RemoveOrder(order)
+
+	slice := min(order.DisplaySize, order.Hidden)
+	order.Remaining = slice
+	order.Hidden -= slice
+	order.Time = ob.clock.Now()
+	ob.AddOrder(order)
+
+	return ob.levels[order.Side][order.Price].headOrder
+}
+
+func (ob *OrderBook) newTrade(incomingOrder *Order, existingOrder *Order, size int) Trade {
+	trade := Trade{
+		ID:    ob.ids.NewID(),
+		Seq:   1,
+		Price: existingOrder.Price,
+		Size:  size,
+		Time:  ob.clock.Now(),
+	}
+	if len(ob.trades) > 0 {
+		trade.Seq = ob.trades[len(ob.trades)-1].Seq + 1
+	}
+	if incomingOrder.Side == Buy {
+		trade.BuyOrderID = incomingOrder.Id
+		trade.SellOrderID = existingOrder.Id
+	} else {
+		trade.BuyOrderID = existingOrder.Id
+		trade.SellOrderID = incomingOrder.Id
+	}
+	return trade
+}
 
-			if incomingOrder.Remaining == 0 {
-				break
+// activateStops triggers stop orders against every trade from index
+// firstTrade on. Triggered orders are executed in arrival order, and the
+// trades they produce are checked in turn so stops can cascade. Trades
+// have been written by then, so storage errors degrade the book; an error
+// is still passed on like any other command's.
+func (ob *OrderBook) activateStops(firstTrade int) error {
+	for firstTrade < len(ob.trades) {
+		trades := ob.trades[firstTrade:]
+		firstTrade = len(ob.trades)
+
+		var triggered []*Order
+		for _, trade := range trades {
+			triggered = append(triggered, ob.stops.trigger(trade.Price)...)
+		}
+		sort.SliceStable(triggered, func(i, j int) bool {
+			return triggered[i].Time.Before(triggered[j].Time)
+		})
+
+		for _, order := range triggered {
+			err := ob.persist("delete stop order", func() error {
+				return ob.storage.DeleteStopOrder(order.ToDTO())
+			})
+			if err != nil {
+				return err
+			}
+			order.activate(ob.clock.Now())
+			if _, err := ob.executeOrder(order, false); err != nil {
+				return err
 			}
 		}
+	}
+	return nil
+}
 
-		if incomingOrder.Remaining > 0 {
-			ob.AddOrder(incomingOrder)
-		}
+// StopOrders lists the pending stop orders, oldest first.
+func (ob *OrderBook) StopOrders() []*OrderDTO {
+	stops := ob.stops.list()
+	dtos := make([]*OrderDTO, 0, len(stops))
+	for _, order := range stops {
+		dtos = append(dtos, order.ToDTO())
+	}
+	return dtos
+}
+
+func (ob *OrderBook) CancelStopOrder(id uuid.UUID) error {
+	if err := ob.begin(); err != nil {
+		return err
+	}
+
+	if ob.stops.orders[id] == nil {
+		return ErrOrderNotFound
 	}
-	return incomingOrder.Id
+	_, err := ob.execute(&JournalEntry{Type: CancelStopCommand, OrderID: &id})
+	return err
 }
 
-func (ob *OrderBook) CancelOrder(id uuid.UUID) bool {
+func (ob *OrderBook) cancelStopOrder(id uuid.UUID) error {
+	order := ob.stops.orders[id]
+	err := ob.persist("delete stop order", func() error {
+		return ob.storage.DeleteStopOrder(order.ToDTO())
+	})
+	if err != nil {
+		return err
+	}
+
+	ob.stops.remove(id)
+	ob.closeOrder(order, Cancelled)
+	return nil
+}
+
+// GetOrder returns a copy of a resting order.
+func (ob *OrderBook) GetOrder(id uuid.UUID) (*OrderDTO, bool) {
 	order := ob.orders[id]
 	if order == nil {
-		return false
+		return nil, false
 	}
-	ob.RemoveOrder(*order)
-	return true
+	return order.ToDTO(), true
+}
+
+func (ob *OrderBook) CancelOrder(id uuid.UUID) error {
+	if err := ob.begin(); err != nil {
+		return err
+	}
+
+	if ob.orders[id] == nil {
+		return ErrOrderNotFound
+	}
+	_, err := ob.execute(&JournalEntry{Type: CancelCommand, OrderID: &id})
+	return err
+}
+
+func (ob *OrderBook) cancelOrder(id uuid.UUID) error {
+	order := ob.orders[id]
+	if _, err := ob.RemoveOrder(*order); err != nil {
+		return err
+	}
+	ob.closeOrder(order, Cancelled)
+	return nil
+}
+
+// OrderStatus looks up an order wherever it is: resting on the book,
+// waiting as a stop, or already filled, cancelled or rejected, unless it
+// closed too long ago or before the book was restored.
+func (ob *OrderBook) OrderStatus(id uuid.UUID) (*OrderInfo, bool) {
+	if order := ob.orders[id]; order != nil {
+		return order.info(), true
+	}
+	if order := ob.stops.orders[id]; order != nil {
+		return order.info(), true
+	}
+	if order := ob.closed.get(id); order != nil {
+		return order.info(), true
+	}
+	return nil, false
 }
 
 func (ob *OrderBook) GetOrderBook() string {
@@ -360,9 +774,14 @@ func (ob *OrderBook) ToDTO() *OrderBookDTO {
 	dto := &OrderBookDTO{
 		Levels: map[Side]map[int]*LevelDTO{Buy: {}, Sell: {}},
 		Orders: make(map[uuid.UUID]*OrderDTO),
+		Stops:  make(map[uuid.UUID]*OrderDTO),
 		Trades: ob.trades,
 	}
 
+	for _, o := range ob.stops.list() {
+		dto.Stops[o.Id] = o.ToDTO()
+	}
+
 	for id, o := range ob.orders {
 		dto.Orders[id] = o.ToDTO()
 	}
@@ -376,17 +795,38 @@ func (ob *OrderBook) ToDTO() *OrderBookDTO {
 	return dto
 }
 
+// Storage persists a book. Begin opens the unit of work of the command
+// with sequence number seq, which every write joins until Commit or
+// Rollback, so a command is stored whole or not at all; writes made
+// outside one are stored on their own.
 type Storage interface {
+	Begin(seq uint64) error
+	Commit() error
+	Rollback() error
 	ResetOrderBook() error
 	RestoreOrderBook() (*OrderBook, error)
 	InsertLevel(side Side, l *LevelDTO) error
 	InsertTrade(t *Trade) error
 	InsertOrder(o *OrderDTO) error
-	DeleteOrder(ob *OrderBookDTO, o *OrderDTO) error
-	UpdateOrder(ob *OrderBookDTO, o *OrderDTO) error
+	DeleteOrder(o *OrderDTO) error
+	UpdateOrder(o *OrderDTO) error
+	InsertStopOrder(o *OrderDTO) error
+	DeleteStopOrder(o *OrderDTO) error
+}
+
+type NilStorage struct{}
+
+func (n *NilStorage) Begin(seq uint64) error {
+	return nil
+}
+
+func (n *NilStorage) Commit() error {
+	return nil
 }
 
-type NilStorage struct {}
+func (n *NilStorage) Rollback() error {
+	return nil
+}
 
 func (n *NilStorage) InsertLevel(side Side, l *LevelDTO) error {
 	return nil
@@ -400,11 +840,19 @@ func (n *NilStorage) InsertOrder(o *OrderDTO) error {
 	return nil
 
 }
-func (n *NilStorage) DeleteOrder(ob *OrderBookDTO, o *OrderDTO) error {
+func (n *NilStorage) DeleteOrder(o *OrderDTO) error {
+	return nil
+}
+
+func (n *NilStorage) UpdateOrder(o *OrderDTO) error {
+	return nil
+}
+
+func (n *NilStorage) InsertStopOrder(o *OrderDTO) error {
 	return nil
 }
 
-func (n *NilStorage) UpdateOrder(ob *OrderBookDTO, o *OrderDTO) error {
+func (n *NilStorage) DeleteStopOrder(o *OrderDTO) error {
 	return nil
 }
 
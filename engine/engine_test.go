@@ -1,9 +1,12 @@
 package engine
 
 import (
+	"encoding/json"
+	"errors"
 	"log"
 	"io"
 	"math/rand/v2"
+	"reflect"
 	"testing"
 
 	"github.com/google/uuid"
@@ -20,7 +23,7 @@ func TestAddingOrders(t *testing.T) {
 	for x := range 10 {
 		expectedSize := 1
 		o := ob.createOrder(uuid.New(), Buy, x, expectedSize, expectedSize)
-		id := ob.AddOrder(o)
+		id, _ := ob.AddOrder(o)
 
 		if ob.orders[id].Price != x {
 			t.Fatalf("tests - order.price wrong. expected=%+v, got=%+v", x, ob.orders[id].Price)
@@ -113,7 +116,7 @@ func TestMultiOrderLevel(t *testing.T) {
 	randomprice := rand.IntN(42) + (42 % 3)
 	for x := range n {
 		o := ob.createOrder(uuid.New(), Buy, randomprice, randomprice*(x+1), randomprice*(x+1))
-		id := ob.AddOrder(o)
+		id, _ := ob.AddOrder(o)
 		orders[x] = *ob.orders[id]
 	}
 
@@ -147,8 +150,8 @@ func TestRemoveTail(t *testing.T) {
 
 	o0 := ob.createOrder(uuid.New(), Buy, 1337, 1, 1)
 	o1 := ob.createOrder(uuid.New(), Buy, 1337, 2, 2)
-	id0 := ob.AddOrder(o0)
-	id1 := ob.AddOrder(o1) //The second order aka the tail is the one we remove
+	id0, _ := ob.AddOrder(o0)
+	id1, _ := ob.AddOrder(o1) //The second order aka the tail is the one we remove
 
 	order := ob.orders[id1]
 	ob.RemoveOrder(*order)
@@ -164,8 +167,8 @@ func TestRemoveHead(t *testing.T) {
 
 	o0 := ob.createOrder(uuid.New(), Buy, 7331, 1, 1) //The first order aka the head is the one we remove
 	o1 := ob.createOrder(uuid.New(), Buy, 7331, 2, 2)
-	id0 := ob.AddOrder(o0)
-	id1 := ob.AddOrder(o1)
+	id0, _ := ob.AddOrder(o0)
+	id1, _ := ob.AddOrder(o1)
 
 	order := ob.orders[id0]
 	ob.RemoveOrder(*order)
@@ -183,9 +186,9 @@ func TestRemoveMiddle(t *testing.T) {
 	o1 := ob.createOrder(uuid.New(), Buy, 7331, 1, 1) //The middle order is removed
 	o2 := ob.createOrder(uuid.New(), Buy, 7331, 2, 2)
 
-	id0 := ob.AddOrder(o0)
-	id1 := ob.AddOrder(o1) //The middle order is removed
-	id2 := ob.AddOrder(o2)
+	id0, _ := ob.AddOrder(o0)
+	id1, _ := ob.AddOrder(o1) //The middle order is removed
+	id2, _ := ob.AddOrder(o2)
 
 	order := ob.orders[id1]
 	ob.RemoveOrder(*order)
@@ -205,7 +208,7 @@ func TestRemoveOnlyOrderInLevel(t *testing.T) {
 	o0 := ob.createOrder(uuid.New(), Buy, 42, 9, 9)
 	o1 := ob.createOrder(uuid.New(), Buy, 41, 9, 9)
 
-	id0 := ob.AddOrder(o0)
+	id0, _ := ob.AddOrder(o0)
 	ob.AddOrder(o1)
 
 	order := ob.orders[id0]
@@ -224,22 +227,22 @@ func TestRemoveOnlyOrderInLevel(t *testing.T) {
 func TestCancelOrder(t *testing.T) {
 	ob := NewOrderBook()
 
-	id0 := ob.ProcessOrder(Sell, 40, 2)
+	id0, _ := ob.ProcessOrder(Sell, 40, 2)
 
-	isCanceled := ob.CancelOrder(id0)
+	err := ob.CancelOrder(id0)
 
 	if len(ob.orders) != 0 {
 		t.Fatalf("tests - order book should be empty. expected=%+v, got=%+v", 0, len(ob.orders))
 	}
 
-	if !isCanceled {
-		t.Fatalf("tests - Order should be canceled. expected=%t, got=%t", true, isCanceled)
+	if err != nil {
+		t.Fatalf("tests - Order should be canceled. expected=%v, got=%v", nil, err)
 	}
 
-	isCanceled = ob.CancelOrder(id0)
+	err = ob.CancelOrder(id0)
 
-	if isCanceled {
-		t.Fatalf("tests - Order should no longer exist. expected=%t, got=%t", false, isCanceled)
+	if err != ErrOrderNotFound {
+		t.Fatalf("tests - Order should no longer exist. expected=%v, got=%v", ErrOrderNotFound, err)
 	}
 
 }
@@ -247,7 +250,7 @@ func TestCancelOrder(t *testing.T) {
 func TestRemoveOnlyOrderInBook(t *testing.T) {
 	ob := NewOrderBook()
 
-	id0 := ob.ProcessOrder(Buy, 42, 9)
+	id0, _ := ob.ProcessOrder(Buy, 42, 9)
 
 	order := ob.orders[id0]
 	ob.RemoveOrder(*order)
@@ -265,8 +268,8 @@ func TestRemoveOnlyOrderInBook(t *testing.T) {
 func TestProcessPartialOrder(t *testing.T) {
 	ob := NewOrderBook()
 
-	id0 := ob.ProcessOrder(Buy, 42, 1)
-	id1 := ob.ProcessOrder(Sell, 40, 2)
+	id0, _ := ob.ProcessOrder(Buy, 42, 1)
+	id1, _ := ob.ProcessOrder(Sell, 40, 2)
 
 	o0 := ob.orders[id0]
 	o1 := ob.orders[id1]
@@ -287,8 +290,8 @@ func TestProcessPartialOrder(t *testing.T) {
 func TestProcessWholeOrder(t *testing.T) {
 	ob := NewOrderBook()
 
-	id0 := ob.ProcessOrder(Buy, 42, 2)
-	id1 := ob.ProcessOrder(Sell, 40, 2)
+	id0, _ := ob.ProcessOrder(Buy, 42, 2)
+	id1, _ := ob.ProcessOrder(Sell, 40, 2)
 
 	o0 := ob.orders[id0]
 	o1 := ob.orders[id1]
@@ -320,14 +323,14 @@ func TestProcessWholeOrder(t *testing.T) {
 func TestProcessMultiLevelOrder(t *testing.T) {
 	ob := NewOrderBook()
 
-	id0 := ob.ProcessOrder(Buy, 42, 3)
-	id1 := ob.ProcessOrder(Sell, 40, 2)
+	id0, _ := ob.ProcessOrder(Buy, 42, 3)
+	id1, _ := ob.ProcessOrder(Sell, 40, 2)
 
 	if ob.orders[id0].Remaining != 1 {
 		t.Fatalf("tests - order 0 should be partially filled."+" expected=%d, got=%+v", 1, ob.orders[id0].Remaining)
 	}
 
-	id2 := ob.ProcessOrder(Sell, 41, 1)
+	id2, _ := ob.ProcessOrder(Sell, 41, 1)
 
 	o0 := ob.orders[id0]
 	o1 := ob.orders[id1]
@@ -365,14 +368,14 @@ func TestProcessMultiLevelOrder(t *testing.T) {
 func TestProcessMultiLevelOrder2(t *testing.T) {
 	ob := NewOrderBook()
 
-	id0 := ob.ProcessOrder(Sell, 40, 2)
-	id1 := ob.ProcessOrder(Buy, 42, 3)
+	id0, _ := ob.ProcessOrder(Sell, 40, 2)
+	id1, _ := ob.ProcessOrder(Buy, 42, 3)
 
 	if ob.orders[id1].Remaining != 1 {
 		t.Fatalf("tests - order 1 should be partially filled."+" expected=%d, got=%+v", 1, ob.orders[id1].Remaining)
 	}
 
-	id2 := ob.ProcessOrder(Sell, 41, 1)
+	id2, _ := ob.ProcessOrder(Sell, 41, 1)
 
 	o0 := ob.orders[id0]
 	o1 := ob.orders[id1]
@@ -468,10 +471,9 @@ func TestProcessTrade2(t *testing.T) {
 func TestProcessTrade3(t *testing.T) {
 	ob := NewOrderBook()
 
-	orderIDs := []uuid.UUID{
-		ob.ProcessOrder(Sell, 85, 10),
-		ob.ProcessOrder(Buy, 88, 12),
-	}
+	id0, _ := ob.ProcessOrder(Sell, 85, 10)
+	id1, _ := ob.ProcessOrder(Buy, 88, 12)
+	orderIDs := []uuid.UUID{id0, id1}
 
 	var expectedTrades = []struct {
 		expectedPrice, expectedSize int
@@ -507,3 +509,922 @@ func TestProcessTrade3(t *testing.T) {
 		}
 	}
 }
+
+func TestMarketOrderSweepsLevels(t *testing.T) {
+	ob := NewOrderBook()
+
+	ob.ProcessOrder(Sell, 85, 2)
+	ob.ProcessOrder(Sell, 90, 3)
+	ob.ProcessOrder(Sell, 120, 4)
+
+	result, _ := ob.PlaceOrder(OrderRequest{Side: Buy, Type: Market, Size: 6})
+
+	var expectedTrades = []struct {
+		expectedPrice, expectedSize int
+	}{
+		{85, 2},
+		{90, 3},
+		{120, 1},
+	}
+
+	if len(ob.trades) != len(expectedTrades) {
+		t.Fatalf("tests - wrong number of trades. expected=%+v, got=%+v", len(expectedTrades), len(ob.trades))
+	}
+
+	for i, expectedTrade := range expectedTrades {
+		if ob.trades[i].Price != expectedTrade.expectedPrice {
+			t.Fatalf("tests - wrong trade price. expected=%+v, got=%+v", expectedTrade.expectedPrice, ob.trades[i].Price)
+		}
+		if ob.trades[i].Size != expectedTrade.expectedSize {
+			t.Fatalf("tests - wrong trade size. expected=%+v, got=%+v", expectedTrade.expectedSize, ob.trades[i].Size)
+		}
+	}
+
+	if result.Filled != 6 || result.Unfilled != 0 || result.Resting {
+		t.Fatalf("tests - wrong market order result. expected=%+v, got=%+v",
+			OrderResult{Id: result.Id, Filled: 6}, result)
+	}
+
+	if ob.lowestAsk == nil || ob.lowestAsk.Price != 120 || ob.lowestAsk.Volume != 3 {
+		t.Fatalf("tests - lowestAsk wrong after sweep. expected=%+v, got=%+v", 120, ob.lowestAsk)
+	}
+}
+
+func TestMarketOrderNeverRests(t *testing.T) {
+	ob := NewOrderBook()
+
+	ob.ProcessOrder(Buy, 42, 2)
+	ob.ProcessOrder(Buy, 40, 1)
+
+	result, _ := ob.PlaceOrder(OrderRequest{Side: Sell, Type: Market, Size: 5})
+
+	if result.Filled != 3 {
+		t.Fatalf("tests - wrong filled size. expected=%d, got=%d", 3, result.Filled)
+	}
+
+	if result.Unfilled != 2 {
+		t.Fatalf("tests - wrong unfilled remainder. expected=%d, got=%d", 2, result.Unfilled)
+	}
+
+	if result.Resting || ob.orders[result.Id] != nil {
+		t.Fatalf("tests - market order should never rest on the book. expected=%+v, got=%+v", nil, ob.orders[result.Id])
+	}
+
+	if len(ob.levels[Sell]) != 0 || len(ob.levels[Buy]) != 0 {
+		t.Fatalf("tests - book should be empty. expected=%+v, got=%+v", 0, len(ob.levels[Sell])+len(ob.levels[Buy]))
+	}
+
+	if ob.highestBid != nil || ob.lowestAsk != nil {
+		t.Fatalf("tests - best levels should be nil. expected=%+v, got=%+v %+v", nil, ob.highestBid, ob.lowestAsk)
+	}
+}
+
+func TestMarketOrderOnEmptyBook(t *testing.T) {
+	ob := NewOrderBook()
+
+	result, _ := ob.PlaceOrder(OrderRequest{Side: Buy, Type: Market, Size: 5})
+
+	if result.Unfilled != 5 || result.Filled != 0 {
+		t.Fatalf("tests - market order on empty book should be unfilled. expected=%d, got=%+v", 5, result)
+	}
+
+	if len(ob.orders) != 0 {
+		t.Fatalf("tests - order book should be empty. expected=%+v, got=%+v", 0, len(ob.orders))
+	}
+}
+
+func TestCancelledLevelLeavesChain(t *testing.T) {
+	ob := NewOrderBook()
+
+	ob.ProcessOrder(Sell, 85, 1)
+	id1, _ := ob.ProcessOrder(Sell, 86, 1)
+	ob.ProcessOrder(Sell, 87, 1)
+
+	ob.CancelOrder(id1)
+
+	if ob.lowestAsk.nextLevel != ob.levels[Sell][87] {
+		t.Fatalf("tests - cancelled level still linked. expected=%+v, got=%+v", ob.levels[Sell][87], ob.lowestAsk.nextLevel)
+	}
+
+	ob.PlaceOrder(OrderRequest{Side: Buy, Type: Market, Size: 2})
+
+	if len(ob.trades) != 2 || ob.trades[1].Price != 87 {
+		t.Fatalf("tests - market order should skip cancelled level. expected=%+v, got=%+v", 87, ob.trades)
+	}
+}
+
+func TestRestoredBookLinksLevels(t *testing.T) {
+	ob := NewOrderBook()
+
+	ob.ProcessOrder(Sell, 87, 1)
+	ob.ProcessOrder(Sell, 85, 1)
+	ob.ProcessOrder(Sell, 86, 1)
+
+	restored := ob.ToDTO().ToOrderBook()
+	restored.storage = &NilStorage{}
+
+	result, _ := restored.PlaceOrder(OrderRequest{Side: Buy, Type: Market, Size: 3})
+
+	if result.Filled != 3 {
+		t.Fatalf("tests - restored book should sweep all levels. expected=%d, got=%d", 3, result.Filled)
+	}
+
+	for i, expectedPrice := range []int{85, 86, 87} {
+		if restored.trades[i].Price != expectedPrice {
+			t.Fatalf("tests - wrong trade price. expected=%+v, got=%+v", expectedPrice, restored.trades[i].Price)
+		}
+	}
+}
+
+func TestImmediateOrCancelDropsRemainder(t *testing.T) {
+	ob := NewOrderBook()
+
+	ob.ProcessOrder(Sell, 85, 2)
+	ob.ProcessOrder(Sell, 90, 2)
+
+	result, _ := ob.PlaceOrder(OrderRequest{Side: Buy, Type: Limit, TimeInForce: IOC, Price: 88, Size: 5})
+
+	if result.Filled != 2 || result.Unfilled != 3 {
+		t.Fatalf("tests - wrong IOC result. expected=%d/%d, got=%+v", 2, 3, result)
+	}
+
+	if result.Resting || ob.orders[result.Id] != nil || len(ob.levels[Buy]) != 0 {
+		t.Fatalf("tests - IOC remainder should not rest. expected=%+v, got=%+v", nil, ob.orders[result.Id])
+	}
+
+	if ob.lowestAsk.Price != 90 {
+		t.Fatalf("tests - lowestAsk wrong. expected=%+v, got=%+v", 90, ob.lowestAsk.Price)
+	}
+}
+
+func TestFillOrKillRejectsWithoutTrading(t *testing.T) {
+	ob := NewOrderBook()
+
+	ob.ProcessOrder(Sell, 85, 2)
+	ob.ProcessOrder(Sell, 86, 2)
+	ob.ProcessOrder(Sell, 90, 10)
+
+	result, _ := ob.PlaceOrder(OrderRequest{Side: Buy, Type: Limit, TimeInForce: FOK, Price: 86, Size: 5})
+
+	if !result.Rejected || result.Filled != 0 {
+		t.Fatalf("tests - FOK should be rejected. expected=%t, got=%+v", true, result)
+	}
+
+	if len(ob.trades) != 0 {
+		t.Fatalf("tests - rejected FOK should not trade. expected=%+v, got=%+v", 0, len(ob.trades))
+	}
+
+	if ob.lowestAsk.Volume != 2 || len(ob.orders) != 3 {
+		t.Fatalf("tests - book should be untouched. expected=%+v, got=%+v", 3, len(ob.orders))
+	}
+}
+
+func TestFillOrKillFillsAcrossLevels(t *testing.T) {
+	ob := NewOrderBook()
+
+	ob.ProcessOrder(Buy, 42, 2)
+	ob.ProcessOrder(Buy, 41, 3)
+
+	result, _ := ob.PlaceOrder(OrderRequest{Side: Sell, Type: Limit, TimeInForce: FOK, Price: 41, Size: 5})
+
+	if result.Rejected || result.Filled != 5 || result.Unfilled != 0 {
+		t.Fatalf("tests - FOK should fill completely. expected=%d, got=%+v", 5, result)
+	}
+
+	if len(ob.trades) != 2 || len(ob.orders) != 0 {
+		t.Fatalf("tests - FOK should consume both levels. expected=%+v, got=%+v", 2, len(ob.trades))
+	}
+}
+
+func TestTimeInForceSurvivesDTO(t *testing.T) {
+	ob := NewOrderBook()
+
+	// GTC is the zero value, so wait with an IOC stop to see it kept.
+	result, err := ob.PlaceOrder(OrderRequest{Side: Sell, Type: StopLimit, TimeInForce: IOC, Price: 39, StopPrice: 40, Size: 1})
+	if err != nil || !result.Pending {
+		t.Fatalf("tests - stop order should wait. expected=%v, got=%+v, %v", true, result, err)
+	}
+
+	dto := ob.ToDTO()
+	if dto.Stops[result.Id].TimeInForce != IOC {
+		t.Fatalf("tests - wrong time in force in DTO. expected=%s, got=%s", IOC, dto.Stops[result.Id].TimeInForce)
+	}
+	restored := dto.ToOrderBook()
+	if restored.stops.orders[result.Id].TimeInForce != IOC {
+		t.Fatalf("tests - wrong time in force. expected=%s, got=%s", IOC, restored.stops.orders[result.Id].TimeInForce)
+	}
+}
+
+func TestPostOnlyRejectsWhenCrossing(t *testing.T) {
+	ob := NewOrderBook()
+
+	ob.ProcessOrder(Sell, 85, 2)
+
+	result, _ := ob.PlaceOrder(OrderRequest{Side: Buy, Type: Limit, PostOnly: true, Price: 86, Size: 1})
+
+	if !result.Rejected || result.Resting {
+		t.Fatalf("tests - crossing post-only order should be rejected. expected=%t, got=%+v", true, result)
+	}
+
+	if len(ob.trades) != 0 || len(ob.levels[Buy]) != 0 {
+		t.Fatalf("tests - rejected post-only order should not trade or rest. expected=%+v, got=%+v", 0, len(ob.trades))
+	}
+}
+
+func TestPostOnlyRepricesBehindTouch(t *testing.T) {
+	ob := NewOrderBook()
+
+	ob.ProcessOrder(Buy, 40, 2)
+	result, _ := ob.PlaceOrder(OrderRequest{Side: Sell, Type: Limit, PostOnly: true, Reprice: true, Price: 38, Size: 3})
+
+	if result.Rejected || !result.Resting {
+		t.Fatalf("tests - repriced post-only order should rest. expected=%t, got=%+v", true, result)
+	}
+
+	if result.Price != 41 || ob.lowestAsk.Price != 41 {
+		t.Fatalf("tests - post-only order should rest one tick behind the touch. expected=%d, got=%d", 41, result.Price)
+	}
+
+	if len(ob.trades) != 0 {
+		t.Fatalf("tests - post-only order should never trade. expected=%+v, got=%+v", 0, len(ob.trades))
+	}
+}
+
+func TestPostOnlyRestsWhenNotCrossing(t *testing.T) {
+	ob := NewOrderBook()
+
+	ob.ProcessOrder(Sell, 85, 2)
+	result, _ := ob.PlaceOrder(OrderRequest{Side: Buy, Type: Limit, PostOnly: true, Price: 84, Size: 1})
+
+	if result.Rejected || !result.Resting || result.Price != 84 {
+		t.Fatalf("tests - non-crossing post-only order should rest unchanged. expected=%d, got=%+v", 84, result)
+	}
+
+	if !ob.orders[result.Id].PostOnly {
+		t.Fatalf("tests - resting order should keep post-only flag. expected=%t, got=%t", true, ob.orders[result.Id].PostOnly)
+	}
+}
+
+func TestStopOrderWaitsOffBook(t *testing.T) {
+	ob := NewOrderBook()
+
+	result, _ := ob.PlaceOrder(OrderRequest{Side: Buy, Type: Stop, StopPrice: 90, Size: 1})
+
+	if !result.Pending || ob.orders[result.Id] != nil || ob.highestBid != nil {
+		t.Fatalf("tests - stop order should wait off the book. expected=%t, got=%+v", true, result)
+	}
+
+	if len(ob.StopOrders()) != 1 {
+		t.Fatalf("tests - wrong number of pending stops. expected=%d, got=%d", 1, len(ob.StopOrders()))
+	}
+}
+
+func TestStopOrderTriggersOnTrade(t *testing.T) {
+	ob := NewOrderBook()
+
+	ob.ProcessOrder(Sell, 90, 1)
+	ob.ProcessOrder(Sell, 95, 5)
+	stop, _ := ob.PlaceOrder(OrderRequest{Side: Buy, Type: Stop, StopPrice: 90, Size: 2})
+
+	ob.ProcessOrder(Buy, 89, 1)
+	if len(ob.StopOrders()) != 1 {
+		t.Fatalf("tests - stop should not trigger without a trade. expected=%d, got=%d", 1, len(ob.StopOrders()))
+	}
+
+	ob.ProcessOrder(Buy, 90, 1)
+
+	if len(ob.StopOrders()) != 0 {
+		t.Fatalf("tests - stop should have triggered. expected=%d, got=%d", 0, len(ob.StopOrders()))
+	}
+
+	if len(ob.trades) != 2 {
+		t.Fatalf("tests - wrong number of trades. expected=%d, got=%d", 2, len(ob.trades))
+	}
+
+	if ob.trades[1].BuyOrderID != stop.Id || ob.trades[1].Price != 95 || ob.trades[1].Size != 2 {
+		t.Fatalf("tests - triggered stop should trade as market order. expected=%+v, got=%+v", 95, ob.trades[1])
+	}
+}
+
+func TestStopLimitRestsAfterTrigger(t *testing.T) {
+	ob := NewOrderBook()
+
+	ob.ProcessOrder(Buy, 50, 1)
+	stop, _ := ob.PlaceOrder(OrderRequest{Side: Sell, Type: StopLimit, StopPrice: 50, Price: 49, Size: 3})
+
+	ob.ProcessOrder(Sell, 50, 1)
+
+	order := ob.orders[stop.Id]
+	if order == nil {
+		t.Fatalf("tests - triggered stop-limit should rest on the book. expected=%+v, got=%+v", stop.Id, nil)
+	}
+
+	if order.Type != Limit || order.Price != 49 || ob.lowestAsk.Price != 49 {
+		t.Fatalf("tests - triggered stop-limit should rest as limit order. expected=%d, got=%+v", 49, order)
+	}
+}
+
+func TestStopOrdersCascade(t *testing.T) {
+	ob := NewOrderBook()
+
+	ob.ProcessOrder(Buy, 100, 1)
+	ob.ProcessOrder(Buy, 98, 1)
+	ob.ProcessOrder(Buy, 95, 1)
+
+	first, _ := ob.PlaceOrder(OrderRequest{Side: Sell, Type: Stop, StopPrice: 100, Size: 1})
+	second, _ := ob.PlaceOrder(OrderRequest{Side: Sell, Type: Stop, StopPrice: 98, Size: 1})
+	untouched, _ := ob.PlaceOrder(OrderRequest{Side: Sell, Type: Stop, StopPrice: 90, Size: 1})
+
+	ob.ProcessOrder(Sell, 100, 1)
+
+	var expectedTrades = []struct {
+		expectedPrice  int
+		expectedSeller uuid.UUID
+	}{
+		{100, uuid.Nil},
+		{98, first.Id},
+		{95, second.Id},
+	}
+
+	if len(ob.trades) != len(expectedTrades) {
+		t.Fatalf("tests - wrong number of trades. expected=%d, got=%d", len(expectedTrades), len(ob.trades))
+	}
+
+	for i, expectedTrade := range expectedTrades {
+		if ob.trades[i].Price != expectedTrade.expectedPrice {
+			t.Fatalf("tests - wrong trade price. expected=%+v, got=%+v", expectedTrade.expectedPrice, ob.trades[i].Price)
+		}
+		if expectedTrade.expectedSeller != uuid.Nil && ob.trades[i].SellOrderID != expectedTrade.expectedSeller {
+			t.Fatalf("tests - wrong seller. expected=%+v, got=%+v", expectedTrade.expectedSeller, ob.trades[i].SellOrderID)
+		}
+	}
+
+	stops := ob.StopOrders()
+	if len(stops) != 1 || stops[0].Id != untouched.Id {
+		t.Fatalf("tests - only the lowest stop should be pending. expected=%+v, got=%+v", untouched.Id, stops)
+	}
+}
+
+func TestCancelStopOrder(t *testing.T) {
+	ob := NewOrderBook()
+
+	stop, _ := ob.PlaceOrder(OrderRequest{Side: Sell, Type: Stop, StopPrice: 40, Size: 1})
+
+	if err := ob.CancelStopOrder(stop.Id); err != nil {
+		t.Fatalf("tests - stop order should be cancelled. expected=%v, got=%v", nil, err)
+	}
+
+	if err := ob.CancelStopOrder(stop.Id); err != ErrOrderNotFound {
+		t.Fatalf("tests - stop order should no longer exist. expected=%v, got=%v", ErrOrderNotFound, err)
+	}
+
+	ob.ProcessOrder(Buy, 40, 1)
+	ob.ProcessOrder(Sell, 40, 1)
+
+	if len(ob.trades) != 1 {
+		t.Fatalf("tests - cancelled stop should not trigger. expected=%d, got=%d", 1, len(ob.trades))
+	}
+}
+
+func TestStopOrdersSurviveDTO(t *testing.T) {
+	ob := NewOrderBook()
+
+	stop, _ := ob.PlaceOrder(OrderRequest{Side: Buy, Type: StopLimit, StopPrice: 60, Price: 61, Size: 2})
+
+	restored := ob.ToDTO().ToOrderBook()
+	restored.storage = &NilStorage{}
+
+	stops := restored.StopOrders()
+	if len(stops) != 1 || stops[0].Id != stop.Id || stops[0].StopPrice != 60 || stops[0].Type != StopLimit {
+		t.Fatalf("tests - stop order not restored. expected=%+v, got=%+v", stop.Id, stops)
+	}
+
+	restored.ProcessOrder(Sell, 60, 1)
+	restored.ProcessOrder(Buy, 60, 1)
+
+	if restored.orders[stop.Id] == nil || restored.orders[stop.Id].Price != 61 {
+		t.Fatalf("tests - restored stop should trigger. expected=%d, got=%+v", 61, restored.orders[stop.Id])
+	}
+}
+
+func TestIcebergShowsOnlyDisplaySize(t *testing.T) {
+	ob := NewOrderBook()
+
+	result, _ := ob.PlaceOrder(OrderRequest{Side: Sell, Type: Limit, Price: 85, Size: 10, DisplaySize: 3})
+
+	order := ob.orders[result.Id]
+	if order.Remaining != 3 || order.Hidden != 7 {
+		t.Fatalf("tests - wrong iceberg split. expected=%d/%d, got=%d/%d", 3, 7, order.Remaining, order.Hidden)
+	}
+
+	if ob.lowestAsk.Volume != 3 {
+		t.Fatalf("tests - level volume should only count the visible slice. expected=%d, got=%d", 3, ob.lowestAsk.Volume)
+	}
+
+	view := BuildOrderBookView(ob)
+	if view.Asks[0].Volume != 3 {
+		t.Fatalf("tests - view should only show the visible slice. expected=%d, got=%d", 3, view.Asks[0].Volume)
+	}
+}
+
+func TestIcebergReplenishLosesPriority(t *testing.T) {
+	ob := NewOrderBook()
+
+	iceberg, _ := ob.PlaceOrder(OrderRequest{Side: Sell, Type: Limit, Price: 85, Size: 5, DisplaySize: 2})
+	other, _ := ob.ProcessOrder(Sell, 85, 4)
+
+	ob.ProcessOrder(Buy, 85, 2)
+
+	level := ob.levels[Sell][85]
+	if level.headOrder.Id != other || level.tailOrder.Id != iceberg.Id {
+		t.Fatalf("tests - replenished iceberg should move to the back. expected=%+v, got=%+v", other, level.headOrder.Id)
+	}
+
+	if level.Volume != 6 || ob.orders[iceberg.Id].Remaining != 2 || ob.orders[iceberg.Id].Hidden != 1 {
+		t.Fatalf("tests - wrong volume after replenish. expected=%d, got=%d", 6, level.Volume)
+	}
+
+	ob.ProcessOrder(Buy, 85, 7)
+
+	var expectedTrades = []struct {
+		expectedSize   int
+		expectedSeller uuid.UUID
+	}{
+		{2, iceberg.Id},
+		{4, other},
+		{2, iceberg.Id},
+		{1, iceberg.Id},
+	}
+
+	if len(ob.trades) != len(expectedTrades) {
+		t.Fatalf("tests - wrong number of trades. expected=%d, got=%d", len(expectedTrades), len(ob.trades))
+	}
+
+	for i, expectedTrade := range expectedTrades {
+		if ob.trades[i].Size != expectedTrade.expectedSize || ob.trades[i].SellOrderID != expectedTrade.expectedSeller {
+			t.Fatalf("tests - wrong trade. expected=%+v, got=%+v", expectedTrade, ob.trades[i])
+		}
+	}
+
+	if len(ob.orders) != 0 || ob.lowestAsk != nil {
+		t.Fatalf("tests - iceberg should be fully filled. expected=%d, got=%d", 0, len(ob.orders))
+	}
+}
+
+func TestIcebergReserveSurvivesDTO(t *testing.T) {
+	ob := NewOrderBook()
+
+	result, _ := ob.PlaceOrder(OrderRequest{Side: Buy, Type: Limit, Price: 40, Size: 9, DisplaySize: 4})
+
+	restored := ob.ToDTO().ToOrderBook()
+	order := restored.orders[result.Id]
+	if order.Remaining != 4 || order.Hidden != 5 || order.DisplaySize != 4 {
+		t.Fatalf("tests - iceberg reserve not restored. expected=%d/%d, got=%+v", 4, 5, order)
+	}
+}
+
+func TestAmendShrinkKeepsPriority(t *testing.T) {
+	ob := NewOrderBook()
+
+	id0, _ := ob.ProcessOrder(Buy, 42, 5)
+	id1, _ := ob.ProcessOrder(Buy, 42, 3)
+
+	result, err := ob.AmendOrder(id0, 42, 2)
+	if err != nil {
+		t.Fatalf("tests - amend failed. expected=%v, got=%v", nil, err)
+	}
+
+	level := ob.levels[Buy][42]
+	if level.headOrder.Id != id0 || level.tailOrder.Id != id1 {
+		t.Fatalf("tests - shrunk order should keep its place. expected=%+v, got=%+v", id0, level.headOrder.Id)
+	}
+
+	if level.Volume != 5 || ob.orders[id0].Remaining != 2 || result.Unfilled != 2 {
+		t.Fatalf("tests - wrong volume after shrink. expected=%d, got=%d", 5, level.Volume)
+	}
+}
+
+func TestAmendIncreaseLosesPriority(t *testing.T) {
+	ob := NewOrderBook()
+
+	id0, _ := ob.ProcessOrder(Buy, 42, 1)
+	id1, _ := ob.ProcessOrder(Buy, 42, 3)
+
+	if _, err := ob.AmendOrder(id0, 42, 4); err != nil {
+		t.Fatalf("tests - amend failed. expected=%v, got=%v", nil, err)
+	}
+
+	level := ob.levels[Buy][42]
+	if level.headOrder.Id != id1 || level.tailOrder.Id != id0 {
+		t.Fatalf("tests - grown order should move to the back. expected=%+v, got=%+v", id1, level.headOrder.Id)
+	}
+
+	if level.Volume != 7 || level.Count != 2 {
+		t.Fatalf("tests - wrong level after increase. expected=%d, got=%d", 7, level.Volume)
+	}
+}
+
+func TestAmendPriceMatches(t *testing.T) {
+	ob := NewOrderBook()
+
+	ob.ProcessOrder(Sell, 45, 2)
+	id, _ := ob.ProcessOrder(Buy, 42, 3)
+
+	result, err := ob.AmendOrder(id, 45, 3)
+	if err != nil {
+		t.Fatalf("tests - amend failed. expected=%v, got=%v", nil, err)
+	}
+
+	if len(ob.trades) != 1 || ob.trades[0].BuyOrderID != id || ob.trades[0].Size != 2 {
+		t.Fatalf("tests - amended order should match. expected=%d, got=%+v", 1, ob.trades)
+	}
+
+	if result.Filled != 2 || ob.orders[id].Remaining != 1 || ob.highestBid.Price != 45 {
+		t.Fatalf("tests - amended order should rest remainder. expected=%d, got=%+v", 1, ob.orders[id])
+	}
+
+	if ob.levels[Buy][42] != nil {
+		t.Fatalf("tests - old level should be removed. expected=%v, got=%+v", nil, ob.levels[Buy][42])
+	}
+}
+
+func TestAmendRejectsInvalidSize(t *testing.T) {
+	ob := NewOrderBook()
+
+	ob.ProcessOrder(Sell, 45, 2)
+	id, _ := ob.ProcessOrder(Buy, 45, 5)
+
+	if _, err := ob.AmendOrder(id, 45, 2); err != ErrInvalidAmend {
+		t.Fatalf("tests - amend below filled size should fail. expected=%v, got=%v", ErrInvalidAmend, err)
+	}
+
+	if _, err := ob.AmendOrder(uuid.New(), 45, 2); err != ErrOrderNotFound {
+		t.Fatalf("tests - amend of unknown order should fail. expected=%v, got=%v", ErrOrderNotFound, err)
+	}
+}
+
+func TestOrderStatusLifecycle(t *testing.T) {
+	ob := NewOrderBook()
+
+	id, _ := ob.ProcessOrder(Sell, 50, 5)
+
+	info, ok := ob.OrderStatus(id)
+	if !ok || info.Status != Open.String() || info.Remaining != 5 {
+		t.Fatalf("tests - new order should be open. expected=%s, got=%+v", Open, info)
+	}
+
+	buyId, _ := ob.ProcessOrder(Buy, 50, 2)
+
+	info, _ = ob.OrderStatus(id)
+	if info.Status != PartiallyFilled.String() || info.Remaining != 3 || len(info.Fills) != 1 {
+		t.Fatalf("tests - order should be partially filled. expected=%s, got=%+v", PartiallyFilled, info)
+	}
+
+	buyInfo, ok := ob.OrderStatus(buyId)
+	if !ok || buyInfo.Status != Filled.String() || buyInfo.Remaining != 0 || buyInfo.Fills[0] != ob.trades[0].ID {
+		t.Fatalf("tests - aggressing order should be filled. expected=%s, got=%+v", Filled, buyInfo)
+	}
+
+	ob.CancelOrder(id)
+
+	info, ok = ob.OrderStatus(id)
+	if !ok || info.Status != Cancelled.String() || info.Remaining != 3 || len(info.Fills) != 1 {
+		t.Fatalf("tests - order should be cancelled. expected=%s, got=%+v", Cancelled, info)
+	}
+}
+
+func TestOrderStatusFilledResting(t *testing.T) {
+	ob := NewOrderBook()
+
+	id, _ := ob.ProcessOrder(Buy, 50, 2)
+	ob.ProcessOrder(Sell, 50, 1)
+	ob.ProcessOrder(Sell, 49, 1)
+
+	info, ok := ob.OrderStatus(id)
+	if !ok || info.Status != Filled.String() || len(info.Fills) != 2 {
+		t.Fatalf("tests - resting order should be filled. expected=%s, got=%+v", Filled, info)
+	}
+}
+
+func TestOrderStatusFillsSurviveRestore(t *testing.T) {
+	ob := NewOrderBook()
+
+	id, _ := ob.ProcessOrder(Sell, 50, 5)
+	ob.ProcessOrder(Buy, 50, 1)
+	ob.ProcessOrder(Buy, 50, 2)
+	before, _ := ob.OrderStatus(id)
+
+	// Storage keeps the book as JSON.
+	data, _ := json.Marshal(ob.ToDTO())
+	var dto OrderBookDTO
+	json.Unmarshal(data, &dto)
+
+	info, ok := dto.ToOrderBook().OrderStatus(id)
+	if !ok || info.Status != PartiallyFilled.String() || !reflect.DeepEqual(info.Fills, before.Fills) {
+		t.Fatalf("tests - restored order should keep its fills. expected=%v, got=%+v", before.Fills, info)
+	}
+}
+
+func TestOrderStatusForgetsOldestClosed(t *testing.T) {
+	ob := NewOrderBook()
+	ob.closed = newClosedOrders(2)
+
+	var ids []uuid.UUID
+	for price := 50; price < 53; price++ {
+		id, _ := ob.ProcessOrder(Sell, price, 1)
+		ob.CancelOrder(id)
+		ids = append(ids, id)
+	}
+
+	if info, ok := ob.OrderStatus(ids[0]); ok {
+		t.Fatalf("tests - oldest closed order should be forgotten. expected=%v, got=%+v", false, info)
+	}
+	for _, id := range ids[1:] {
+		if info, ok := ob.OrderStatus(id); !ok || info.Status != Cancelled.String() {
+			t.Fatalf("tests - latest closed orders should be kept. expected=%s, got=%+v", Cancelled, info)
+		}
+	}
+	if len(ob.closed.orders) != 2 {
+		t.Fatalf("tests - closed orders should stay bounded. expected=%d, got=%d", 2, len(ob.closed.orders))
+	}
+}
+
+func TestOrderStatusRejectedAndPending(t *testing.T) {
+	ob := NewOrderBook()
+
+	fok, _ := ob.PlaceOrder(OrderRequest{Side: Buy, Type: Limit, TimeInForce: FOK, Price: 50, Size: 1})
+	stop, _ := ob.PlaceOrder(OrderRequest{Side: Buy, Type: Stop, StopPrice: 60, Size: 1})
+
+	if info, _ := ob.OrderStatus(fok.Id); info.Status != Rejected.String() {
+		t.Fatalf("tests - FOK order should be rejected. expected=%s, got=%+v", Rejected, info)
+	}
+
+	if info, _ := ob.OrderStatus(stop.Id); info.Status != Pending.String() {
+		t.Fatalf("tests - stop order should be pending. expected=%s, got=%+v", Pending, info)
+	}
+
+	if _, ok := ob.OrderStatus(uuid.New()); ok {
+		t.Fatalf("tests - unknown order should not be found. expected=%t, got=%t", false, ok)
+	}
+}
+
+func TestPlaceOrderRejectsInvalidOrders(t *testing.T) {
+	ob := NewOrderBook()
+	ob.SetInstrument(Instrument{Symbol: "BTC-USD", TickSize: 5, LotSize: 10, MinSize: 20, MaxSize: 1000, MinPrice: 100, MaxPrice: 500})
+
+	var tests = []struct {
+		req          OrderRequest
+		expectedCode RejectCode
+	}{
+		{OrderRequest{Side: Buy, Type: Limit, Price: 200, Size: 0}, RejectInvalidSize},
+		{OrderRequest{Side: Buy, Type: Limit, Price: 200, Size: -10}, RejectInvalidSize},
+		{OrderRequest{Side: Buy, Type: Limit, Price: 200, Size: 25}, RejectLotSize},
+		{OrderRequest{Side: Buy, Type: Limit, Price: 200, Size: 10}, RejectSizeRange},
+		{OrderRequest{Side: Buy, Type: Limit, Price: 200, Size: 1010}, RejectSizeRange},
+		{OrderRequest{Side: Buy, Type: Limit, Price: 0, Size: 20}, RejectInvalidPrice},
+		{OrderRequest{Side: Buy, Type: Limit, Price: 202, Size: 20}, RejectTickSize},
+		{OrderRequest{Side: Buy, Type: Limit, Price: 95, Size: 20}, RejectPriceRange},
+		{OrderRequest{Side: Buy, Type: Limit, Price: 505, Size: 20}, RejectPriceRange},
+		{OrderRequest{Side: Buy, Type: Limit, Price: 200, Size: 100, DisplaySize: 15}, RejectLotSize},
+		{OrderRequest{Side: Buy, Type: Stop, StopPrice: 203, Size: 20}, RejectInvalidStop},
+		{OrderRequest{Side: Buy, Type: StopLimit, StopPrice: 200, Price: 600, Size: 20}, RejectPriceRange},
+	}
+
+	for _, tt := range tests {
+		_, err := ob.PlaceOrder(tt.req)
+		rejectErr, ok := err.(*RejectError)
+		if !ok || rejectErr.Code != tt.expectedCode {
+			t.Fatalf("tests - wrong rejection for %+v. expected=%s, got=%v", tt.req, tt.expectedCode, err)
+		}
+	}
+
+	if len(ob.orders) != 0 || len(ob.stops.list()) != 0 || len(ob.closed.orders) != 0 {
+		t.Fatalf("tests - rejected orders should leave no trace. expected=%d, got=%d", 0, len(ob.orders)+len(ob.closed.orders))
+	}
+
+	if _, err := ob.PlaceOrder(OrderRequest{Side: Buy, Type: Market, Size: 20}); err != nil {
+		t.Fatalf("tests - market order needs no price. expected=%v, got=%v", nil, err)
+	}
+}
+
+func TestPostOnlyRepricesByTickSize(t *testing.T) {
+	ob := NewOrderBook()
+	ob.SetInstrument(Instrument{TickSize: 5})
+
+	ob.ProcessOrder(Sell, 100, 1)
+	result, _ := ob.PlaceOrder(OrderRequest{Side: Buy, Type: Limit, PostOnly: true, Reprice: true, Price: 110, Size: 1})
+
+	if !result.Resting || result.Price != 95 {
+		t.Fatalf("tests - post-only should reprice one tick behind the touch. expected=%d, got=%+v", 95, result)
+	}
+}
+
+func TestAmendOrderValidatesInstrument(t *testing.T) {
+	ob := NewOrderBook()
+	ob.SetInstrument(Instrument{TickSize: 5, LotSize: 2})
+
+	id, _ := ob.ProcessOrder(Buy, 100, 4)
+
+	if _, err := ob.AmendOrder(id, 102, 4); err == nil {
+		t.Fatalf("tests - amend off the tick should fail. expected=%s, got=%v", RejectTickSize, err)
+	}
+
+	if _, err := ob.AmendOrder(id, 100, 3); err == nil {
+		t.Fatalf("tests - amend off the lot should fail. expected=%s, got=%v", RejectLotSize, err)
+	}
+
+	if order := ob.orders[id]; order.Price != 100 || order.Remaining != 4 {
+		t.Fatalf("tests - rejected amend should leave the order alone. expected=%d, got=%+v", 4, order)
+	}
+}
+
+// failingStorage fails the storage calls named in failOn and records the
+// ones that went through, keeping those of a unit of work in pending
+// until it is committed.
+type failingStorage struct {
+	NilStorage
+	failOn    map[string]bool
+	open      bool
+	pending   []string
+	committed []string
+}
+
+var errStorageDown = errors.New("storage down")
+
+func (f *failingStorage) fail(op string) error {
+	if f.failOn[op] {
+		return errStorageDown
+	}
+	if f.open {
+		f.pending = append(f.pending, op)
+	} else {
+		f.committed = append(f.committed, op)
+	}
+	return nil
+}
+
+func (f *failingStorage) Begin(seq uint64) error {
+	f.open = true
+	return nil
+}
+
+func (f *failingStorage) Commit() error {
+	defer f.Rollback()
+	if f.failOn["Commit"] {
+		return errStorageDown
+	}
+	f.committed = append(f.committed, f.pending...)
+	return nil
+}
+
+func (f *failingStorage) Rollback() error {
+	f.open, f.pending = false, nil
+	return nil
+}
+
+func (f *failingStorage) UpdateOrder(o *OrderDTO) error {
+	return f.fail("UpdateOrder")
+}
+
+func (f *failingStorage) InsertLevel(side Side, l *LevelDTO) error {
+	return f.fail("InsertLevel")
+}
+
+func (f *failingStorage) InsertOrder(o *OrderDTO) error {
+	return f.fail("InsertOrder")
+}
+
+func (f *failingStorage) InsertTrade(t *Trade) error {
+	return f.fail("InsertTrade")
+}
+
+func (f *failingStorage) DeleteOrder(o *OrderDTO) error {
+	return f.fail("DeleteOrder")
+}
+
+func TestFailedInsertRollsBackOrder(t *testing.T) {
+	ob := NewOrderBook()
+	ob.ProcessOrder(Buy, 40, 1)
+
+	ob.AddStorage(&failingStorage{failOn: map[string]bool{"InsertLevel": true, "InsertOrder": true}})
+
+	for _, price := range []int{40, 41} {
+		_, err := ob.ProcessOrder(Buy, price, 2)
+		if !errors.Is(err, ErrStorage) || errors.Is(err, ErrDegraded) {
+			t.Fatalf("tests - failed insert should be a plain storage error. expected=%v, got=%v", ErrStorage, err)
+		}
+	}
+
+	if len(ob.orders) != 1 || ob.highestBid.Price != 40 || ob.highestBid.Volume != 1 || ob.highestBid.Count != 1 || ob.highestBid.nextLevel != nil {
+		t.Fatalf("tests - book should be unchanged. expected=%d, got=%+v", 1, ob.highestBid)
+	}
+
+	if ob.Degraded() != nil {
+		t.Fatalf("tests - book should not be degraded. expected=%v, got=%v", nil, ob.Degraded())
+	}
+}
+
+func TestFailedCancelKeepsOrder(t *testing.T) {
+	ob := NewOrderBook()
+	id, _ := ob.ProcessOrder(Sell, 40, 2)
+
+	ob.AddStorage(&failingStorage{failOn: map[string]bool{"DeleteOrder": true}})
+
+	if err := ob.CancelOrder(id); !errors.Is(err, ErrStorage) {
+		t.Fatalf("tests - cancel should fail. expected=%v, got=%v", ErrStorage, err)
+	}
+
+	if order := ob.orders[id]; order == nil || ob.lowestAsk.headOrder != order {
+		t.Fatalf("tests - order should still rest. expected=%s, got=%+v", id, ob.lowestAsk)
+	}
+}
+
+func TestFailureMidMatchDegradesBook(t *testing.T) {
+	ob := NewOrderBook()
+	ob.ProcessOrder(Sell, 40, 1)
+	ob.ProcessOrder(Sell, 41, 2)
+
+	ob.AddStorage(&failingStorage{failOn: map[string]bool{"DeleteOrder": true}})
+
+	result, err := ob.PlaceOrder(OrderRequest{Side: Buy, Type: Limit, Price: 41, Size: 2})
+	if !errors.Is(err, ErrDegraded) {
+		t.Fatalf("tests - failure after the first trade should degrade the book. expected=%v, got=%v", ErrDegraded, err)
+	}
+
+	if result.Filled != 2 || len(ob.trades) != 2 || ob.lowestAsk.Price != 41 || ob.lowestAsk.Volume != 1 {
+		t.Fatalf("tests - order should complete in memory. expected=%d, got=%+v", 2, result)
+	}
+
+	if _, err := ob.ProcessOrder(Buy, 30, 1); !errors.Is(err, ErrDegraded) {
+		t.Fatalf("tests - degraded book should refuse orders. expected=%v, got=%v", ErrDegraded, err)
+	}
+
+	ob.AddStorage(&NilStorage{})
+	if err := ob.Resync(); err != nil || ob.Degraded() != nil {
+		t.Fatalf("tests - resync should clear the degraded state. expected=%v, got=%v", nil, err)
+	}
+
+	if _, err := ob.ProcessOrder(Buy, 30, 1); err != nil {
+		t.Fatalf("tests - book should accept orders again. expected=%v, got=%v", nil, err)
+	}
+}
+
+func TestMatchIsStoredAsOneUnitOfWork(t *testing.T) {
+	ob := NewOrderBook()
+	ob.ProcessOrder(Sell, 40, 1)
+	ob.ProcessOrder(Sell, 41, 2)
+
+	storage := &failingStorage{}
+	ob.AddStorage(storage)
+	ob.ProcessOrder(Buy, 41, 2)
+
+	expected := []string{"InsertTrade", "DeleteOrder", "InsertTrade", "UpdateOrder"}
+	if !reflect.DeepEqual(storage.committed, expected) {
+		t.Fatalf("tests - match should be committed whole. expected=%v, got=%v", expected, storage.committed)
+	}
+
+	storage = &failingStorage{failOn: map[string]bool{"InsertOrder": true}}
+	ob.AddStorage(storage)
+	if _, err := ob.ProcessOrder(Buy, 42, 3); !errors.Is(err, ErrDegraded) {
+		t.Fatalf("tests - failure after the first trade should degrade the book. expected=%v, got=%v", ErrDegraded, err)
+	}
+
+	if len(storage.committed) != 0 || storage.open {
+		t.Fatalf("tests - failed command should be rolled back in storage. expected=%v, got=%v", nil, storage.committed)
+	}
+}
+
+func TestFailedCommitDegradesBook(t *testing.T) {
+	ob := NewOrderBook()
+	ob.AddStorage(&failingStorage{failOn: map[string]bool{"Commit": true}})
+
+	if _, err := ob.ProcessOrder(Buy, 40, 1); !errors.Is(err, ErrDegraded) || len(ob.orders) != 1 {
+		t.Fatalf("tests - failed commit should degrade the book. expected=%v, got=%v", ErrDegraded, err)
+	}
+}
+
+func TestBuildBookOrdersView(t *testing.T) {
+	ob := NewOrderBook()
+	first, _ := ob.ProcessOrder(Buy, 40, 5)
+	iceberg, _ := ob.PlaceOrder(OrderRequest{Side: Buy, Type: Limit, Price: 40, Size: 10, DisplaySize: 2})
+	ob.ProcessOrder(Buy, 39, 1)
+	ob.ProcessOrder(Buy, 38, 1)
+	ob.ProcessOrder(Sell, 45, 3)
+
+	view := BuildBookOrdersView(ob, 2)
+	if len(view.Bids) != 2 || view.Bids[0].Price != 40 || view.Bids[1].Price != 39 || len(view.Asks) != 1 {
+		t.Fatalf("tests - wrong levels. expected=%s, got=%+v", "40, 39 / 45", view)
+	}
+
+	best := view.Bids[0]
+	if best.Volume != 7 || best.Count != 2 || len(best.Orders) != 2 {
+		t.Fatalf("tests - wrong best bid. expected=%d/%d, got=%+v", 7, 2, best)
+	}
+	if best.Orders[0].ID != first || best.Orders[1].ID != iceberg.Id || best.Orders[1].Size != 2 {
+		t.Fatalf("tests - orders should be in FIFO order, icebergs showing their slice. expected=%s, %s, got=%+v", first, iceberg.Id, best.Orders)
+	}
+
+	if all := BuildBookOrdersView(ob, 0); len(all.Bids) != 3 {
+		t.Fatalf("tests - depth 0 should return every level. expected=%d, got=%d", 3, len(all.Bids))
+	}
+}
@@ -0,0 +1,51 @@
+package engine
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrNoTradeHistory = errors.New("storage keeps no trade history")
+
+// TradeQuery selects a page of the trades of one symbol. Trades are paged
+// in Seq order, oldest first unless Descending. Zero values leave a filter
+// out.
+type TradeQuery struct {
+	Symbol string
+	// From is inclusive, To exclusive.
+	From, To           time.Time
+	MinPrice, MaxPrice int
+	// OrderID matches trades on either side of the order.
+	OrderID    uuid.UUID
+	Descending bool
+	// After continues from the trade it marks, in the direction of the
+	// query.
+	After *TradeCursor
+	Limit int
+}
+
+// TradeCursor marks a trade's place in the order trades are paged in.
+type TradeCursor struct {
+	Seq uint64
+}
+
+// TradeHistory answers trade queries from storage, rather than from the
+// trades a book holds in memory.
+type TradeHistory interface {
+	QueryTrades(q TradeQuery) ([]Trade, error)
+}
+
+// Matches tells whether t passes the filters of q, leaving out paging.
+func (q TradeQuery) Matches(t Trade) bool {
+	switch {
+	case !q.From.IsZero() && t.Time.Before(q.From),
+		!q.To.IsZero() && !t.Time.Before(q.To),
+		q.MinPrice != 0 && t.Price < q.MinPrice,
+		q.MaxPrice != 0 && t.Price > q.MaxPrice,
+		q.OrderID != uuid.Nil && t.BuyOrderID != q.OrderID && t.SellOrderID != q.OrderID:
+		return false
+	}
+	return true
+}
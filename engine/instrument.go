@@ -0,0 +1,138 @@
+package engine
+
+import "fmt"
+
+// Instrument holds the trading rules for one symbol. Prices must be a
+// multiple of TickSize and sizes a multiple of LotSize. A zero MaxSize or
+// MaxPrice leaves that side of the range open.
+type Instrument struct {
+	Symbol   string `json:"symbol"`
+	TickSize int    `json:"tick_size"`
+	LotSize  int    `json:"lot_size"`
+	MinSize  int    `json:"min_size"`
+	MaxSize  int    `json:"max_size"`
+	MinPrice int    `json:"min_price"`
+	MaxPrice int    `json:"max_price"`
+}
+
+type RejectCode string
+
+const (
+	RejectInvalidSize  RejectCode = "invalid_size"
+	RejectLotSize      RejectCode = "lot_size"
+	RejectSizeRange    RejectCode = "size_range"
+	RejectInvalidPrice RejectCode = "invalid_price"
+	RejectTickSize     RejectCode = "tick_size"
+	RejectPriceRange   RejectCode = "price_range"
+	RejectInvalidStop  RejectCode = "invalid_stop_price"
+)
+
+// RejectError is returned when an order breaks its instrument's rules.
+// Nothing about the book changes when an order is rejected.
+type RejectError struct {
+	Code    RejectCode
+	Message string
+}
+
+func (e *RejectError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+func reject(code RejectCode, format string, args ...any) *RejectError {
+	return &RejectError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// DefaultInstrument trades any positive whole price and size.
+func DefaultInstrument(symbol string) Instrument {
+	return Instrument{Symbol: symbol}.WithDefaults()
+}
+
+// WithDefaults fills in the tick, lot and minimum size of a spec that
+// leaves them out.
+func (i Instrument) WithDefaults() Instrument {
+	if i.TickSize == 0 {
+		i.TickSize = 1
+	}
+	if i.LotSize == 0 {
+		i.LotSize = 1
+	}
+	if i.MinSize == 0 {
+		i.MinSize = i.LotSize
+	}
+	if i.MinPrice == 0 {
+		i.MinPrice = i.TickSize
+	}
+	return i
+}
+
+// Validate checks that the spec itself is consistent.
+func (i Instrument) Validate() error {
+	switch {
+	case i.TickSize <= 0 || i.LotSize <= 0:
+		return fmt.Errorf("%s: tick and lot size must be positive", i.Symbol)
+	case i.MinSize <= 0 || i.MinPrice <= 0:
+		return fmt.Errorf("%s: minimum size and price must be positive", i.Symbol)
+	case i.MaxSize < 0 || i.MaxPrice < 0:
+		return fmt.Errorf("%s: maximum size and price must not be negative", i.Symbol)
+	case i.MaxSize > 0 && i.MaxSize < i.MinSize:
+		return fmt.Errorf("%s: maximum size is below minimum size", i.Symbol)
+	case i.MaxPrice > 0 && i.MaxPrice < i.MinPrice:
+		return fmt.Errorf("%s: maximum price is below minimum price", i.Symbol)
+	}
+	return nil
+}
+
+func (i Instrument) checkSize(size int) error {
+	switch {
+	case size <= 0:
+		return reject(RejectInvalidSize, "size %d must be positive", size)
+	case size%i.LotSize != 0:
+		return reject(RejectLotSize, "size %d is not a multiple of lot size %d", size, i.LotSize)
+	case size < i.MinSize:
+		return reject(RejectSizeRange, "size %d is below minimum %d", size, i.MinSize)
+	case i.MaxSize > 0 && size > i.MaxSize:
+		return reject(RejectSizeRange, "size %d is above maximum %d", size, i.MaxSize)
+	}
+	return nil
+}
+
+func (i Instrument) checkPrice(price int) error {
+	switch {
+	case price <= 0:
+		return reject(RejectInvalidPrice, "price %d must be positive", price)
+	case price%i.TickSize != 0:
+		return reject(RejectTickSize, "price %d is not a multiple of tick size %d", price, i.TickSize)
+	case !i.priceInRange(price):
+		return reject(RejectPriceRange, "price %d is outside %d-%d", price, i.MinPrice, i.MaxPrice)
+	}
+	return nil
+}
+
+func (i Instrument) priceInRange(price int) bool {
+	return price >= i.MinPrice && (i.MaxPrice == 0 || price <= i.MaxPrice)
+}
+
+// checkOrder validates an incoming order against the instrument. Market
+// orders carry no price, and only stop orders carry a stop price.
+func (i Instrument) checkOrder(req OrderRequest) error {
+	if err := i.checkSize(req.Size); err != nil {
+		return err
+	}
+	if req.DisplaySize < 0 || req.DisplaySize%i.LotSize != 0 {
+		return reject(RejectLotSize, "display size %d is not a multiple of lot size %d", req.DisplaySize, i.LotSize)
+	}
+
+	if req.Type == Limit || req.Type == StopLimit {
+		if err := i.checkPrice(req.Price); err != nil {
+			return err
+		}
+	}
+
+	if req.Type == Stop || req.Type == StopLimit {
+		if err := i.checkPrice(req.StopPrice); err != nil {
+			rejectErr := err.(*RejectError)
+			return reject(RejectInvalidStop, "stop %s", rejectErr.Message)
+		}
+	}
+	return nil
+}
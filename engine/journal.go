@@ -0,0 +1,190 @@
+package engine
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type CommandType string
+
+const (
+	PlaceCommand      CommandType = "place"
+	AmendCommand      CommandType = "amend"
+	CancelCommand     CommandType = "cancel"
+	CancelStopCommand CommandType = "cancel_stop"
+	ResetCommand      CommandType = "reset"
+	// BaselineCommand loads a whole book. It starts the journal of a book
+	// that already held orders before it had a journal.
+	BaselineCommand CommandType = "baseline"
+)
+
+// JournalEntry records one accepted command. Time and IDs fix the clock
+// reading and the namespace of every id the command hands out, so
+// replaying the entry produces exactly the same orders and trades.
+type JournalEntry struct {
+	Seq     uint64        `json:"seq"`
+	Time    time.Time     `json:"time"`
+	IDs     uuid.UUID     `json:"ids"`
+	Type    CommandType   `json:"type"`
+	Order   *OrderRequest `json:"order,omitempty"`
+	OrderID *uuid.UUID    `json:"order_id,omitempty"`
+	Price   int           `json:"price,omitempty"`
+	Size    int           `json:"size,omitempty"`
+	Book    *OrderBookDTO `json:"book,omitempty"`
+}
+
+// Journal is an append-only log of the commands applied to a book. An
+// entry is appended before its command is applied, so once Append returns
+// the command is durable whatever happens to storage afterwards.
+type Journal interface {
+	Append(entry *JournalEntry) error
+	Entries() ([]*JournalEntry, error)
+}
+
+// SnapshotStore keeps copies of the book taken at a sequence number, so
+// recovery only has to replay the journal after the latest one. Latest
+// returns a nil book when there is no usable snapshot.
+type SnapshotStore interface {
+	Save(seq uint64, book *OrderBookDTO) error
+	Latest() (uint64, *OrderBookDTO, error)
+}
+
+func (ob *OrderBook) AddJournal(journal Journal) {
+	ob.journal = journal
+}
+
+func (ob *OrderBook) AddSnapshots(snapshots SnapshotStore) {
+	ob.snapshots = snapshots
+}
+
+// Seq is the sequence number of the last command applied to the book.
+func (ob *OrderBook) Seq() uint64 {
+	return ob.seq
+}
+
+// execute journals a command, if the book has a journal, and applies it
+// in a storage unit of work. The journal append is the command's first
+// write: if it fails nothing has changed, and once it succeeds later
+// storage failures degrade the book rather than roll the command back, so
+// memory always matches the journal.
+func (ob *OrderBook) execute(entry *JournalEntry) (OrderResult, error) {
+	entry.Seq = ob.seq + 1
+	entry.Time = ob.clock.Now()
+	entry.IDs = ob.ids.NewID()
+
+	if err := ob.storage.Begin(entry.Seq); err != nil {
+		return OrderResult{}, fmt.Errorf("%w: begin: %w", ErrStorage, err)
+	}
+
+	if ob.journal != nil {
+		if err := ob.journal.Append(entry); err != nil {
+			ob.commit(true)
+			return OrderResult{}, fmt.Errorf("%w: journal: %w", ErrStorage, err)
+		}
+		ob.written = true
+	}
+
+	result, err := ob.apply(entry)
+	ob.commit(err != nil)
+	if err == nil {
+		err = ob.health()
+	}
+	return result, err
+}
+
+// apply runs a command on the clock and ids recorded in its entry.
+func (ob *OrderBook) apply(entry *JournalEntry) (OrderResult, error) {
+	clock, ids := ob.clock, ob.ids
+	ob.clock = &TickingClock{Start: entry.Time}
+	ob.ids = &SequentialIDs{Namespace: entry.IDs}
+	defer func() {
+		ob.clock, ob.ids = clock, ids
+	}()
+
+	ob.seq = entry.Seq
+	switch entry.Type {
+	case PlaceCommand:
+		return ob.placeOrder(*entry.Order)
+	case AmendCommand:
+		return ob.amendOrder(*entry.OrderID, entry.Price, entry.Size)
+	case CancelCommand:
+		return OrderResult{}, ob.cancelOrder(*entry.OrderID)
+	case CancelStopCommand:
+		return OrderResult{}, ob.cancelStopOrder(*entry.OrderID)
+	case ResetCommand:
+		return OrderResult{}, ob.resetOrderBook()
+	case BaselineCommand:
+		ob.load(entry.Book.ToOrderBook())
+		return OrderResult{}, nil
+	}
+	return OrderResult{}, fmt.Errorf("unknown journal command %q", entry.Type)
+}
+
+// recover rebuilds the book from the latest snapshot, if any, and the
+// journal entries after it. It reports false if there was nothing to
+// recover from.
+func (ob *OrderBook) recover(entries []*JournalEntry) bool {
+	if ob.snapshots != nil {
+		seq, book, err := ob.snapshots.Latest()
+		if err != nil {
+			Logger.Printf("Failed to read snapshots. Replaying the whole journal. %s", err)
+		} else if book != nil {
+			ob.load(book.ToOrderBook())
+			ob.seq = seq
+
+			tail := 0
+			for tail < len(entries) && entries[tail].Seq <= seq {
+				tail++
+			}
+			entries = entries[tail:]
+			Logger.Printf("Loaded snapshot at seq %d", seq)
+		} else if len(entries) == 0 {
+			return false
+		}
+	} else if len(entries) == 0 {
+		return false
+	}
+
+	ob.replay(entries)
+	return true
+}
+
+// replay rebuilds the book from its journal. Storage already holds the
+// effects of every entry, so it is left out while replaying.
+func (ob *OrderBook) replay(entries []*JournalEntry) {
+	storage, journal := ob.storage, ob.journal
+	ob.storage, ob.journal = &NilStorage{}, nil
+	defer func() {
+		ob.storage, ob.journal = storage, journal
+	}()
+
+	for _, entry := range entries {
+		if entry.Seq != ob.seq+1 {
+			Logger.Printf("Journal skips from seq %d to %d", ob.seq, entry.Seq)
+		}
+		if _, err := ob.apply(entry); err != nil {
+			Logger.Printf("Journal entry %d (%s) failed on replay: %s", entry.Seq, entry.Type, err)
+		}
+	}
+	Logger.Printf("Replayed %d journal entries up to seq %d", len(entries), ob.seq)
+}
+
+// startJournal writes a baseline entry for a book restored from storage
+// into an empty journal, so replaying the journal later starts from the
+// same book.
+func (ob *OrderBook) startJournal() {
+	if ob.journal == nil || (len(ob.orders) == 0 && len(ob.stops.orders) == 0 && len(ob.trades) == 0) {
+		return
+	}
+
+	entry := &JournalEntry{Type: BaselineCommand, Book: ob.ToDTO()}
+	entry.Seq = ob.seq + 1
+	entry.Time = ob.clock.Now()
+	if err := ob.journal.Append(entry); err != nil {
+		Logger.Printf("Failed to write journal baseline: %s", err)
+		return
+	}
+	ob.seq = entry.Seq
+}
@@ -0,0 +1,278 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand/v2"
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// memoryJournal round-trips entries through JSON like a journal on disk.
+type memoryJournal struct {
+	lines [][]byte
+}
+
+func (m *memoryJournal) Append(entry *JournalEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	m.lines = append(m.lines, data)
+	return nil
+}
+
+func (m *memoryJournal) Entries() ([]*JournalEntry, error) {
+	var entries []*JournalEntry
+	for _, line := range m.lines {
+		var entry JournalEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, &entry)
+	}
+	return entries, nil
+}
+
+func bookJSON(t *testing.T, ob *OrderBook) string {
+	data, err := json.Marshal(ob.ToDTO())
+	if err != nil {
+		t.Fatalf("tests - marshal book failed. expected=%v, got=%v", nil, err)
+	}
+	return string(data)
+}
+
+func TestJournalReplayRebuildsBook(t *testing.T) {
+	journal := &memoryJournal{}
+	ob := NewOrderBook()
+	ob.AddJournal(journal)
+
+	rng := rand.New(rand.NewPCG(7, 7))
+	var ids []uuid.UUID
+	for range 2000 {
+		side := Side(rng.IntN(2))
+		price := 90 + rng.IntN(21)
+
+		switch rng.IntN(10) {
+		case 0:
+			ob.PlaceOrder(OrderRequest{Side: side, Type: Market, Size: 1 + rng.IntN(5)})
+		case 1:
+			result, _ := ob.PlaceOrder(OrderRequest{Side: side, Type: StopLimit, StopPrice: price, Price: price, Size: 1 + rng.IntN(5)})
+			ids = append(ids, result.Id)
+		case 2:
+			result, _ := ob.PlaceOrder(OrderRequest{Side: side, Type: Limit, Price: price, Size: 10, DisplaySize: 3})
+			ids = append(ids, result.Id)
+		case 3:
+			if len(ids) > 0 {
+				id := ids[rng.IntN(len(ids))]
+				if ob.CancelOrder(id) == ErrOrderNotFound {
+					ob.CancelStopOrder(id)
+				}
+			}
+		case 4:
+			if len(ids) > 0 {
+				ob.AmendOrder(ids[rng.IntN(len(ids))], price, 1+rng.IntN(10))
+			}
+		default:
+			id, _ := ob.ProcessOrder(side, price, 1+rng.IntN(10))
+			ids = append(ids, id)
+		}
+	}
+
+	replayed := NewOrderBook()
+	replayed.AddJournal(journal)
+	replayed.RestoreOrderBook()
+
+	if replayed.Seq() != ob.Seq() || ob.Seq() == 0 {
+		t.Fatalf("tests - replay should reach the same seq. expected=%d, got=%d", ob.Seq(), replayed.Seq())
+	}
+
+	if !reflect.DeepEqual(replayed.trades, ob.trades) {
+		t.Fatalf("tests - replay should produce the same trades. expected=%d, got=%d", len(ob.trades), len(replayed.trades))
+	}
+
+	if expected, got := bookJSON(t, ob), bookJSON(t, replayed); expected != got {
+		t.Fatalf("tests - replay should produce the same book. expected=%s, got=%s", expected, got)
+	}
+
+	for id, order := range ob.closed.orders {
+		if restored := replayed.closed.get(id); restored == nil || restored.Status != order.Status {
+			t.Fatalf("tests - replay should produce the same closed orders. expected=%+v, got=%+v", order, restored)
+		}
+	}
+}
+
+func TestTradesKeepExecutionOrder(t *testing.T) {
+	journal := &memoryJournal{}
+	ob := NewOrderBook()
+	ob.AddJournal(journal)
+	for _, price := range []int{42, 40, 41} {
+		ob.ProcessOrder(Sell, price, 1)
+	}
+	ob.ProcessOrder(Buy, 42, 3)
+
+	for i, trade := range ob.trades {
+		if trade.Seq != uint64(i+1) || trade.Price != 40+i {
+			t.Fatalf("tests - trades should be numbered in the order they were made. expected=%d at %d, got=%d at %d", i+1, 40+i, trade.Seq, trade.Price)
+		}
+		if i > 0 && !trade.Time.After(ob.trades[i-1].Time) {
+			t.Fatalf("tests - trades of one command should be stamped in order. expected=after %s, got=%s", ob.trades[i-1].Time, trade.Time)
+		}
+	}
+
+	replayed := NewOrderBook()
+	replayed.AddJournal(journal)
+	replayed.RestoreOrderBook()
+	if !reflect.DeepEqual(replayed.trades, ob.trades) {
+		t.Fatalf("tests - replay should stamp and number trades the same. expected=%v, got=%v", ob.trades, replayed.trades)
+	}
+}
+
+func TestJournalStartsFromStoredBook(t *testing.T) {
+	stored := NewOrderBook()
+	stored.ProcessOrder(Buy, 40, 2)
+	stored.ProcessOrder(Sell, 45, 1)
+	storage := &restoringStorage{book: stored.ToDTO()}
+
+	journal := &memoryJournal{}
+	ob := NewOrderBook()
+	ob.AddStorage(storage)
+	ob.AddJournal(journal)
+	ob.RestoreOrderBook()
+	ob.ProcessOrder(Sell, 40, 1)
+
+	replayed := NewOrderBook()
+	replayed.AddJournal(journal)
+	replayed.RestoreOrderBook()
+
+	if expected, got := bookJSON(t, ob), bookJSON(t, replayed); expected != got {
+		t.Fatalf("tests - journal should start from the stored book. expected=%s, got=%s", expected, got)
+	}
+}
+
+func TestUnreadableJournalGetsNoBaseline(t *testing.T) {
+	stored := NewOrderBook()
+	stored.ProcessOrder(Buy, 40, 2)
+	journal := &unreadableJournal{}
+
+	ob := NewOrderBook()
+	ob.AddStorage(&restoringStorage{book: stored.ToDTO()})
+	ob.AddJournal(journal)
+	ob.RestoreOrderBook()
+
+	if len(journal.appended) != 0 {
+		t.Fatalf("tests - no baseline should go on top of an unreadable journal. expected=%d, got=%d", 0, len(journal.appended))
+	}
+	if len(ob.orders) != 1 || !errors.Is(ob.Degraded(), ErrStorage) {
+		t.Fatalf("tests - book should be restored from storage and degraded. expected=%s, got=%v", ErrStorage, ob.Degraded())
+	}
+	if _, err := ob.ProcessOrder(Sell, 45, 1); !errors.Is(err, ErrDegraded) || len(journal.appended) != 0 {
+		t.Fatalf("tests - commands should be refused. expected=%s, got=%v", ErrDegraded, err)
+	}
+}
+
+func TestSnapshotAndJournalTailRebuildBook(t *testing.T) {
+	journal := &memoryJournal{}
+	snapshots := &memorySnapshots{}
+	ob := NewOrderBook()
+	ob.AddJournal(journal)
+	ob.AddSnapshots(snapshots)
+	sequencer := NewSequencer(ob, 1)
+	defer sequencer.Close()
+
+	sequencer.ProcessOrder(Buy, 40, 5)
+	sequencer.ProcessOrder(Sell, 45, 3)
+	sequencer.PlaceOrder(OrderRequest{Side: Sell, Type: StopLimit, StopPrice: 38, Price: 37, Size: 2})
+	seq, err := sequencer.TakeSnapshot()
+	if err != nil || seq != 3 {
+		t.Fatalf("tests - snapshot should be taken at the last seq. expected=%d, got=%d (%v)", 3, seq, err)
+	}
+
+	sequencer.ProcessOrder(Sell, 40, 4)
+	sequencer.ProcessOrder(Buy, 46, 1)
+
+	// Entries up to the snapshot are no longer needed to recover.
+	journal.lines = journal.lines[seq:]
+
+	recovered := NewOrderBook()
+	recovered.AddJournal(journal)
+	recovered.AddSnapshots(snapshots)
+	recovered.RestoreOrderBook()
+
+	var expected string
+	sequencer.Read(func(ob *OrderBook) {
+		expected = bookJSON(t, ob)
+	})
+	if got := bookJSON(t, recovered); got != expected || recovered.Seq() != 5 {
+		t.Fatalf("tests - snapshot and journal tail should rebuild the book. expected=%s, got=%s", expected, got)
+	}
+}
+
+func TestFailedJournalAppendRejectsCommand(t *testing.T) {
+	ob := NewOrderBook()
+	ob.AddJournal(&failingJournal{})
+
+	if _, err := ob.ProcessOrder(Buy, 40, 1); err == nil || len(ob.orders) != 0 || ob.Seq() != 0 {
+		t.Fatalf("tests - command should not be applied. expected=%s, got=%v", ErrStorage, err)
+	}
+}
+
+type failingJournal struct{}
+
+func (f *failingJournal) Append(entry *JournalEntry) error {
+	return errStorageDown
+}
+
+func (f *failingJournal) Entries() ([]*JournalEntry, error) {
+	return nil, nil
+}
+
+// unreadableJournal can be appended to but not read back.
+type unreadableJournal struct {
+	appended []*JournalEntry
+}
+
+func (u *unreadableJournal) Append(entry *JournalEntry) error {
+	u.appended = append(u.appended, entry)
+	return nil
+}
+
+func (u *unreadableJournal) Entries() ([]*JournalEntry, error) {
+	return nil, errStorageDown
+}
+
+// memorySnapshots keeps the latest snapshot, round-tripped through JSON.
+type memorySnapshots struct {
+	seq  uint64
+	data []byte
+}
+
+func (m *memorySnapshots) Save(seq uint64, book *OrderBookDTO) error {
+	data, err := json.Marshal(book)
+	m.seq, m.data = seq, data
+	return err
+}
+
+func (m *memorySnapshots) Latest() (uint64, *OrderBookDTO, error) {
+	if m.data == nil {
+		return 0, nil, nil
+	}
+	var book OrderBookDTO
+	err := json.Unmarshal(m.data, &book)
+	return m.seq, &book, err
+}
+
+// restoringStorage restores a fixed book and stores nothing.
+type restoringStorage struct {
+	NilStorage
+	book *OrderBookDTO
+}
+
+func (r *restoringStorage) RestoreOrderBook() (*OrderBook, error) {
+	data, _ := json.Marshal(r.book)
+	var dto OrderBookDTO
+	json.Unmarshal(data, &dto)
+	return dto.ToOrderBook(), nil
+}
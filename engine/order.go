@@ -7,13 +7,87 @@ import (
 	"github.com/google/uuid"
 )
 
+type OrderType int
+
+const (
+	Limit OrderType = iota
+	Market
+	Stop
+	StopLimit
+)
+
+func (t OrderType) String() string {
+	switch t {
+	case Market:
+		return "MARKET"
+	case Stop:
+		return "STOP"
+	case StopLimit:
+		return "STOP_LIMIT"
+	}
+	return "LIMIT"
+}
+
+type TimeInForce int
+
+const (
+	GTC TimeInForce = iota
+	IOC
+	FOK
+)
+
+func (t TimeInForce) String() string {
+	switch t {
+	case IOC:
+		return "IOC"
+	case FOK:
+		return "FOK"
+	}
+	return "GTC"
+}
+
+type OrderStatus int
+
+const (
+	Open OrderStatus = iota
+	PartiallyFilled
+	Filled
+	Cancelled
+	Rejected
+	Pending
+)
+
+func (s OrderStatus) String() string {
+	switch s {
+	case PartiallyFilled:
+		return "PARTIALLY_FILLED"
+	case Filled:
+		return "FILLED"
+	case Cancelled:
+		return "CANCELLED"
+	case Rejected:
+		return "REJECTED"
+	case Pending:
+		return "PENDING"
+	}
+	return "OPEN"
+}
+
 type Order struct {
-	Id          uuid.UUID `json:"id"`
-	Side        Side `json:"side"`
-	Size        int `json:"size"`
-	Remaining   int `json:"remaining"`
-	Price       int `json:"price"`
-	Time        time.Time `json:"time"`
+	Id          uuid.UUID   `json:"id"`
+	Side        Side        `json:"side"`
+	Type        OrderType   `json:"type"`
+	TimeInForce TimeInForce `json:"time_in_force"`
+	PostOnly    bool        `json:"post_only"`
+	Size        int         `json:"size"`
+	Remaining   int         `json:"remaining"`
+	DisplaySize int         `json:"display_size,omitempty"`
+	Hidden      int         `json:"hidden,omitempty"`
+	Price       int         `json:"price"`
+	StopPrice   int         `json:"stop_price,omitempty"`
+	Time        time.Time   `json:"time"`
+	Status      OrderStatus `json:"status"`
+	Fills       []uuid.UUID `json:"fills"`
 	nextOrder   *Order
 	prevOrder   *Order
 	parentLevel *Level
@@ -23,6 +97,110 @@ func (o Order) Equals(other Order) bool {
 	return o.Id == other.Id
 }
 
+// crosses reports whether the order is willing to trade at price.
+// Market orders take any price.
+func (o *Order) crosses(price int) bool {
+	if o.Type == Market {
+		return true
+	}
+	return o.crossesAt(o.Price, price)
+}
+
+// crossesAt reports whether the order would trade at price if its limit
+// were limitPrice.
+func (o *Order) crossesAt(limitPrice int, price int) bool {
+	if o.Side == Buy {
+		return limitPrice >= price
+	}
+	return limitPrice <= price
+}
+
+func (o *Order) isStop() bool {
+	return o.Type == Stop || o.Type == StopLimit
+}
+
+// triggeredBy reports whether a trade at price sets off the stop order.
+// Buy stops trigger at or above their stop price, sell stops at or below.
+func (o *Order) triggeredBy(price int) bool {
+	if o.Side == Buy {
+		return price >= o.StopPrice
+	}
+	return price <= o.StopPrice
+}
+
+// activate turns a triggered stop order into the market or limit order
+// it stands for. It takes its place in time from the moment it triggers.
+func (o *Order) activate(now time.Time) {
+	if o.Type == Stop {
+		o.Type = Market
+	} else {
+		o.Type = Limit
+	}
+	o.Time = now
+}
+
+// splitReserve moves everything beyond the display size of an iceberg
+// into its hidden reserve before it rests.
+func (o *Order) splitReserve() {
+	if o.DisplaySize > 0 && o.Remaining > o.DisplaySize {
+		o.Hidden = o.Remaining - o.DisplaySize
+		o.Remaining = o.DisplaySize
+	}
+}
+
+// rests reports whether an unfilled remainder may be added to the book.
+func (o *Order) rests() bool {
+	return o.Type == Limit && o.TimeInForce == GTC
+}
+
+// OrderInfo is the externally visible state of an order. Remaining
+// includes any hidden iceberg reserve, and Fills lists the ids of the
+// trades the order took part in.
+type OrderInfo struct {
+	Id        uuid.UUID   `json:"id"`
+	Side      Side        `json:"side"`
+	Type      OrderType   `json:"type"`
+	Price     int         `json:"price"`
+	StopPrice int         `json:"stop_price,omitempty"`
+	Size      int         `json:"size"`
+	Remaining int         `json:"remaining"`
+	Status    string      `json:"status"`
+	Fills     []uuid.UUID `json:"fills"`
+	Time      time.Time   `json:"time"`
+}
+
+func (o *Order) info() *OrderInfo {
+	fills := make([]uuid.UUID, len(o.Fills))
+	copy(fills, o.Fills)
+
+	return &OrderInfo{
+		Id:        o.Id,
+		Side:      o.Side,
+		Type:      o.Type,
+		Price:     o.Price,
+		StopPrice: o.StopPrice,
+		Size:      o.Size,
+		Remaining: o.Remaining + o.Hidden,
+		Status:    o.currentStatus().String(),
+		Fills:     fills,
+		Time:      o.Time,
+	}
+}
+
+// currentStatus derives the status of orders still in play from how much
+// of them has been filled. Closed orders carry their final status.
+func (o *Order) currentStatus() OrderStatus {
+	switch {
+	case o.Status != Open:
+		return o.Status
+	case o.isStop():
+		return Pending
+	case o.Remaining+o.Hidden < o.Size:
+		return PartiallyFilled
+	}
+	return Open
+}
+
 func (o *Order) String() string {
 	var nextID, prevID string
 
@@ -39,12 +217,18 @@ func (o *Order) String() string {
 	}
 
 	return fmt.Sprintf(
-		"Order{\n\tid: %s\n\tside: %s\n\tsize: %d\n\tremaining: %d\n\tprice: %d\n\ttime: %s\n\tnextOrderId: %s\n\tprevOrderId: %s\n\t}\n",
+		"Order{\n\tid: %s\n\tside: %s\n\ttype: %s\n\ttimeInForce: %s\n\tpostOnly: %t\n\tsize: %d\n\tremaining: %d\n\tdisplaySize: %d\n\thidden: %d\n\tprice: %d\n\tstopPrice: %d\n\ttime: %s\n\tnextOrderId: %s\n\tprevOrderId: %s\n\t}\n",
 		o.Id.String(),
 		o.Side,
+		o.Type,
+		o.TimeInForce,
+		o.PostOnly,
 		o.Size,
 		o.Remaining,
+		o.DisplaySize,
+		o.Hidden,
 		o.Price,
+		o.StopPrice,
 		o.Time.Format(time.RFC3339),
 		nextID,
 		prevID,
@@ -53,12 +237,18 @@ func (o *Order) String() string {
 
 func (o *Order) ToDTO() *OrderDTO {
 	orderDTO := &OrderDTO{
-		Id:        o.Id,
-		Side:      o.Side,
-		Size:      o.Size,
-		Remaining: o.Remaining,
-		Price:     o.Price,
-		Time:      o.Time,
+		Id:          o.Id,
+		Side:        o.Side,
+		Type:        o.Type,
+		TimeInForce: o.TimeInForce,
+		PostOnly:    o.PostOnly,
+		Size:        o.Size,
+		Remaining:   o.Remaining,
+		DisplaySize: o.DisplaySize,
+		Hidden:      o.Hidden,
+		Price:       o.Price,
+		StopPrice:   o.StopPrice,
+		Time:        o.Time,
 	}
 	if o.nextOrder != nil {
 		orderDTO.NextID = &o.nextOrder.Id
@@ -0,0 +1,151 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Every command (placing, amending or cancelling an order) is applied in
+// memory and written to storage as it goes, inside one storage unit of
+// work that is committed when the command ends. Storage therefore only
+// ever holds whole commands. If the first storage write of a command
+// fails, the command is rolled back and the error, wrapping ErrStorage,
+// returned; the book carries on as if it never happened.
+//
+// A failure after the first write cannot be undone in memory, where the
+// command has already changed the book. The command then completes in
+// memory, which stays consistent, storage is rolled back to where the
+// command started and the book becomes degraded: storage writes stop and
+// every further command fails with ErrDegraded until Resync or
+// ResetOrderBook brings storage back in line. A failed commit degrades the
+// book the same way.
+var (
+	ErrStorage  = errors.New("storage failure")
+	ErrDegraded = errors.New("order book is degraded")
+)
+
+// begin starts a new command, refusing it if the book is degraded.
+func (ob *OrderBook) begin() error {
+	ob.written = false
+	return ob.health()
+}
+
+func (ob *OrderBook) health() error {
+	if ob.degraded != nil {
+		return fmt.Errorf("%w: %w", ErrDegraded, ob.degraded)
+	}
+	return nil
+}
+
+// persist runs one storage write of the current command. An error is only
+// returned for the command's first write, and the caller must then undo
+// its in-memory change. Later failures degrade the book instead and the
+// caller carries on.
+func (ob *OrderBook) persist(op string, write func() error) error {
+	if ob.degraded != nil {
+		return nil
+	}
+
+	if err := write(); err != nil {
+		err = fmt.Errorf("%w: %s: %w", ErrStorage, op, err)
+		if !ob.written {
+			return err
+		}
+		Logger.Printf("Storage failed mid-command, order book is degraded: %s", err)
+		ob.degraded = err
+		return nil
+	}
+
+	ob.written = true
+	return nil
+}
+
+// commit ends the unit of work of a command. Storage keeps the command
+// only if it succeeded and every write went through; otherwise storage is
+// rolled back.
+func (ob *OrderBook) commit(failed bool) {
+	if failed || ob.degraded != nil {
+		if err := ob.storage.Rollback(); err != nil {
+			Logger.Printf("Failed to roll back storage: %s", err)
+		}
+		return
+	}
+
+	if err := ob.storage.Commit(); err != nil && ob.written {
+		err = fmt.Errorf("%w: commit: %w", ErrStorage, err)
+		Logger.Printf("Storage failed to commit, order book is degraded: %s", err)
+		ob.degraded = err
+	}
+}
+
+// Degraded returns the storage error that degraded the book, or nil if
+// the book is healthy.
+func (ob *OrderBook) Degraded() error {
+	return ob.degraded
+}
+
+// DurableSeq is the sequence number of the last command storage is known
+// to hold. Storage written synchronously holds every applied command.
+func (ob *OrderBook) DurableSeq() uint64 {
+	if durable, ok := ob.storage.(interface{ DurableSeq() uint64 }); ok {
+		return durable.DurableSeq()
+	}
+	return ob.seq
+}
+
+// Resync rewrites storage from the in-memory book, which stays
+// authoritative while the book is degraded, and clears the degraded state
+// once every write has gone through. The rewrite is a single unit of work,
+// so a failed resync leaves storage as it was.
+func (ob *OrderBook) Resync() error {
+	if err := ob.storage.Begin(ob.seq); err != nil {
+		return fmt.Errorf("%w: begin: %w", ErrStorage, err)
+	}
+	if err := ob.resync(); err != nil {
+		if rollbackErr := ob.storage.Rollback(); rollbackErr != nil {
+			Logger.Printf("Failed to roll back storage: %s", rollbackErr)
+		}
+		return err
+	}
+	if err := ob.storage.Commit(); err != nil {
+		return fmt.Errorf("%w: commit: %w", ErrStorage, err)
+	}
+
+	if ob.degraded != nil {
+		Logger.Printf("Storage resynced, order book is no longer degraded")
+	}
+	ob.degraded = nil
+	return nil
+}
+
+func (ob *OrderBook) resync() error {
+	if err := ob.storage.ResetOrderBook(); err != nil {
+		return fmt.Errorf("%w: reset: %w", ErrStorage, err)
+	}
+
+	for side, best := range map[Side]*Level{Buy: ob.highestBid, Sell: ob.lowestAsk} {
+		for level := best; level != nil; level = level.nextLevel {
+			if err := ob.storage.InsertLevel(side, level.ToDTO()); err != nil {
+				return fmt.Errorf("%w: insert level: %w", ErrStorage, err)
+			}
+			for order := level.headOrder; order != nil; order = order.nextOrder {
+				if err := ob.storage.InsertOrder(order.ToDTO()); err != nil {
+					return fmt.Errorf("%w: insert order: %w", ErrStorage, err)
+				}
+			}
+		}
+	}
+
+	for i := range ob.trades {
+		if err := ob.storage.InsertTrade(&ob.trades[i]); err != nil {
+			return fmt.Errorf("%w: insert trade: %w", ErrStorage, err)
+		}
+	}
+
+	for _, order := range ob.stops.list() {
+		if err := ob.storage.InsertStopOrder(order.ToDTO()); err != nil {
+			return fmt.Errorf("%w: insert stop order: %w", ErrStorage, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,298 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrSequencerClosed = errors.New("sequencer is closed")
+	ErrNoSnapshots     = errors.New("order book has no snapshot store")
+)
+
+// Sequencer owns an OrderBook and is the only way to reach it once
+// started. Every command is queued on a channel and run by a single
+// goroutine, one at a time, so the book needs no locking. After each
+// command a Snapshot is published, which readers can use without going
+// through the queue.
+type Sequencer struct {
+	book      *OrderBook
+	commands  chan func()
+	snapshot  atomic.Pointer[Snapshot]
+	quit      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+
+	watchMu  sync.Mutex
+	watchers map[*watcher]struct{}
+}
+
+type watcher struct {
+	fn func(prev, next *Snapshot)
+}
+
+// Snapshot is a read-only copy of a book taken between two commands. Seq
+// is the sequence number of the last command applied and DurableSeq that
+// of the last one in storage when the snapshot was taken.
+type Snapshot struct {
+	Seq        uint64
+	DurableSeq uint64
+	Time       time.Time
+	View       OrderBookView
+	Instrument Instrument
+	Degraded   error
+}
+
+// NewSequencer starts the goroutine that runs commands against ob.
+// queueSize bounds how many commands can wait before callers block.
+func NewSequencer(ob *OrderBook, queueSize int) *Sequencer {
+	s := &Sequencer{
+		book:     ob,
+		commands: make(chan func(), queueSize),
+		quit:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	s.publish()
+
+	go s.run()
+	return s
+}
+
+func (s *Sequencer) run() {
+	defer close(s.stopped)
+	for {
+		select {
+		case command := <-s.commands:
+			command()
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+// Close stops the sequencer. Commands still queued are dropped and their
+// callers get ErrSequencerClosed.
+func (s *Sequencer) Close() {
+	s.closeOnce.Do(func() { close(s.quit) })
+	<-s.stopped
+}
+
+func (s *Sequencer) publish() {
+	next := &Snapshot{
+		Seq:        s.book.seq,
+		DurableSeq: s.book.DurableSeq(),
+		Time:       time.Now().UTC(),
+		View:       BuildOrderBookView(s.book),
+		Instrument: s.book.instrument,
+		Degraded:   s.book.degraded,
+	}
+	prev := s.snapshot.Swap(next)
+	if prev == nil {
+		return
+	}
+
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+	for w := range s.watchers {
+		w.fn(prev, next)
+	}
+}
+
+// Watch calls fn with the previous and the new snapshot every time one is
+// published, on the sequencer goroutine, until stop is called. fn holds up
+// every command, so it must be quick and must not block.
+func (s *Sequencer) Watch(fn func(prev, next *Snapshot)) (stop func()) {
+	w := &watcher{fn: fn}
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+	if s.watchers == nil {
+		s.watchers = make(map[*watcher]struct{})
+	}
+	s.watchers[w] = struct{}{}
+
+	return func() {
+		s.watchMu.Lock()
+		defer s.watchMu.Unlock()
+		delete(s.watchers, w)
+	}
+}
+
+// Snapshot returns the book as it was after the last command.
+func (s *Sequencer) Snapshot() *Snapshot {
+	return s.snapshot.Load()
+}
+
+// Do runs command on the sequencer goroutine and waits for it, then
+// publishes a new snapshot. command must not keep ob once it returns, nor
+// call back into the sequencer.
+func (s *Sequencer) Do(command func(ob *OrderBook) error) error {
+	return s.submit(command, true)
+}
+
+// Read runs query on the sequencer goroutine like Do, but without
+// publishing a snapshot, for lookups that don't change the book.
+func (s *Sequencer) Read(query func(ob *OrderBook)) error {
+	return s.submit(func(ob *OrderBook) error {
+		query(ob)
+		return nil
+	}, false)
+}
+
+func (s *Sequencer) submit(command func(ob *OrderBook) error, publish bool) error {
+	var err error
+	done := make(chan struct{})
+	run := func() {
+		err = command(s.book)
+		if publish {
+			s.publish()
+		}
+		close(done)
+	}
+
+	select {
+	case s.commands <- run:
+	case <-s.quit:
+		return ErrSequencerClosed
+	}
+
+	select {
+	case <-done:
+		return err
+	case <-s.stopped:
+		select {
+		case <-done:
+			return err
+		default:
+			return ErrSequencerClosed
+		}
+	}
+}
+
+func (s *Sequencer) PlaceOrder(req OrderRequest) (OrderResult, error) {
+	var result OrderResult
+	err := s.Do(func(ob *OrderBook) error {
+		var err error
+		result, err = ob.PlaceOrder(req)
+		return err
+	})
+	return result, err
+}
+
+func (s *Sequencer) ProcessOrder(side Side, price int, size int) (uuid.UUID, error) {
+	var id uuid.UUID
+	err := s.Do(func(ob *OrderBook) error {
+		var err error
+		id, err = ob.ProcessOrder(side, price, size)
+		return err
+	})
+	return id, err
+}
+
+func (s *Sequencer) AmendOrder(id uuid.UUID, newPrice int, newSize int) (OrderResult, error) {
+	var result OrderResult
+	err := s.Do(func(ob *OrderBook) error {
+		var err error
+		result, err = ob.AmendOrder(id, newPrice, newSize)
+		return err
+	})
+	return result, err
+}
+
+func (s *Sequencer) CancelOrder(id uuid.UUID) error {
+	return s.Do(func(ob *OrderBook) error {
+		return ob.CancelOrder(id)
+	})
+}
+
+func (s *Sequencer) CancelStopOrder(id uuid.UUID) error {
+	return s.Do(func(ob *OrderBook) error {
+		return ob.CancelStopOrder(id)
+	})
+}
+
+func (s *Sequencer) ResetOrderBook() error {
+	return s.Do(func(ob *OrderBook) error {
+		return ob.ResetOrderBook()
+	})
+}
+
+func (s *Sequencer) Resync() error {
+	return s.Do(func(ob *OrderBook) error {
+		return ob.Resync()
+	})
+}
+
+// TakeSnapshot saves a copy of the book to its snapshot store. Only
+// copying the book holds up the sequencer; it is written out afterwards.
+func (s *Sequencer) TakeSnapshot() (uint64, error) {
+	if s.book.snapshots == nil {
+		return 0, ErrNoSnapshots
+	}
+
+	var seq uint64
+	var book *OrderBookDTO
+	if err := s.Read(func(ob *OrderBook) {
+		seq, book = ob.seq, ob.ToDTO()
+		book.Trades = slices.Clip(book.Trades)
+	}); err != nil {
+		return 0, err
+	}
+	if err := s.book.snapshots.Save(seq, book); err != nil {
+		return 0, fmt.Errorf("%w: snapshot: %w", ErrStorage, err)
+	}
+	return seq, nil
+}
+
+// Check runs the book's consistency checks between two commands.
+func (s *Sequencer) Check() (report *CheckReport, err error) {
+	if rerr := s.Read(func(ob *OrderBook) {
+		report, err = ob.Check()
+	}); rerr != nil {
+		return nil, rerr
+	}
+	return report, err
+}
+
+// OrdersView returns the orders of the depth best levels of each side,
+// or of every level if depth is 0, with the snapshot of the same moment.
+func (s *Sequencer) OrdersView(depth int) (view BookOrdersView, snapshot *Snapshot, err error) {
+	err = s.Read(func(ob *OrderBook) {
+		view = BuildBookOrdersView(ob, depth)
+		snapshot = s.Snapshot()
+	})
+	return view, snapshot, err
+}
+
+func (s *Sequencer) GetOrder(id uuid.UUID) (order *OrderDTO, ok bool) {
+	s.Read(func(ob *OrderBook) {
+		order, ok = ob.GetOrder(id)
+	})
+	return order, ok
+}
+
+func (s *Sequencer) OrderStatus(id uuid.UUID) (info *OrderInfo, ok bool) {
+	s.Read(func(ob *OrderBook) {
+		info, ok = ob.OrderStatus(id)
+	})
+	return info, ok
+}
+
+func (s *Sequencer) StopOrders() (stops []*OrderDTO) {
+	s.Read(func(ob *OrderBook) {
+		stops = ob.StopOrders()
+	})
+	return stops
+}
+
+func (s *Sequencer) String() (str string) {
+	s.Read(func(ob *OrderBook) {
+		str = ob.String()
+	})
+	return str
+}
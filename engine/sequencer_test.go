@@ -0,0 +1,142 @@
+package engine
+
+import (
+	"math/rand/v2"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestSequencerConcurrentClients(t *testing.T) {
+	seq := NewSequencer(NewOrderBook(), 64)
+	defer seq.Close()
+
+	const clients = 32
+	const ordersPerClient = 200
+
+	var wg sync.WaitGroup
+	for c := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rng := rand.New(rand.NewPCG(uint64(c), 0))
+
+			var ids []uuid.UUID
+			for range ordersPerClient {
+				side := Side(rng.IntN(2))
+				price := 90 + rng.IntN(21)
+
+				switch rng.IntN(6) {
+				case 0:
+					seq.PlaceOrder(OrderRequest{Side: side, Type: Market, Size: 1 + rng.IntN(5)})
+				case 1:
+					if len(ids) > 0 {
+						seq.CancelOrder(ids[rng.IntN(len(ids))])
+					}
+				case 2:
+					if len(ids) > 0 {
+						seq.AmendOrder(ids[rng.IntN(len(ids))], price, 1+rng.IntN(10))
+					}
+				case 3:
+					view := seq.Snapshot().View
+					for i := 1; i < len(view.Bids); i++ {
+						if view.Bids[i-1].Price <= view.Bids[i].Price {
+							t.Errorf("tests - snapshot bids out of order. expected=%s, got=%+v", "descending", view.Bids)
+						}
+					}
+					if len(ids) > 0 {
+						seq.OrderStatus(ids[rng.IntN(len(ids))])
+					}
+				default:
+					id, err := seq.ProcessOrder(side, price, 1+rng.IntN(10))
+					if err != nil {
+						t.Errorf("tests - ProcessOrder failed. expected=%v, got=%v", nil, err)
+					}
+					ids = append(ids, id)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	seq.Read(func(ob *OrderBook) {
+		for side, levels := range ob.levels {
+			for price, level := range levels {
+				volume, count := 0, 0
+				for o := level.headOrder; o != nil; o = o.nextOrder {
+					volume += o.Remaining
+					count++
+				}
+				if volume != level.Volume || count != level.Count {
+					t.Errorf("tests - level %s %d out of sync with its orders. expected=%d/%d, got=%d/%d",
+						side, price, volume, count, level.Volume, level.Count)
+				}
+			}
+		}
+
+		if ob.highestBid != nil && ob.lowestAsk != nil && ob.highestBid.Price >= ob.lowestAsk.Price {
+			t.Errorf("tests - book is crossed. expected=%d<%d, got crossed", ob.highestBid.Price, ob.lowestAsk.Price)
+		}
+	})
+
+	if snapshot := seq.Snapshot(); snapshot.Seq == 0 {
+		t.Fatalf("tests - snapshots should be published. expected=%s, got=%d", ">0", snapshot.Seq)
+	}
+}
+
+func TestSequencerClosed(t *testing.T) {
+	seq := NewSequencer(NewOrderBook(), 1)
+	seq.Close()
+
+	if _, err := seq.ProcessOrder(Buy, 10, 1); err != ErrSequencerClosed {
+		t.Fatalf("tests - closed sequencer should refuse commands. expected=%v, got=%v", ErrSequencerClosed, err)
+	}
+}
+
+func TestSequencerWatchDiffsRebuildBook(t *testing.T) {
+	seq := NewSequencer(NewOrderBook(), 64)
+	defer seq.Close()
+
+	// Apply every published diff to a copy of the first snapshot.
+	bids, asks := map[int]int{}, map[int]int{}
+	var trades []Trade
+	var seqs []uint64
+	stop := seq.Watch(func(prev, next *Snapshot) {
+		changes, made := DiffViews(prev.View, next.View)
+		for _, c := range changes {
+			levels := bids
+			if c.Side == Sell {
+				levels = asks
+			}
+			if c.Volume == 0 {
+				delete(levels, c.Price)
+			} else {
+				levels[c.Price] = c.Volume
+			}
+		}
+		trades = append(trades, made...)
+		seqs = append(seqs, next.Seq)
+	})
+
+	seq.ProcessOrder(Buy, 40, 5)
+	seq.ProcessOrder(Buy, 39, 2)
+	seq.ProcessOrder(Sell, 42, 3)
+	seq.ProcessOrder(Sell, 40, 5)
+	id, _ := seq.ProcessOrder(Sell, 43, 1)
+	seq.CancelOrder(id)
+	seq.AmendOrder(id, 44, 1)
+	stop()
+	seq.ProcessOrder(Buy, 30, 1)
+
+	view := seq.Snapshot().View
+	if len(bids) != 1 || bids[39] != 2 || len(asks) != 1 || asks[42] != 3 {
+		t.Fatalf("tests - diffs should rebuild the book. expected=%+v %+v, got=%v %v", view.Bids[:1], view.Asks, bids, asks)
+	}
+	if len(trades) != 1 || trades[0].Price != 40 || trades[0].Size != 5 {
+		t.Fatalf("tests - diffs should carry the trades. expected=%d, got=%+v", 1, trades)
+	}
+	if len(seqs) != 7 || seqs[0] != 1 || seqs[5] != 6 {
+		t.Fatalf("tests - one diff per command until stopped. expected=%d, got=%v", 7, seqs)
+	}
+}
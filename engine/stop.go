@@ -0,0 +1,104 @@
+package engine
+
+import (
+	"sort"
+
+	"github.com/google/uuid"
+)
+
+// stopBook holds stop orders off the book until a trade triggers them.
+// Buy stops are kept by ascending stop price and sell stops by descending
+// stop price, so the orders a trade sets off are always at the front.
+// Orders with the same stop price stay in arrival order.
+type stopBook struct {
+	buys   []*Order
+	sells  []*Order
+	orders map[uuid.UUID]*Order
+}
+
+func newStopBook() *stopBook {
+	return &stopBook{
+		orders: make(map[uuid.UUID]*Order),
+	}
+}
+
+func (sb *stopBook) add(order *Order) {
+	sb.orders[order.Id] = order
+	if order.Side == Buy {
+		i := sort.Search(len(sb.buys), func(i int) bool {
+			return sb.buys[i].StopPrice > order.StopPrice
+		})
+		sb.buys = insertOrder(sb.buys, i, order)
+	} else {
+		i := sort.Search(len(sb.sells), func(i int) bool {
+			return sb.sells[i].StopPrice < order.StopPrice
+		})
+		sb.sells = insertOrder(sb.sells, i, order)
+	}
+}
+
+func (sb *stopBook) remove(id uuid.UUID) *Order {
+	order, ok := sb.orders[id]
+	if !ok {
+		return nil
+	}
+	delete(sb.orders, id)
+
+	if order.Side == Buy {
+		sb.buys = removeOrder(sb.buys, order)
+	} else {
+		sb.sells = removeOrder(sb.sells, order)
+	}
+	return order
+}
+
+// trigger removes and returns every stop order set off by a trade at price.
+func (sb *stopBook) trigger(price int) []*Order {
+	var triggered []*Order
+
+	n := 0
+	for n < len(sb.buys) && sb.buys[n].triggeredBy(price) {
+		n++
+	}
+	triggered = append(triggered, sb.buys[:n]...)
+	sb.buys = sb.buys[n:]
+
+	n = 0
+	for n < len(sb.sells) && sb.sells[n].triggeredBy(price) {
+		n++
+	}
+	triggered = append(triggered, sb.sells[:n]...)
+	sb.sells = sb.sells[n:]
+
+	for _, order := range triggered {
+		delete(sb.orders, order.Id)
+	}
+	return triggered
+}
+
+// list returns the pending stop orders, oldest first.
+func (sb *stopBook) list() []*Order {
+	orders := make([]*Order, 0, len(sb.orders))
+	orders = append(orders, sb.buys...)
+	orders = append(orders, sb.sells...)
+	sort.SliceStable(orders, func(i, j int) bool {
+		return orders[i].Time.Before(orders[j].Time)
+	})
+	return orders
+}
+
+func insertOrder(orders []*Order, i int, order *Order) []*Order {
+	orders = append(orders, nil)
+	copy(orders[i+1:], orders[i:])
+	orders[i] = order
+	return orders
+}
+
+func removeOrder(orders []*Order, order *Order) []*Order {
+	for i, o := range orders {
+		if o == order {
+			return append(orders[:i], orders[i+1:]...)
+		}
+	}
+	return orders
+}
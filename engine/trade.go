@@ -7,8 +7,11 @@ import (
 	"github.com/google/uuid"
 )
 
+// Trade is one fill. Seq numbers the trades of a book in the order they
+// were made, from 1; trades stored before it was kept have 0.
 type Trade struct {
 	ID 	 uuid.UUID
+	Seq      uint64
 	Price    int
 	Size     int
 	Time     time.Time
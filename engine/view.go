@@ -2,12 +2,17 @@ package engine
 
 import (
 	"os"
+	"slices"
 	"sort"
+	"time"
+
+	"github.com/google/uuid"
 )
 
 type LevelView struct {
 	Price  int
 	Volume int
+	Count  int
 }
 
 type OrderBookView struct {
@@ -15,6 +20,8 @@ type OrderBookView struct {
 	Asks     []LevelView
 	Trades   []Trade
 	Hostname string
+	Symbol   string
+	Symbols  []string
 }
 
 func BuildOrderBookView(ob *OrderBook) OrderBookView {
@@ -24,6 +31,7 @@ func BuildOrderBookView(ob *OrderBook) OrderBookView {
 		view.Bids = append(view.Bids, LevelView{
 			Price:  price,
 			Volume: level.Volume,
+			Count:  level.Count,
 		})
 	}
 
@@ -31,10 +39,12 @@ func BuildOrderBookView(ob *OrderBook) OrderBookView {
 		view.Asks = append(view.Asks, LevelView{
 			Price:  price,
 			Volume: level.Volume,
+			Count:  level.Count,
 		})
 	}
 
-	view.Trades = ob.trades
+	// Clipped so a snapshot holding the view never sees later appends.
+	view.Trades = slices.Clip(ob.trades)
 
 	sort.Slice(view.Bids, func(i, j int) bool {
 		return view.Bids[i].Price > view.Bids[j].Price
@@ -53,3 +63,99 @@ func BuildOrderBookView(ob *OrderBook) OrderBookView {
 
 	return view
 }
+
+// LevelChange is the new volume at a price. Zero volume means the level
+// is gone.
+type LevelChange struct {
+	Side   Side
+	Price  int
+	Volume int
+}
+
+// DiffViews returns the levels whose volume changed between two views of
+// a book, bids before asks, and the trades made in between.
+func DiffViews(prev, next OrderBookView) ([]LevelChange, []Trade) {
+	var changes []LevelChange
+	changes = diffLevels(changes, Buy, prev.Bids, next.Bids)
+	changes = diffLevels(changes, Sell, prev.Asks, next.Asks)
+	return changes, NewTrades(prev, next)
+}
+
+// NewTrades returns the trades made between two views of a book, in the
+// order they were made.
+func NewTrades(prev, next OrderBookView) []Trade {
+	// Trades only grow, unless the book was reset.
+	if len(next.Trades) > len(prev.Trades) {
+		return next.Trades[len(prev.Trades):]
+	}
+	return nil
+}
+
+func diffLevels(changes []LevelChange, side Side, prev, next []LevelView) []LevelChange {
+	volumes := make(map[int]int, len(prev))
+	for _, level := range prev {
+		volumes[level.Price] = level.Volume
+	}
+	for _, level := range next {
+		if volume, ok := volumes[level.Price]; !ok || volume != level.Volume {
+			changes = append(changes, LevelChange{Side: side, Price: level.Price, Volume: level.Volume})
+		}
+		delete(volumes, level.Price)
+	}
+
+	var gone []int
+	for price := range volumes {
+		gone = append(gone, price)
+	}
+	sort.Ints(gone)
+	for _, price := range gone {
+		changes = append(changes, LevelChange{Side: side, Price: price})
+	}
+	return changes
+}
+
+// OrderView is a resting order as the market sees it: only the visible
+// part of an iceberg counts towards Size.
+type OrderView struct {
+	ID   uuid.UUID
+	Size int
+	Time time.Time
+}
+
+// LevelOrdersView is a level with its orders in the order they fill.
+type LevelOrdersView struct {
+	LevelView
+	Orders []OrderView
+}
+
+// BookOrdersView holds the best levels of each side of a book, best
+// first, with their orders.
+type BookOrdersView struct {
+	Bids []LevelOrdersView
+	Asks []LevelOrdersView
+}
+
+// BuildBookOrdersView walks the depth best levels of each side, or every
+// level if depth is 0. It reads the order lists, so it must run between
+// two commands.
+func BuildBookOrdersView(ob *OrderBook, depth int) BookOrdersView {
+	return BookOrdersView{
+		Bids: levelOrders(ob.highestBid, depth),
+		Asks: levelOrders(ob.lowestAsk, depth),
+	}
+}
+
+func levelOrders(best *Level, depth int) []LevelOrdersView {
+	levels := []LevelOrdersView{}
+	for level := best; level != nil && (depth == 0 || len(levels) < depth); level = level.nextLevel {
+		view := LevelOrdersView{
+			LevelView: LevelView{Price: level.Price, Volume: level.Volume, Count: level.Count},
+			Orders:    make([]OrderView, 0, level.Count),
+		}
+		for order := level.headOrder; order != nil; order = order.nextOrder {
+			view.Orders = append(view.Orders, OrderView{ID: order.Id, Size: order.Remaining, Time: order.Time})
+		}
+		levels = append(levels, view)
+	}
+	return levels
+}
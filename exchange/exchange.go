@@ -0,0 +1,314 @@
+package exchange
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"limit-order-book/engine"
+)
+
+var Logger *log.Logger
+
+// DefaultSymbol is the instrument that books created before symbols
+// existed are stored under.
+const DefaultSymbol = "DEFAULT"
+
+var (
+	ErrInvalidSymbol     = errors.New("invalid symbol")
+	ErrSymbolExists      = errors.New("symbol already exists")
+	ErrInvalidInstrument = errors.New("invalid instrument")
+)
+
+var symbolPattern = regexp.MustCompile(`^[A-Z0-9][A-Z0-9._-]{0,31}$`)
+
+// SymbolStore persists the set of symbols and their instrument specs so
+// books created through the admin API come back after a restart.
+type SymbolStore interface {
+	LoadSymbols() ([]engine.Instrument, error)
+	SaveSymbol(instrument engine.Instrument) error
+}
+
+// commandQueueSize bounds the commands waiting on each book's sequencer.
+const commandQueueSize = 1024
+
+// Exchange holds one order book per symbol, each run by its own
+// sequencer. Each book gets its own storage from newStorage so it is
+// persisted and restored on its own.
+type Exchange struct {
+	mu         sync.RWMutex
+	books      map[string]*engine.Sequencer
+	opening    map[string]bool // symbols being restored, not yet in books
+	closers    []io.Closer
+	newStorage func(symbol string) engine.Storage
+	newJournal func(symbol string) engine.Journal
+	snapshots  func(symbol string) engine.SnapshotStore
+	onOpen     []func(symbol string, book *engine.Sequencer)
+	symbols    SymbolStore
+	stop       chan struct{}
+	stopped    chan struct{}
+}
+
+// Config lists the instruments to trade. Spec fields left out fall back
+// to engine.DefaultInstrument.
+type Config struct {
+	Symbols []engine.Instrument `json:"symbols"`
+}
+
+func NewExchange(newStorage func(symbol string) engine.Storage, symbols SymbolStore) *Exchange {
+	return &Exchange{
+		books:      make(map[string]*engine.Sequencer),
+		opening:    make(map[string]bool),
+		newStorage: newStorage,
+		symbols:    symbols,
+	}
+}
+
+// SetJournal gives every book opened from now on a command journal from
+// newJournal. Books with a journal are restored by replaying it.
+func (e *Exchange) SetJournal(newJournal func(symbol string) engine.Journal) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.newJournal = newJournal
+}
+
+// SetSnapshots gives every book opened from now on a snapshot store from
+// newSnapshots. Books with a journal are restored from their latest
+// snapshot and the journal after it.
+func (e *Exchange) SetSnapshots(newSnapshots func(symbol string) engine.SnapshotStore) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.snapshots = newSnapshots
+}
+
+// WatchBooks calls fn with every open book, and with every book opened
+// from now on before it takes its first command, to watch it.
+func (e *Exchange) WatchBooks(fn func(symbol string, book *engine.Sequencer)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for symbol, book := range e.books {
+		fn(symbol, book)
+	}
+	e.onOpen = append(e.onOpen, fn)
+}
+
+// StartSnapshots snapshots every book that has changed since its last
+// snapshot once per interval, until the exchange is closed.
+func (e *Exchange) StartSnapshots(interval time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.stop != nil {
+		return
+	}
+	e.stop = make(chan struct{})
+	e.stopped = make(chan struct{})
+
+	go func() {
+		defer close(e.stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		taken := make(map[string]uint64)
+		for {
+			select {
+			case <-ticker.C:
+				for _, symbol := range e.Symbols() {
+					book, ok := e.Book(symbol)
+					if !ok || book.Snapshot().Seq == taken[symbol] {
+						continue
+					}
+					seq, err := book.TakeSnapshot()
+					if err != nil {
+						Logger.Printf("Failed to snapshot %s: %s\n", symbol, err)
+						continue
+					}
+					taken[symbol] = seq
+				}
+			case <-e.stop:
+				return
+			}
+		}
+	}()
+}
+
+// LoadConfig reads a JSON config file listing the instruments to trade.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return &config, nil
+}
+
+// Restore opens a book for every symbol in the config and every symbol
+// previously saved to the symbol store. A symbol in both keeps the spec
+// from the config.
+func (e *Exchange) Restore(config *Config) error {
+	var instruments []engine.Instrument
+	if config != nil {
+		instruments = append(instruments, config.Symbols...)
+	}
+
+	saved, err := e.symbols.LoadSymbols()
+	if err != nil {
+		return err
+	}
+	instruments = append(instruments, saved...)
+
+	for _, instrument := range instruments {
+		if _, err := e.AddSymbol(instrument); err != nil && !errors.Is(err, ErrSymbolExists) {
+			return fmt.Errorf("%s: %w", instrument.Symbol, err)
+		}
+	}
+	return nil
+}
+
+// AddSymbol creates the order book for a new instrument, restores
+// whatever its storage already holds and starts its sequencer.
+func (e *Exchange) AddSymbol(instrument engine.Instrument) (*engine.Sequencer, error) {
+	if !symbolPattern.MatchString(instrument.Symbol) {
+		return nil, ErrInvalidSymbol
+	}
+	instrument = instrument.WithDefaults()
+	if err := instrument.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidInstrument, err)
+	}
+
+	// The symbol is held while its book is restored, which can take long,
+	// so other books stay usable meanwhile.
+	e.mu.Lock()
+	if _, ok := e.books[instrument.Symbol]; ok || e.opening[instrument.Symbol] {
+		e.mu.Unlock()
+		return nil, ErrSymbolExists
+	}
+	e.opening[instrument.Symbol] = true
+	newJournal, snapshots := e.newJournal, e.snapshots
+	e.mu.Unlock()
+	defer func() {
+		e.mu.Lock()
+		delete(e.opening, instrument.Symbol)
+		e.mu.Unlock()
+	}()
+
+	if err := e.symbols.SaveSymbol(instrument); err != nil {
+		return nil, err
+	}
+
+	ob := engine.NewOrderBook()
+	ob.SetInstrument(instrument)
+	storage := e.newStorage(instrument.Symbol)
+	ob.AddStorage(storage)
+	if newJournal != nil {
+		ob.AddJournal(newJournal(instrument.Symbol))
+	}
+	if snapshots != nil {
+		ob.AddSnapshots(snapshots(instrument.Symbol))
+	}
+	ob.RestoreOrderBook()
+	book := engine.NewSequencer(ob, commandQueueSize)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if closer, ok := storage.(io.Closer); ok {
+		e.closers = append(e.closers, closer)
+	}
+	for _, fn := range e.onOpen {
+		fn(instrument.Symbol, book)
+	}
+	e.books[instrument.Symbol] = book
+
+	Logger.Printf("Opened order book for %s\n", instrument.Symbol)
+	return book, nil
+}
+
+func (e *Exchange) Book(symbol string) (*engine.Sequencer, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	ob, ok := e.books[symbol]
+	return ob, ok
+}
+
+// Instruments returns the spec of every traded symbol in alphabetical
+// order.
+func (e *Exchange) Instruments() []engine.Instrument {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	instruments := make([]engine.Instrument, 0, len(e.books))
+	for _, book := range e.books {
+		instruments = append(instruments, book.Snapshot().Instrument)
+	}
+	sort.Slice(instruments, func(i, j int) bool {
+		return instruments[i].Symbol < instruments[j].Symbol
+	})
+	return instruments
+}
+
+// Symbols returns every traded symbol in alphabetical order.
+func (e *Exchange) Symbols() []string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	symbols := make([]string, 0, len(e.books))
+	for symbol := range e.books {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	return symbols
+}
+
+// Close stops periodic snapshots and the sequencer of every book, then
+// closes their storage, which flushes any writes still queued.
+func (e *Exchange) Close() {
+	e.mu.Lock()
+	stop, stopped := e.stop, e.stopped
+	e.stop = nil
+	e.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-stopped
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, book := range e.books {
+		book.Close()
+	}
+	for _, closer := range e.closers {
+		if err := closer.Close(); err != nil {
+			Logger.Printf("Failed to close storage: %s\n", err)
+		}
+	}
+	e.closers = nil
+}
+
+// NilSymbolStore keeps no record of symbols; only configured symbols
+// survive a restart.
+type NilSymbolStore struct{}
+
+func (n *NilSymbolStore) LoadSymbols() ([]engine.Instrument, error) {
+	return nil, nil
+}
+
+func (n *NilSymbolStore) SaveSymbol(instrument engine.Instrument) error {
+	return nil
+}
@@ -0,0 +1,175 @@
+package exchange
+
+import (
+	"errors"
+	"io"
+	"log"
+	"testing"
+
+	"limit-order-book/engine"
+)
+
+func TestMain(m *testing.M) {
+	Logger = log.New(io.Discard, "", 0)
+	engine.Logger = Logger
+	m.Run()
+}
+
+type memorySymbolStore struct {
+	symbols []engine.Instrument
+}
+
+func (m *memorySymbolStore) LoadSymbols() ([]engine.Instrument, error) {
+	return m.symbols, nil
+}
+
+func (m *memorySymbolStore) SaveSymbol(instrument engine.Instrument) error {
+	for i, s := range m.symbols {
+		if s.Symbol == instrument.Symbol {
+			m.symbols[i] = instrument
+			return nil
+		}
+	}
+	m.symbols = append(m.symbols, instrument)
+	return nil
+}
+
+func symbol(s string) engine.Instrument {
+	return engine.Instrument{Symbol: s}
+}
+
+func newTestExchange(store SymbolStore) *Exchange {
+	return NewExchange(func(symbol string) engine.Storage {
+		return &engine.NilStorage{}
+	}, store)
+}
+
+func TestBooksAreIndependent(t *testing.T) {
+	ex := newTestExchange(&NilSymbolStore{})
+
+	btc, err := ex.AddSymbol(symbol("BTC-USD"))
+	if err != nil {
+		t.Fatalf("tests - AddSymbol failed. expected=%v, got=%v", nil, err)
+	}
+	eth, _ := ex.AddSymbol(symbol("ETH-USD"))
+
+	btc.ProcessOrder(engine.Buy, 100, 1)
+	eth.ProcessOrder(engine.Sell, 100, 1)
+
+	if len(btc.Snapshot().View.Asks) != 0 || len(eth.Snapshot().View.Bids) != 0 {
+		t.Fatalf("tests - orders should not cross between symbols. expected=%d, got=%+v", 0, btc.Snapshot().View)
+	}
+
+	if book, ok := ex.Book("BTC-USD"); !ok || book != btc {
+		t.Fatalf("tests - wrong book for symbol. expected=%p, got=%p", btc, book)
+	}
+
+	symbols := ex.Symbols()
+	if len(symbols) != 2 || symbols[0] != "BTC-USD" || symbols[1] != "ETH-USD" {
+		t.Fatalf("tests - wrong symbols. expected=%v, got=%v", []string{"BTC-USD", "ETH-USD"}, symbols)
+	}
+}
+
+func TestAddSymbolRejectsInvalidAndDuplicate(t *testing.T) {
+	ex := newTestExchange(&NilSymbolStore{})
+
+	for _, s := range []string{"", "btc", "BTC/USD", "A-VERY-LONG-SYMBOL-NAME-THAT-GOES-ON"} {
+		if _, err := ex.AddSymbol(symbol(s)); err != ErrInvalidSymbol {
+			t.Fatalf("tests - symbol %q should be invalid. expected=%v, got=%v", s, ErrInvalidSymbol, err)
+		}
+	}
+
+	ex.AddSymbol(symbol("BTC-USD"))
+	if _, err := ex.AddSymbol(symbol("BTC-USD")); err != ErrSymbolExists {
+		t.Fatalf("tests - duplicate symbol should fail. expected=%v, got=%v", ErrSymbolExists, err)
+	}
+}
+
+func TestRestoreMergesConfigAndSavedSymbols(t *testing.T) {
+	store := &memorySymbolStore{symbols: []engine.Instrument{symbol("ETH-USD"), {Symbol: "BTC-USD", TickSize: 5}}}
+	ex := newTestExchange(store)
+
+	err := ex.Restore(&Config{Symbols: []engine.Instrument{{Symbol: "BTC-USD", TickSize: 10}, symbol("SOL-USD")}})
+	if err != nil {
+		t.Fatalf("tests - Restore failed. expected=%v, got=%v", nil, err)
+	}
+
+	symbols := ex.Symbols()
+	if len(symbols) != 3 {
+		t.Fatalf("tests - wrong symbols after restore. expected=%d, got=%v", 3, symbols)
+	}
+
+	if len(store.symbols) != 3 {
+		t.Fatalf("tests - configured symbols should be saved. expected=%d, got=%v", 3, store.symbols)
+	}
+
+	btc, _ := ex.Book("BTC-USD")
+	if btc.Snapshot().Instrument.TickSize != 10 {
+		t.Fatalf("tests - config spec should win over saved spec. expected=%d, got=%d", 10, btc.Snapshot().Instrument.TickSize)
+	}
+}
+
+func TestAddSymbolRejectsInvalidSpec(t *testing.T) {
+	ex := newTestExchange(&NilSymbolStore{})
+
+	_, err := ex.AddSymbol(engine.Instrument{Symbol: "BTC-USD", MinSize: 10, MaxSize: 5})
+	if !errors.Is(err, ErrInvalidInstrument) {
+		t.Fatalf("tests - inconsistent spec should fail. expected=%v, got=%v", ErrInvalidInstrument, err)
+	}
+
+	if _, ok := ex.Book("BTC-USD"); ok {
+		t.Fatalf("tests - no book should be opened for an invalid spec. expected=%v, got=%v", false, ok)
+	}
+}
+
+// slowStorage holds up restoring its book until release is closed.
+type slowStorage struct {
+	engine.NilStorage
+	restoring chan struct{}
+	release   chan struct{}
+}
+
+func (s *slowStorage) RestoreOrderBook() (*engine.OrderBook, error) {
+	close(s.restoring)
+	<-s.release
+	return s.NilStorage.RestoreOrderBook()
+}
+
+func TestAddSymbolRestoresOutsideLock(t *testing.T) {
+	slow := &slowStorage{restoring: make(chan struct{}), release: make(chan struct{})}
+	ex := NewExchange(func(symbol string) engine.Storage {
+		if symbol == "SLOW" {
+			return slow
+		}
+		return &engine.NilStorage{}
+	}, &NilSymbolStore{})
+	ex.AddSymbol(symbol("BTC-USD"))
+
+	added := make(chan error)
+	go func() {
+		_, err := ex.AddSymbol(symbol("SLOW"))
+		added <- err
+	}()
+	<-slow.restoring
+
+	if _, ok := ex.Book("BTC-USD"); !ok {
+		t.Fatalf("tests - other books should be usable while one restores. expected=%v, got=%v", true, ok)
+	}
+	if _, err := ex.AddSymbol(symbol("ETH-USD")); err != nil {
+		t.Fatalf("tests - other symbols should be added while one restores. expected=%v, got=%v", nil, err)
+	}
+	if _, err := ex.AddSymbol(symbol("SLOW")); err != ErrSymbolExists {
+		t.Fatalf("tests - symbol being restored should not be added twice. expected=%v, got=%v", ErrSymbolExists, err)
+	}
+	if _, ok := ex.Book("SLOW"); ok {
+		t.Fatalf("tests - book should not be served before it is restored. expected=%v, got=%v", false, ok)
+	}
+
+	close(slow.release)
+	if err := <-added; err != nil {
+		t.Fatalf("tests - AddSymbol failed. expected=%v, got=%v", nil, err)
+	}
+	if _, ok := ex.Book("SLOW"); !ok {
+		t.Fatalf("tests - restored book should be served. expected=%v, got=%v", true, ok)
+	}
+}
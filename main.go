@@ -2,39 +2,294 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"flag"
+	"fmt"
 	"limit-order-book/engine"
+	"limit-order-book/exchange"
 	"limit-order-book/server"
 	"limit-order-book/storage"
 	"limit-order-book/util"
+	"os"
+	"os/signal"
+	"path/filepath"
 	"strconv"
+	"syscall"
+	"time"
 )
 
 var (
-	port      = flag.Int("port", 3000, "HTTP port")
+	port   = flag.Int("port", 3000, "HTTP port")
+	config = flag.String("config", "", "JSON file listing the symbols to trade")
+	store  = flag.String("storage", "postgres", "storage backend: sqlite, postgres, json or memory")
+
+	migrate  = flag.Bool("migrate", true, "apply pending schema migrations on startup; if false, refuse to start while any are pending")
+	jsonSync = flag.String("json-sync", "commit", "when json storage syncs its log to disk: commit, never, or an interval such as 1s")
+
+	asyncStorage  = flag.Bool("async-storage", false, "write to storage in the background, in batches")
+	flushInterval = flag.Duration("flush-interval", 10*time.Millisecond, "longest wait before queued writes are flushed, with -async-storage")
+	flushSize     = flag.Int("flush-size", 500, "queued writes that trigger a flush, with -async-storage")
+	storageQueue  = flag.Int("storage-queue", 1024, "commands that can wait to be written before orders block, with -async-storage")
+	journal       = flag.String("journal", "", "directory for the per-symbol command journals; empty disables journaling")
+
+	snapshots        = flag.String("snapshots", "", "directory for book snapshots; needs -journal")
+	snapshotInterval = flag.Duration("snapshot-interval", time.Minute, "how often to snapshot changed books; 0 disables periodic snapshots")
+
+	candleIntervals = flag.String("candles", engine.DefaultIntervals, "comma-separated candle intervals to keep, such as 1s,1m,1d; empty disables candles")
+	candleFlush     = flag.Duration("candle-flush", time.Second, "how often changed candles are saved")
+
+	shutdownTimeout = flag.Duration("shutdown-timeout", 10*time.Second, "how long to wait for requests in flight on SIGINT or SIGTERM")
 )
 
+// commands are the subcommands run instead of the server.
+var commands = map[string]func(args []string) error{
+	"snapshot": runSnapshot,
+	"check":    runCheck,
+	"migrate":  runMigrate,
+	"candles":  runCandles,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			storage.Logger = util.SetupLogging()
+			engine.Logger = storage.Logger
+			if err := command(os.Args[2:]); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	flag.Parse()
 	addr := ":" + strconv.Itoa(*port)
 
 	logger := util.SetupLogging()
 	engine.Logger = logger
+	exchange.Logger = logger
 	server.Logger = logger
 	storage.Logger = logger
 
+	// Books are only recovered from snapshots along with their journal.
+	if *snapshots != "" && *journal == "" {
+		logger.Fatal("-snapshots needs -journal")
+	}
 
-	db := storage.InitPostgres()
-	defer db.Close(context.Background())
+	backend, err := openStorage(*store, *migrate, true)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	defer backend.close()
+	logger.Printf("Using %s storage\n", *store)
 
-	storage := storage.PostgresStorage{Database: db}
-	ob := engine.NewOrderBook()
-	ob.AddStorage(&storage)
-	ob.RestoreOrderBook()
+	newStorage := backend.newStorage
+	if *asyncStorage {
+		config := engine.AsyncConfig{QueueSize: *storageQueue, BatchSize: *flushSize, FlushInterval: *flushInterval}
+		newStorage = func(symbol string) engine.Storage {
+			return engine.NewAsyncStorage(backend.newStorage(symbol), config)
+		}
+	}
 
-	logger.Printf("LimitOrderBook running on http://%s\n", addr)
-	server := server.NewServer(addr, ob)
-	if err := server.Serve(); err != nil {
+	ex := exchange.NewExchange(newStorage, backend.symbols)
+
+	if *journal != "" {
+		if err := os.MkdirAll(*journal, 0755); err != nil {
+			logger.Fatal(err)
+		}
+		ex.SetJournal(func(symbol string) engine.Journal {
+			return &storage.FileJournal{Path: filepath.Join(*journal, symbol+".journal")}
+		})
+	}
+
+	if *snapshots != "" {
+		if err := os.MkdirAll(*snapshots, 0755); err != nil {
+			logger.Fatal(err)
+		}
+		ex.SetSnapshots(func(symbol string) engine.SnapshotStore {
+			return &storage.FileSnapshots{Dir: *snapshots, Symbol: symbol}
+		})
+	}
+
+	cfg := &exchange.Config{Symbols: []engine.Instrument{engine.DefaultInstrument(exchange.DefaultSymbol)}}
+	if *config != "" {
+		loaded, err := exchange.LoadConfig(*config)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		cfg = loaded
+	}
+
+	if err := ex.Restore(cfg); err != nil {
+		logger.Fatal(err)
+	}
+	if *snapshots != "" && *snapshotInterval > 0 {
+		ex.StartSnapshots(*snapshotInterval)
+	}
+
+	intervals, err := engine.ParseIntervals(*candleIntervals)
+	if err != nil {
 		logger.Fatal(err)
 	}
+	var candles *engine.CandleAggregator
+	if len(intervals) > 0 {
+		candles = engine.NewCandleAggregator(backend.candles, intervals)
+		// Catch up on trades made since the candles were last saved.
+		if backend.trades != nil {
+			for _, symbol := range ex.Symbols() {
+				if err := candles.Rebuild(symbol, backend.trades, false); err != nil {
+					logger.Fatalf("Failed to rebuild %s candles: %s", symbol, err)
+				}
+			}
+		}
+		ex.WatchBooks(candles.Watch)
+		candles.Start(*candleFlush)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	logger.Printf("LimitOrderBook running on http://%s\n", addr)
+	server := server.NewServer(addr, ex, backend.trades, candles)
+	served := make(chan error, 1)
+	go func() { served <- server.Serve() }()
+
+	var serveErr error
+	select {
+	case serveErr = <-served:
+	case <-ctx.Done():
+		logger.Println("Shutting down")
+	}
+	stop()
+
+	// Stop taking orders, then let the books write out what is queued,
+	// then save the candles of their last trades.
+	shutdown, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdown); err != nil {
+		logger.Printf("Failed to shut down the server: %s\n", err)
+	}
+	ex.Close()
+	if candles != nil {
+		if err := candles.Close(); err != nil {
+			logger.Printf("Failed to save candles: %s\n", err)
+		}
+	}
+	if serveErr != nil {
+		backend.close()
+		logger.Fatal(serveErr)
+	}
+}
+
+// backend is an open storage backend: how to get each symbol's storage,
+// where symbols are kept, where trade history is queried (nil if it
+// keeps none), where candles are kept and how to disconnect.
+type backend struct {
+	newStorage func(symbol string) engine.Storage
+	symbols    exchange.SymbolStore
+	trades     engine.TradeHistory
+	candles    engine.CandleStore
+	close      func()
+}
+
+// openStorage connects to a storage backend. With migrate, pending schema
+// migrations are applied; without, they are an error. Only the writer,
+// the server, repairs files a crash left torn.
+func openStorage(kind string, migrate bool, writer bool) (*backend, error) {
+	switch kind {
+	case "postgres":
+		var db *storage.PostgresDB
+		if migrate {
+			db = storage.InitPostgres()
+		} else {
+			db = storage.ConnectPostgres()
+			if err := requireMigrated(storage.NewPostgresMigrator(db)); err != nil {
+				db.Close()
+				return nil, err
+			}
+		}
+		return &backend{
+			newStorage: func(symbol string) engine.Storage {
+				return &storage.PostgresStorage{Database: db, Symbol: symbol}
+			},
+			symbols: &storage.PostgresSymbolStore{Database: db},
+			trades:  &storage.PostgresTradeHistory{Database: db},
+			candles: &storage.PostgresCandleStore{Database: db},
+			close:   db.Close,
+		}, nil
+	case "sqlite":
+		var db *sql.DB
+		if migrate {
+			db = storage.InitSqlite()
+		} else {
+			db = storage.OpenSqlite()
+			if err := requireMigrated(storage.NewSqliteMigrator(db)); err != nil {
+				db.Close()
+				return nil, err
+			}
+		}
+		return &backend{
+			newStorage: func(symbol string) engine.Storage {
+				return &storage.SqliteStorage{Database: db, Symbol: symbol}
+			},
+			symbols: &storage.SqliteSymbolStore{Database: db},
+			trades:  &storage.SqliteTradeHistory{Database: db},
+			candles: &storage.SqliteCandleStore{Database: db},
+			close:   func() { db.Close() },
+		}, nil
+	case "json":
+		sync, interval, err := parseJsonSync(*jsonSync)
+		if err != nil {
+			return nil, err
+		}
+		return &backend{
+			newStorage: func(symbol string) engine.Storage {
+				return &storage.JsonStorage{Symbol: symbol, Sync: sync, SyncInterval: interval, Writer: writer}
+			},
+			symbols: &exchange.NilSymbolStore{},
+			trades:  &storage.JsonTradeHistory{},
+			// Rebuilt from the logs on every start.
+			candles: &engine.MemoryCandleStore{},
+			close:   func() {},
+		}, nil
+	case "memory":
+		return &backend{
+			newStorage: func(symbol string) engine.Storage {
+				return &engine.NilStorage{}
+			},
+			symbols: &exchange.NilSymbolStore{},
+			candles: &engine.MemoryCandleStore{},
+			close:   func() {},
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown storage backend %q", kind)
+}
+
+// parseJsonSync reads the -json-sync flag.
+func parseJsonSync(value string) (storage.JsonSync, time.Duration, error) {
+	switch value {
+	case "commit":
+		return storage.SyncEveryCommit, 0, nil
+	case "never":
+		return storage.SyncNever, 0, nil
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval <= 0 {
+		return 0, 0, fmt.Errorf("-json-sync must be commit, never or an interval, got %q", value)
+	}
+	return storage.SyncPeriodic, interval, nil
+}
+
+func requireMigrated(migrator *storage.Migrator, err error) error {
+	if err != nil {
+		return err
+	}
+	pending, err := migrator.Pending()
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%d schema migrations pending; run limit-order-book migrate up", len(pending))
+	}
+	return nil
 }
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"limit-order-book/storage"
+	"time"
+)
+
+const migrateUsage = `usage: limit-order-book migrate <command> [flags]
+
+commands:
+  up      apply every pending migration
+  down    revert the last -steps applied migrations
+  status  list migrations and when they were applied`
+
+// runMigrate runs the migrate subcommand against the -storage database.
+func runMigrate(args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	fs := flag.NewFlagSet("migrate "+args[0], flag.ContinueOnError)
+	kind := fs.String("storage", "postgres", "storage backend: sqlite or postgres")
+	steps := fs.Int("steps", 1, "migrations to revert, for down")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	var migrator *storage.Migrator
+	var err error
+	switch *kind {
+	case "postgres":
+		db := storage.ConnectPostgres()
+		defer db.Close()
+		migrator, err = storage.NewPostgresMigrator(db)
+	case "sqlite":
+		db := storage.OpenSqlite()
+		defer db.Close()
+		migrator, err = storage.NewSqliteMigrator(db)
+	default:
+		return fmt.Errorf("storage backend %q has no schema", *kind)
+	}
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up()
+		printMigrations("applied", applied)
+		return err
+	case "down":
+		reverted, err := migrator.Down(*steps)
+		printMigrations("reverted", reverted)
+		return err
+	case "status":
+		status, err := migrator.Status()
+		if err != nil {
+			return err
+		}
+		for _, s := range status {
+			applied := "pending"
+			if !s.Applied.IsZero() {
+				applied = s.Applied.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d %-30s %s\n", s.Version, s.Name, applied)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown migrate command %q\n%s", args[0], migrateUsage)
+}
+
+func printMigrations(verb string, migrations []storage.Migration) {
+	for _, m := range migrations {
+		fmt.Printf("%s %04d_%s\n", verb, m.Version, m.Name)
+	}
+}
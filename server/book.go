@@ -0,0 +1,109 @@
+package server
+
+import (
+	"encoding/json"
+	"limit-order-book/engine"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// BookResponse is the depth of one book. Levels are best first; at level 3
+// each carries its orders in the order they fill.
+type BookResponse struct {
+	Symbol    string      `json:"symbol"`
+	Seq       uint64      `json:"seq"`
+	Timestamp time.Time   `json:"timestamp"`
+	Level     int         `json:"level"`
+	Bids      []BookLevel `json:"bids"`
+	Asks      []BookLevel `json:"asks"`
+}
+
+type BookLevel struct {
+	Price  int         `json:"price"`
+	Volume int         `json:"volume"`
+	Count  int         `json:"count"`
+	Orders []BookOrder `json:"orders,omitempty"`
+}
+
+// BookOrder is a resting order; Size is only the visible part of an
+// iceberg.
+type BookOrder struct {
+	ID   uuid.UUID `json:"id"`
+	Size int       `json:"size"`
+	Time time.Time `json:"time"`
+}
+
+// book serves GET /api/book?symbol=S&depth=N&level=2|3. depth 0, the
+// default, returns every level.
+func (s *Server) book(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	symbol := query.Get("symbol")
+	if symbol == "" {
+		symbol = s.defaultSymbol()
+	}
+	ob, ok := s.exchange.Book(symbol)
+	if !ok {
+		writeUnknownSymbol(w, symbol)
+		return
+	}
+
+	depth := 0
+	if param := query.Get("depth"); param != "" {
+		var err error
+		if depth, err = strconv.Atoi(param); err != nil || depth < 0 {
+			writeError(w, http.StatusBadRequest, "invalid_depth", "Invalid depth, use a number of levels, or 0 for all")
+			return
+		}
+	}
+
+	response := BookResponse{Symbol: symbol, Level: 2}
+	switch query.Get("level") {
+	case "", "2":
+		snapshot := ob.Snapshot()
+		response.Seq, response.Timestamp = snapshot.Seq, snapshot.Time
+		response.Bids = bookLevels(snapshot.View.Bids, depth)
+		response.Asks = bookLevels(snapshot.View.Asks, depth)
+	case "3":
+		view, snapshot, err := ob.OrdersView(depth)
+		if err != nil {
+			writeEngineError(w, err)
+			return
+		}
+		response.Level = 3
+		response.Seq, response.Timestamp = snapshot.Seq, snapshot.Time
+		response.Bids = bookOrders(view.Bids)
+		response.Asks = bookOrders(view.Asks)
+	default:
+		writeError(w, http.StatusBadRequest, "invalid_level", "Invalid level, use 2 or 3")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func bookLevels(levels []engine.LevelView, depth int) []BookLevel {
+	if depth > 0 && len(levels) > depth {
+		levels = levels[:depth]
+	}
+	book := make([]BookLevel, 0, len(levels))
+	for _, level := range levels {
+		book = append(book, BookLevel{Price: level.Price, Volume: level.Volume, Count: level.Count})
+	}
+	return book
+}
+
+func bookOrders(levels []engine.LevelOrdersView) []BookLevel {
+	book := make([]BookLevel, 0, len(levels))
+	for _, level := range levels {
+		orders := make([]BookOrder, 0, len(level.Orders))
+		for _, order := range level.Orders {
+			orders = append(orders, BookOrder{ID: order.ID, Size: order.Size, Time: order.Time})
+		}
+		book = append(book, BookLevel{Price: level.Price, Volume: level.Volume, Count: level.Count, Orders: orders})
+	}
+	return book
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"limit-order-book/engine"
+)
+
+func TestBookLevel2(t *testing.T) {
+	ts, book := newTestServer(t, nil, nil, nil)
+	postOrder(t, ts, "buy", 40, 2)
+	postOrder(t, ts, "buy", 40, 3)
+	postOrder(t, ts, "buy", 39, 1)
+	postOrder(t, ts, "buy", 38, 1)
+	postOrder(t, ts, "sell", 42, 1)
+
+	var response BookResponse
+	getJSON(t, ts, "/api/book?depth=2", http.StatusOK, &response)
+	if response.Symbol != "BTC-USD" || response.Level != 2 || response.Seq != book.Snapshot().Seq {
+		t.Fatalf("tests - wrong book. expected=%v, got=%+v", "BTC-USD at level 2", response)
+	}
+	expected := []BookLevel{{Price: 40, Volume: 5, Count: 2}, {Price: 39, Volume: 1, Count: 1}}
+	if len(response.Bids) != len(expected) {
+		t.Fatalf("tests - depth should cut the bids. expected=%d, got=%+v", len(expected), response.Bids)
+	}
+	for i, level := range expected {
+		if got := response.Bids[i]; got.Price != level.Price || got.Volume != level.Volume || got.Count != level.Count {
+			t.Fatalf("tests - wrong bid level. expected=%+v, got=%+v", level, got)
+		}
+	}
+	if len(response.Asks) != 1 || response.Asks[0].Price != 42 || response.Asks[0].Orders != nil {
+		t.Fatalf("tests - wrong asks. expected=%v, got=%+v", "42 without orders", response.Asks)
+	}
+}
+
+func TestBookLevel3(t *testing.T) {
+	ts, book := newTestServer(t, nil, nil, nil)
+	first := postOrder(t, ts, "sell", 42, 2)
+	second := postOrder(t, ts, "sell", 42, 3)
+	iceberg, _ := book.PlaceOrder(engine.OrderRequest{Side: engine.Sell, Type: engine.Limit, Price: 43, Size: 10, DisplaySize: 4})
+	postOrder(t, ts, "buy", 40, 1)
+
+	var response BookResponse
+	getJSON(t, ts, "/api/book?symbol=BTC-USD&level=3", http.StatusOK, &response)
+	if response.Level != 3 || response.Seq != book.Snapshot().Seq || len(response.Asks) != 2 || len(response.Bids) != 1 {
+		t.Fatalf("tests - wrong book. expected=%v, got=%+v", "2 asks and 1 bid at level 3", response)
+	}
+	orders := response.Asks[0].Orders
+	if len(orders) != 2 || orders[0].ID != first.Id || orders[1].ID != second.Id || orders[0].Size != 2 {
+		t.Fatalf("tests - orders should be listed in the order they fill. expected=%v, got=%+v", []any{first.Id, second.Id}, orders)
+	}
+	orders = response.Asks[1].Orders
+	if len(orders) != 1 || orders[0].ID != iceberg.Id || orders[0].Size != 4 {
+		t.Fatalf("tests - iceberg should show its visible size. expected=%d, got=%+v", 4, orders)
+	}
+
+	getJSON(t, ts, "/api/book?level=3&depth=1", http.StatusOK, &response)
+	if len(response.Asks) != 1 || response.Asks[0].Price != 42 {
+		t.Fatalf("tests - depth should cut level 3 too. expected=%d, got=%+v", 1, response.Asks)
+	}
+}
+
+func TestBookRejectsBadParameters(t *testing.T) {
+	ts, _ := newTestServer(t, nil, nil, nil)
+
+	for path, expected := range map[string]string{
+		"/api/book?symbol=NOPE": "unknown_symbol",
+		"/api/book?depth=-1":    "invalid_depth",
+		"/api/book?depth=x":     "invalid_depth",
+		"/api/book?level=1":     "invalid_level",
+	} {
+		status := http.StatusBadRequest
+		if expected == "unknown_symbol" {
+			status = http.StatusNotFound
+		}
+		if code := getError(t, ts, path, status); code != expected {
+			t.Fatalf("tests - %s should be refused. expected=%s, got=%s", path, expected, code)
+		}
+	}
+}
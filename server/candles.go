@@ -0,0 +1,110 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"limit-order-book/engine"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultCandleLimit = 500
+	maxCandleLimit     = 5000
+)
+
+// CandlesResponse holds candles oldest first. Intervals without trades
+// have no candle.
+type CandlesResponse struct {
+	Symbol   string          `json:"symbol"`
+	Interval string          `json:"interval"`
+	Candles  []CandleMessage `json:"candles"`
+}
+
+type CandleMessage struct {
+	Start  time.Time `json:"start"`
+	Open   int       `json:"open"`
+	High   int       `json:"high"`
+	Low    int       `json:"low"`
+	Close  int       `json:"close"`
+	Volume int       `json:"volume"`
+	VWAP   float64   `json:"vwap"`
+	Trades int       `json:"trades"`
+}
+
+// candleHistory serves GET /api/candles?symbol=S&interval=1m&from=T&to=T&limit=N:
+// the latest limit candles starting in [from, to). To page further back,
+// ask again with to set to the start of the oldest candle returned.
+func (s *Server) candleHistory(w http.ResponseWriter, r *http.Request) {
+	if s.candles == nil {
+		writeError(w, http.StatusNotImplemented, "not_implemented", "Candles are not kept")
+		return
+	}
+
+	query := r.URL.Query()
+	q := engine.CandleQuery{Symbol: query.Get("symbol"), Interval: query.Get("interval"), Limit: defaultCandleLimit}
+	if q.Symbol == "" {
+		q.Symbol = s.defaultSymbol()
+	}
+	if _, ok := s.exchange.Book(q.Symbol); !ok {
+		writeUnknownSymbol(w, q.Symbol)
+		return
+	}
+
+	invalid := func(param, message string) {
+		writeError(w, http.StatusBadRequest, "invalid_"+param, message)
+	}
+	var names []string
+	for _, interval := range s.candles.Intervals() {
+		names = append(names, interval.Name)
+	}
+	if !slices.Contains(names, q.Interval) {
+		invalid("interval", "Invalid interval, use one of "+strings.Join(names, ", "))
+		return
+	}
+	var err error
+	if v := query.Get("from"); v != "" {
+		if q.From, err = time.Parse(time.RFC3339Nano, v); err != nil {
+			invalid("from", "Invalid from, use an RFC 3339 time")
+			return
+		}
+	}
+	if v := query.Get("to"); v != "" {
+		if q.To, err = time.Parse(time.RFC3339Nano, v); err != nil {
+			invalid("to", "Invalid to, use an RFC 3339 time")
+			return
+		}
+	}
+	if v := query.Get("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit <= 0 || q.Limit > maxCandleLimit {
+			invalid("limit", fmt.Sprintf("Invalid limit, use 1 to %d", maxCandleLimit))
+			return
+		}
+	}
+
+	candles, err := s.candles.Candles(q)
+	if err != nil {
+		writeEngineError(w, fmt.Errorf("%w: candles: %w", engine.ErrStorage, err))
+		return
+	}
+
+	response := CandlesResponse{Symbol: q.Symbol, Interval: q.Interval, Candles: make([]CandleMessage, 0, len(candles))}
+	for _, c := range candles {
+		response.Candles = append(response.Candles, CandleMessage{
+			Start:  c.Start,
+			Open:   c.Open,
+			High:   c.High,
+			Low:    c.Low,
+			Close:  c.Close,
+			Volume: c.Volume,
+			VWAP:   c.VWAP(),
+			Trades: c.Trades,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
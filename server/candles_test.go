@@ -0,0 +1,78 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"limit-order-book/engine"
+)
+
+func newCandleServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	intervals, _ := engine.ParseIntervals("1m")
+	ts, _ := newTestServer(t, nil, nil, engine.NewCandleAggregator(&engine.MemoryCandleStore{}, intervals))
+	return ts
+}
+
+func TestCandlesFromTrades(t *testing.T) {
+	ts := newCandleServer(t)
+
+	// Keep every trade in one minute.
+	if now := time.Now(); now.Second() >= 55 {
+		time.Sleep(now.Truncate(time.Minute).Add(time.Minute).Sub(now))
+	}
+	for _, trade := range [][2]int{{40, 1}, {44, 2}, {38, 1}} {
+		postOrder(t, ts, "sell", trade[0], trade[1])
+		postOrder(t, ts, "buy", trade[0], trade[1])
+	}
+
+	var response CandlesResponse
+	getJSON(t, ts, "/api/candles?symbol=BTC-USD&interval=1m", http.StatusOK, &response)
+	if response.Symbol != "BTC-USD" || response.Interval != "1m" || len(response.Candles) != 1 {
+		t.Fatalf("tests - wrong candles. expected=%v, got=%+v", "one BTC-USD 1m candle", response)
+	}
+	expected := CandleMessage{Start: response.Candles[0].Start, Open: 40, High: 44, Low: 38, Close: 38, Volume: 4, VWAP: 41.5, Trades: 3}
+	if got := response.Candles[0]; got != expected {
+		t.Fatalf("tests - wrong candle. expected=%+v, got=%+v", expected, got)
+	}
+	if start := expected.Start; !start.Equal(start.Truncate(time.Minute)) {
+		t.Fatalf("tests - candle should start on the minute. expected=%v, got=%v", start.Truncate(time.Minute), start)
+	}
+
+	to := expected.Start.Format(time.RFC3339Nano)
+	getJSON(t, ts, "/api/candles?interval=1m&to="+to, http.StatusOK, &response)
+	if len(response.Candles) != 0 {
+		t.Fatalf("tests - to should leave out the candle starting then. expected=%d, got=%+v", 0, response.Candles)
+	}
+	getJSON(t, ts, "/api/candles?interval=1m&from="+to+"&limit=1", http.StatusOK, &response)
+	if len(response.Candles) != 1 {
+		t.Fatalf("tests - from should take in the candle starting then. expected=%d, got=%+v", 1, response.Candles)
+	}
+}
+
+func TestCandlesRejectsBadParameters(t *testing.T) {
+	ts := newCandleServer(t)
+
+	for query, expected := range map[string]string{
+		"interval=5m":               "invalid_interval",
+		"interval=":                 "invalid_interval",
+		"interval=1m&from=now":      "invalid_from",
+		"interval=1m&to=2024-01-01": "invalid_to",
+		"interval=1m&limit=0":       "invalid_limit",
+		"interval=1m&limit=5001":    "invalid_limit",
+	} {
+		if code := getError(t, ts, "/api/candles?symbol=BTC-USD&"+query, http.StatusBadRequest); code != expected {
+			t.Fatalf("tests - %s should be refused. expected=%s, got=%s", query, expected, code)
+		}
+	}
+	if code := getError(t, ts, "/api/candles?symbol=NOPE&interval=1m", http.StatusNotFound); code != "unknown_symbol" {
+		t.Fatalf("tests - unknown symbol should be refused. expected=%s, got=%s", "unknown_symbol", code)
+	}
+
+	noCandles, _ := newTestServer(t, nil, nil, nil)
+	if code := getError(t, noCandles, "/api/candles?interval=1m", http.StatusNotImplemented); code != "not_implemented" {
+		t.Fatalf("tests - server without candles should say so. expected=%s, got=%s", "not_implemented", code)
+	}
+}
@@ -2,35 +2,140 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"limit-order-book/engine"
+	"limit-order-book/exchange"
 	"limit-order-book/web"
 	"log"
+	"net"
 	"net/http"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 )
 
 var Logger *log.Logger
 
 type Server struct {
-	addr 	string
-	ob   	*engine.OrderBook
+	addr       string
+	exchange   *exchange.Exchange
+	marketData *marketData
+	trades     engine.TradeHistory
+	candles    *engine.CandleAggregator
+	http       *http.Server
+	cancel     context.CancelFunc // ends the requests still streaming on shutdown
 }
 
 type PlaceOrderRequest struct {
-	Side  string `json:"side"`
-	Price int    `json:"price"`
-	Size  int    `json:"size"`
+	Side        string `json:"side"`
+	Type        string `json:"type"`
+	TimeInForce string `json:"time_in_force"`
+	PostOnly    bool   `json:"post_only"`
+	Reprice     bool   `json:"reprice"`
+	Price       int    `json:"price"`
+	StopPrice   int    `json:"stop_price"`
+	Size        int    `json:"size"`
+	DisplaySize int    `json:"display_size"`
 }
 
-func NewServer(addr string, ob *engine.OrderBook) *Server {
+// AmendOrderRequest changes a resting order. Fields left out keep their
+// current value; Size is the new total size of the order.
+type AmendOrderRequest struct {
+	Price *int `json:"price"`
+	Size  *int `json:"size"`
+}
+
+// AddSymbolRequest carries the instrument spec of a new symbol; spec
+// fields left out fall back to engine.DefaultInstrument.
+type AddSymbolRequest struct {
+	engine.Instrument
+}
+
+// ErrorResponse is the body of every error response.
+type ErrorResponse struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+// NewServer serves the books of ex. trades answers /api/trades; it is nil
+// if storage keeps no trade history. candles answers /api/candles; it is
+// nil if candles are not kept.
+func NewServer(addr string, ex *exchange.Exchange, trades engine.TradeHistory, candles *engine.CandleAggregator) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Server{
-		addr: addr,
-		ob: ob,
+		addr:       addr,
+		exchange:   ex,
+		marketData: newMarketData(ex),
+		trades:     trades,
+		candles:    candles,
+		http: &http.Server{
+			Addr:        addr,
+			BaseContext: func(net.Listener) context.Context { return ctx },
+		},
+		cancel: cancel,
+	}
+}
+
+// defaultSymbol picks the book shown on the index page when none is
+// asked for: the default symbol if it is traded, else the first one.
+func (s *Server) defaultSymbol() string {
+	if _, ok := s.exchange.Book(exchange.DefaultSymbol); ok {
+		return exchange.DefaultSymbol
+	}
+	if symbols := s.exchange.Symbols(); len(symbols) > 0 {
+		return symbols[0]
+	}
+	return exchange.DefaultSymbol
+}
+
+func writeError(w http.ResponseWriter, status int, code string, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: code, Message: message})
+}
+
+// writeUnknownSymbol reports a symbol that is not traded.
+func writeUnknownSymbol(w http.ResponseWriter, symbol string) {
+	writeError(w, http.StatusNotFound, "unknown_symbol", "Unknown symbol "+symbol)
+}
+
+// writeEngineError reports a command the engine refused. Instrument rule
+// violations carry their own code, storage failures are the server's
+// fault, and anything else is a plain bad request.
+func writeEngineError(w http.ResponseWriter, err error) {
+	var rejectErr *engine.RejectError
+	switch {
+	case errors.As(err, &rejectErr):
+		writeError(w, http.StatusBadRequest, string(rejectErr.Code), rejectErr.Message)
+	case errors.Is(err, engine.ErrOrderNotFound):
+		writeError(w, http.StatusNotFound, "not_found", err.Error())
+	case errors.Is(err, engine.ErrDegraded):
+		Logger.Printf("Refused command: %s", err)
+		writeError(w, http.StatusServiceUnavailable, "degraded", err.Error())
+	case errors.Is(err, engine.ErrStorage):
+		Logger.Printf("Command failed: %s", err)
+		writeError(w, http.StatusInternalServerError, "storage_error", err.Error())
+	default:
+		writeError(w, http.StatusBadRequest, "bad_request", err.Error())
+	}
+}
+
+// withBook resolves the {symbol} route variable to that symbol's order
+// book before calling handler.
+func (s *Server) withBook(handler func(http.ResponseWriter, *http.Request, *engine.Sequencer)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		symbol := mux.Vars(r)["symbol"]
+		ob, ok := s.exchange.Book(symbol)
+		if !ok {
+			writeUnknownSymbol(w, symbol)
+			return
+		}
+		handler(w, r, ob)
 	}
 }
 
@@ -42,53 +147,210 @@ func headers(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// Serve answers requests until Shutdown.
 func (s *Server) Serve() error {
+	s.http.Handler = s.Handler()
+	if err := s.http.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown stops taking connections, ends the streams and waits for the
+// other requests to finish, or for ctx to be done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.cancel()
+	return s.http.Shutdown(ctx)
+}
+
+// Handler routes every endpoint of the server.
+func (s *Server) Handler() http.Handler {
+	// Every book is watched from the start, so the stream holds all their
+	// events.
+	for _, symbol := range s.exchange.Symbols() {
+		s.marketData.feed(symbol)
+	}
+
 	r := mux.NewRouter()
-	r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request){
-		view := engine.BuildOrderBookView(s.ob)
+	r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		symbol := r.URL.Query().Get("symbol")
+		if symbol == "" {
+			symbol = s.defaultSymbol()
+		}
+
+		ob, ok := s.exchange.Book(symbol)
+		if !ok {
+			writeUnknownSymbol(w, symbol)
+			return
+		}
+
+		view := ob.Snapshot().View
+		// The full history is on /api/trades.
+		view.Trades = view.Trades[max(0, len(view.Trades)-recentTrades):]
+		view.Symbol = symbol
+		view.Symbols = s.exchange.Symbols()
 		tmpl := template.Must(template.New("index").Parse(web.IndexTemplate()))
 		tmpl.Execute(w, view)
 	})
 
 	r.HandleFunc("/headers", headers)
 
-	r.HandleFunc("/ob", func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprint(w, s.ob.String())
-	})
+	r.HandleFunc("/ob/{symbol}", s.withBook(func(w http.ResponseWriter, r *http.Request, ob *engine.Sequencer) {
+		fmt.Fprint(w, ob.String())
+	}))
 
-	r.HandleFunc("/api/order", func(w http.ResponseWriter, r *http.Request) {
-		placeOrder(w, r, s.ob)
-	})
+	r.HandleFunc("/api/health", s.health)
 
-	r.HandleFunc("/api/wipe", func(w http.ResponseWriter, r *http.Request) {
-		err := s.ob.ResetOrderBook()
-		if err != nil {
-			json.NewEncoder(w).Encode(map[string]bool{"ok": false})
-			Logger.Printf("Failed to wipe orderbook: %s", err)
-		} else {
-			json.NewEncoder(w).Encode(map[string]bool{"ok": true})
+	r.HandleFunc("/ws/marketdata", s.marketDataSocket)
+	r.HandleFunc("/api/stream", s.stream).Methods(http.MethodGet)
+	r.HandleFunc("/api/book", s.book).Methods(http.MethodGet)
+	r.HandleFunc("/api/trades", s.tradeHistory).Methods(http.MethodGet)
+	r.HandleFunc("/api/candles", s.candleHistory).Methods(http.MethodGet)
+
+	r.HandleFunc("/api/admin/symbols", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.exchange.Symbols())
+	}).Methods(http.MethodGet)
+
+	r.HandleFunc("/api/admin/symbols", s.addSymbol).Methods(http.MethodPost)
+
+	r.HandleFunc("/api/{symbol}/instrument", s.withBook(func(w http.ResponseWriter, r *http.Request, ob *engine.Sequencer) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ob.Snapshot().Instrument)
+	})).Methods(http.MethodGet)
+
+	r.HandleFunc("/api/{symbol}/order", s.withBook(placeOrder))
+	r.HandleFunc("/api/{symbol}/order/{id}", s.withBook(amendOrder)).Methods(http.MethodPatch)
+	r.HandleFunc("/api/{symbol}/order/{id}", s.withBook(getOrder)).Methods(http.MethodGet)
+	r.HandleFunc("/api/{symbol}/order/{id}", s.withBook(cancelOrder)).Methods(http.MethodDelete)
+
+	r.HandleFunc("/api/{symbol}/stops", s.withBook(func(w http.ResponseWriter, r *http.Request, ob *engine.Sequencer) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ob.StopOrders())
+	})).Methods(http.MethodGet)
+
+	r.HandleFunc("/api/{symbol}/stops/{id}", s.withBook(cancelStopOrder)).Methods(http.MethodDelete)
+
+	r.HandleFunc("/api/{symbol}/wipe", s.withBook(func(w http.ResponseWriter, r *http.Request, ob *engine.Sequencer) {
+		if err := ob.ResetOrderBook(); err != nil {
+			writeEngineError(w, err)
+			return
 		}
-	})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]bool{"ok": true})
+	})).Methods(http.MethodPost)
 
-	r.HandleFunc("/api/health", func(w http.ResponseWriter, r *http.Request) {
+	r.HandleFunc("/api/admin/{symbol}/resync", s.withBook(func(w http.ResponseWriter, r *http.Request, ob *engine.Sequencer) {
+		if err := ob.Resync(); err != nil {
+			writeEngineError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]bool{"ok": true})
-	})
+	})).Methods(http.MethodPost)
 
-	http.Handle("/", r)
+	r.HandleFunc("/api/admin/{symbol}/snapshot", s.withBook(func(w http.ResponseWriter, r *http.Request, ob *engine.Sequencer) {
+		seq, err := ob.TakeSnapshot()
+		if err != nil {
+			writeEngineError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"ok": true, "seq": seq})
+	})).Methods(http.MethodPost)
 
-	return http.ListenAndServe(s.addr, nil)
+	r.HandleFunc("/api/admin/{symbol}/check", s.withBook(func(w http.ResponseWriter, r *http.Request, ob *engine.Sequencer) {
+		report, err := ob.Check()
+		if err != nil {
+			writeEngineError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"ok":         report.OK(),
+			"seq":        report.Seq,
+			"invariants": report.Invariants,
+			"storage":    report.Storage,
+		})
+	})).Methods(http.MethodGet)
+
+	return r
+}
 
+// BookHealth tells how far a book's storage has got: DurableSeq trails Seq
+// while writes are queued.
+type BookHealth struct {
+	Seq        uint64 `json:"seq"`
+	DurableSeq uint64 `json:"durable_seq"`
 }
 
-func placeOrder(w http.ResponseWriter, r *http.Request, ob *engine.OrderBook) {
+// health reports ok unless a book is degraded, in which case it answers
+// 503 and lists the degraded symbols.
+func (s *Server) health(w http.ResponseWriter, r *http.Request) {
+	degraded := map[string]string{}
+	books := map[string]BookHealth{}
+	for _, symbol := range s.exchange.Symbols() {
+		ob, ok := s.exchange.Book(symbol)
+		if !ok {
+			continue
+		}
+		snapshot := ob.Snapshot()
+		books[symbol] = BookHealth{Seq: snapshot.Seq, DurableSeq: snapshot.DurableSeq}
+		if snapshot.Degraded != nil {
+			degraded[symbol] = snapshot.Degraded.Error()
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(degraded) > 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]any{"ok": false, "degraded": degraded, "books": books})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{"ok": true, "books": books})
+}
+
+func (s *Server) addSymbol(w http.ResponseWriter, r *http.Request) {
+	var req AddSymbolRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_json", "Invalid JSON: "+err.Error())
+		return
+	}
+	defer r.Body.Close()
+
+	ob, err := s.exchange.AddSymbol(req.Instrument)
+	switch {
+	case errors.Is(err, exchange.ErrInvalidSymbol):
+		writeError(w, http.StatusBadRequest, "invalid_symbol", err.Error())
+		return
+	case errors.Is(err, exchange.ErrInvalidInstrument):
+		writeError(w, http.StatusBadRequest, "invalid_instrument", err.Error())
+		return
+	case errors.Is(err, exchange.ErrSymbolExists):
+		writeError(w, http.StatusConflict, "symbol_exists", err.Error())
+		return
+	case err != nil:
+		Logger.Printf("Failed to add symbol %s: %s", req.Symbol, err)
+		writeError(w, http.StatusInternalServerError, "storage_error", "Failed to add symbol")
+		return
+	}
+
+	s.marketData.feed(req.Symbol)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(ob.Snapshot().Instrument)
+}
+
+func placeOrder(w http.ResponseWriter, r *http.Request, ob *engine.Sequencer) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
 		return
 	}
 
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, "Failed to read body", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "bad_request", "Failed to read body")
 		return
 	}
 	defer r.Body.Close()
@@ -97,7 +359,7 @@ func placeOrder(w http.ResponseWriter, r *http.Request, ob *engine.OrderBook) {
 
 	var req PlaceOrderRequest
 	if err := json.Unmarshal(body, &req); err != nil {
-		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "invalid_json", err.Error())
 		return
 	}
 
@@ -108,15 +370,152 @@ func placeOrder(w http.ResponseWriter, r *http.Request, ob *engine.OrderBook) {
 	case "sell":
 		side = engine.Sell
 	default:
-		http.Error(w, "Invalid side, use 'buy' or 'sell'", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, "invalid_side", "Invalid side, use 'buy' or 'sell'")
+		return
+	}
+
+	var orderType engine.OrderType
+	switch req.Type {
+	case "", "limit":
+		orderType = engine.Limit
+	case "market":
+		orderType = engine.Market
+	case "stop":
+		orderType = engine.Stop
+	case "stop_limit":
+		orderType = engine.StopLimit
+	default:
+		writeError(w, http.StatusBadRequest, "invalid_type", "Invalid type, use 'limit', 'market', 'stop' or 'stop_limit'")
+		return
+	}
+
+	var timeInForce engine.TimeInForce
+	switch req.TimeInForce {
+	case "", "gtc":
+		timeInForce = engine.GTC
+	case "ioc":
+		timeInForce = engine.IOC
+	case "fok":
+		timeInForce = engine.FOK
+	default:
+		writeError(w, http.StatusBadRequest, "invalid_time_in_force", "Invalid time_in_force, use 'gtc', 'ioc' or 'fok'")
+		return
+	}
+
+	result, err := ob.PlaceOrder(engine.OrderRequest{
+		Side:        side,
+		Type:        orderType,
+		TimeInForce: timeInForce,
+		PostOnly:    req.PostOnly,
+		Reprice:     req.Reprice,
+		Price:       req.Price,
+		StopPrice:   req.StopPrice,
+		Size:        req.Size,
+		DisplaySize: req.DisplaySize,
+	})
+	if err != nil {
+		writeEngineError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func amendOrder(w http.ResponseWriter, r *http.Request, ob *engine.Sequencer) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_order_id", "Invalid order id")
+		return
+	}
+
+	var req AmendOrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_json", "Invalid JSON: "+err.Error())
+		return
+	}
+	defer r.Body.Close()
+
+	// Looked up and amended in one command so fields left out keep the
+	// order's values at the moment it is amended.
+	var result engine.OrderResult
+	err = ob.Do(func(book *engine.OrderBook) error {
+		order, ok := book.GetOrder(id)
+		if !ok {
+			return engine.ErrOrderNotFound
+		}
+
+		newPrice, newSize := order.Price, order.Size
+		if req.Price != nil {
+			newPrice = *req.Price
+		}
+		if req.Size != nil {
+			newSize = *req.Size
+		}
+
+		var err error
+		result, err = book.AmendOrder(id, newPrice, newSize)
+		return err
+	})
+	if err != nil {
+		writeEngineError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func getOrder(w http.ResponseWriter, r *http.Request, ob *engine.Sequencer) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_order_id", "Invalid order id")
 		return
 	}
 
-	order := ob.ProcessOrder(side, req.Price, req.Size)
+	info, ok := ob.OrderStatus(id)
+	if !ok {
+		writeEngineError(w, engine.ErrOrderNotFound)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(order)
+	json.NewEncoder(w).Encode(info)
+}
 
-	Logger.Println(ob)
-	// Logger.Println(ob.GetLevel(side, req.Price))
+func cancelOrder(w http.ResponseWriter, r *http.Request, ob *engine.Sequencer) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_order_id", "Invalid order id")
+		return
+	}
+
+	err = ob.CancelOrder(id)
+	if errors.Is(err, engine.ErrOrderNotFound) {
+		err = ob.CancelStopOrder(id)
+	}
+	if err != nil {
+		writeEngineError(w, err)
+		return
+	}
+
+	info, _ := ob.OrderStatus(id)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+func cancelStopOrder(w http.ResponseWriter, r *http.Request, ob *engine.Sequencer) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_order_id", "Invalid order id")
+		return
+	}
+
+	if err := ob.CancelStopOrder(id); err != nil {
+		writeEngineError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"ok": true})
 }
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"limit-order-book/engine"
+	"limit-order-book/exchange"
+)
+
+func TestMain(m *testing.M) {
+	Logger = log.New(io.Discard, "", 0)
+	engine.Logger = Logger
+	exchange.Logger = Logger
+	m.Run()
+}
+
+// newTestServer serves an exchange trading BTC-USD. Books get storage from
+// newStorage, or none if it is nil.
+func newTestServer(t *testing.T, newStorage func(symbol string) engine.Storage, trades engine.TradeHistory, candles *engine.CandleAggregator) (*httptest.Server, *engine.Sequencer) {
+	t.Helper()
+	if newStorage == nil {
+		newStorage = func(string) engine.Storage { return &engine.NilStorage{} }
+	}
+	ex := exchange.NewExchange(newStorage, &exchange.NilSymbolStore{})
+	t.Cleanup(ex.Close)
+	book, err := ex.AddSymbol(engine.Instrument{Symbol: "BTC-USD"})
+	if err != nil {
+		t.Fatalf("tests - AddSymbol failed. expected=%v, got=%v", nil, err)
+	}
+	if candles != nil {
+		ex.WatchBooks(candles.Watch)
+	}
+
+	ts := httptest.NewServer(NewServer("", ex, trades, candles).Handler())
+	t.Cleanup(ts.Close)
+	return ts, book
+}
+
+// postOrder places a limit order over HTTP.
+func postOrder(t *testing.T, ts *httptest.Server, side string, price, size int) engine.OrderResult {
+	t.Helper()
+	body, _ := json.Marshal(PlaceOrderRequest{Side: side, Type: "limit", Price: price, Size: size})
+	resp, err := http.Post(ts.URL+"/api/BTC-USD/order", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("tests - order failed. expected=%v, got=%v", nil, err)
+	}
+	defer resp.Body.Close()
+	var result engine.OrderResult
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&result) != nil {
+		t.Fatalf("tests - order refused. expected=%d, got=%d", http.StatusOK, resp.StatusCode)
+	}
+	return result
+}
+
+// getJSON fetches path, checks the status and decodes the body into v.
+func getJSON(t *testing.T, ts *httptest.Server, path string, status int, v any) {
+	t.Helper()
+	resp, err := http.Get(ts.URL + path)
+	if err != nil {
+		t.Fatalf("tests - GET %s failed. expected=%v, got=%v", path, nil, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != status {
+		t.Fatalf("tests - wrong status for %s. expected=%d, got=%d", path, status, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("tests - %s should answer JSON. expected=%v, got=%v", path, nil, err)
+	}
+}
+
+// getError fetches path and returns the code of the JSON error it answers
+// with status.
+func getError(t *testing.T, ts *httptest.Server, path string, status int) string {
+	t.Helper()
+	var body ErrorResponse
+	getJSON(t, ts, path, status, &body)
+	return body.Error
+}
+
+func TestWipeOnlyOnPost(t *testing.T) {
+	ts, book := newTestServer(t, nil, nil, nil)
+	postOrder(t, ts, "buy", 40, 1)
+
+	resp, err := http.Get(ts.URL + "/api/BTC-USD/wipe")
+	if err != nil {
+		t.Fatalf("tests - GET failed. expected=%v, got=%v", nil, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed || len(book.Snapshot().View.Bids) != 1 {
+		t.Fatalf("tests - GET should not wipe the book. expected=%d, got=%d", http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+
+	resp, err = http.Post(ts.URL+"/api/BTC-USD/wipe", "application/json", nil)
+	if err != nil {
+		t.Fatalf("tests - POST failed. expected=%v, got=%v", nil, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || len(book.Snapshot().View.Bids) != 0 {
+		t.Fatalf("tests - POST should wipe the book. expected=%d, got=%d", http.StatusOK, resp.StatusCode)
+	}
+}
+
+// failingReset is storage that can't wipe a book.
+type failingReset struct {
+	engine.NilStorage
+}
+
+func (f *failingReset) ResetOrderBook() error {
+	return errors.New("disk full")
+}
+
+func TestWipeReportsStorageError(t *testing.T) {
+	ts, _ := newTestServer(t, func(string) engine.Storage { return &failingReset{} }, nil, nil)
+
+	resp, err := http.Post(ts.URL+"/api/BTC-USD/wipe", "application/json", nil)
+	if err != nil {
+		t.Fatalf("tests - POST failed. expected=%v, got=%v", nil, err)
+	}
+	defer resp.Body.Close()
+	var body ErrorResponse
+	json.NewDecoder(resp.Body).Decode(&body)
+	if resp.StatusCode != http.StatusInternalServerError || resp.Header.Get("Content-Type") != "application/json" || body.Error != "storage_error" {
+		t.Fatalf("tests - failed wipe should answer a JSON error. expected=%s, got=%d %+v", "storage_error", resp.StatusCode, body)
+	}
+}
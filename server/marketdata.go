@@ -0,0 +1,432 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"limit-order-book/engine"
+	"limit-order-book/exchange"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// Market data is served on /ws/marketdata. A client sends
+//
+//	{"op": "subscribe", "channel": "book", "symbol": "BTC-USD"}
+//
+// and gets an ack, a snapshot of every price level, then an update with
+// the levels that changed after every command. The trades channel starts
+// with the latest trades and then sends every trade as it happens. Every
+// message carries the seq of the command it follows. A client that
+// doesn't keep up has its queued messages dropped, is told it lagged, and
+// gets a fresh snapshot of each of its channels.
+const (
+	BookChannel   = "book"
+	TradesChannel = "trades"
+)
+
+const (
+	wsSendQueue  = 256
+	wsWriteWait  = 10 * time.Second
+	wsPongWait   = 60 * time.Second
+	wsPingPeriod = wsPongWait * 9 / 10
+	wsMaxMessage = 4096
+
+	// recentTrades is how many trades a trades snapshot holds.
+	recentTrades = 50
+)
+
+// MarketDataRequest subscribes to or unsubscribes from a channel of one
+// symbol.
+type MarketDataRequest struct {
+	Op      string `json:"op"`
+	Channel string `json:"channel"`
+	Symbol  string `json:"symbol"`
+}
+
+// StatusMessage acks a request ("subscribed", "unsubscribed"), reports a
+// bad one ("error") or tells a slow client its updates were dropped
+// ("lagged").
+type StatusMessage struct {
+	Type    string `json:"type"`
+	Channel string `json:"channel,omitempty"`
+	Symbol  string `json:"symbol,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type LevelMessage struct {
+	Side   string `json:"side,omitempty"`
+	Price  int    `json:"price"`
+	Volume int    `json:"volume"`
+}
+
+// BookSnapshotMessage holds every level of the book, best first.
+type BookSnapshotMessage struct {
+	Type    string         `json:"type"`
+	Channel string         `json:"channel"`
+	Symbol  string         `json:"symbol"`
+	Seq     uint64         `json:"seq"`
+	Bids    []LevelMessage `json:"bids"`
+	Asks    []LevelMessage `json:"asks"`
+}
+
+// BookUpdateMessage holds the levels a command changed. Zero volume
+// means the level is gone.
+type BookUpdateMessage struct {
+	Type    string         `json:"type"`
+	Channel string         `json:"channel"`
+	Symbol  string         `json:"symbol"`
+	Seq     uint64         `json:"seq"`
+	Changes []LevelMessage `json:"changes"`
+}
+
+type TradeMessage struct {
+	ID          uuid.UUID `json:"id"`
+	Price       int       `json:"price"`
+	Size        int       `json:"size"`
+	Time        time.Time `json:"time"`
+	BuyOrderID  uuid.UUID `json:"buy_order_id"`
+	SellOrderID uuid.UUID `json:"sell_order_id"`
+}
+
+// TradesMessage holds the latest trades, as a "snapshot", or those made
+// by one command, as an "update".
+type TradesMessage struct {
+	Type    string         `json:"type"`
+	Channel string         `json:"channel"`
+	Symbol  string         `json:"symbol"`
+	Seq     uint64         `json:"seq"`
+	Trades  []TradeMessage `json:"trades"`
+}
+
+func sideName(side engine.Side) string {
+	return strings.ToLower(side.String())
+}
+
+func levelMessages(levels []engine.LevelView) []LevelMessage {
+	messages := make([]LevelMessage, 0, len(levels))
+	for _, level := range levels {
+		messages = append(messages, LevelMessage{Price: level.Price, Volume: level.Volume})
+	}
+	return messages
+}
+
+func tradeMessages(trades []engine.Trade) []TradeMessage {
+	messages := make([]TradeMessage, 0, len(trades))
+	for _, t := range trades {
+		messages = append(messages, TradeMessage{
+			ID:          t.ID,
+			Price:       t.Price,
+			Size:        t.Size,
+			Time:        t.Time,
+			BuyOrderID:  t.BuyOrderID,
+			SellOrderID: t.SellOrderID,
+		})
+	}
+	return messages
+}
+
+// marketData keeps one feed per symbol, and the events of every feed for
+// the stream.
+type marketData struct {
+	exchange *exchange.Exchange
+	events   *eventRing
+	mu       sync.Mutex
+	feeds    map[string]*feed
+}
+
+func newMarketData(ex *exchange.Exchange) *marketData {
+	return &marketData{exchange: ex, events: newEventRing(streamBuffer), feeds: make(map[string]*feed)}
+}
+
+// feed returns the feed of symbol, watching its book from the first call.
+func (m *marketData) feed(symbol string) (*feed, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if f, ok := m.feeds[symbol]; ok {
+		return f, true
+	}
+	book, ok := m.exchange.Book(symbol)
+	if !ok {
+		return nil, false
+	}
+	f := &feed{symbol: symbol, book: book, events: m.events, clients: make(map[*wsClient]map[string]bool)}
+	book.Watch(f.publish)
+	m.feeds[symbol] = f
+	return f, true
+}
+
+// feed turns the snapshots a book publishes into stream events and
+// messages for the clients subscribed to it.
+type feed struct {
+	symbol  string
+	book    *engine.Sequencer
+	events  *eventRing
+	mu      sync.Mutex
+	clients map[*wsClient]map[string]bool
+}
+
+// publish runs on the book's sequencer goroutine after every command.
+func (f *feed) publish(prev, next *engine.Snapshot) {
+	changes, trades := engine.DiffViews(prev.View, next.View)
+	f.record(next.Seq, changes, trades)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.clients) == 0 {
+		return
+	}
+
+	var book, prints []byte
+	if len(changes) > 0 {
+		update := BookUpdateMessage{Type: "update", Channel: BookChannel, Symbol: f.symbol, Seq: next.Seq}
+		for _, c := range changes {
+			update.Changes = append(update.Changes, LevelMessage{Side: sideName(c.Side), Price: c.Price, Volume: c.Volume})
+		}
+		book, _ = json.Marshal(update)
+	}
+	if len(trades) > 0 {
+		prints, _ = json.Marshal(TradesMessage{Type: "update", Channel: TradesChannel, Symbol: f.symbol, Seq: next.Seq, Trades: tradeMessages(trades)})
+	}
+
+	for client, channels := range f.clients {
+		if book != nil && channels[BookChannel] {
+			client.enqueue(book)
+		}
+		if prints != nil && channels[TradesChannel] {
+			client.enqueue(prints)
+		}
+	}
+}
+
+// subscribe adds the client to channel and queues a snapshot of it. It
+// runs between two commands, so the snapshot is exactly what the next
+// update applies to.
+func (f *feed) subscribe(client *wsClient, channel string) error {
+	return f.book.Read(func(*engine.OrderBook) {
+		snapshot := f.book.Snapshot()
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.clients[client] == nil {
+			f.clients[client] = make(map[string]bool)
+		}
+		f.clients[client][channel] = true
+
+		var message any
+		switch channel {
+		case BookChannel:
+			message = BookSnapshotMessage{
+				Type:    "snapshot",
+				Channel: BookChannel,
+				Symbol:  f.symbol,
+				Seq:     snapshot.Seq,
+				Bids:    levelMessages(snapshot.View.Bids),
+				Asks:    levelMessages(snapshot.View.Asks),
+			}
+		case TradesChannel:
+			trades := snapshot.View.Trades
+			trades = trades[max(0, len(trades)-recentTrades):]
+			message = TradesMessage{Type: "snapshot", Channel: TradesChannel, Symbol: f.symbol, Seq: snapshot.Seq, Trades: tradeMessages(trades)}
+		}
+		client.send(message)
+	})
+}
+
+func (f *feed) unsubscribe(client *wsClient, channel string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.clients[client], channel)
+	if len(f.clients[client]) == 0 {
+		delete(f.clients, client)
+	}
+}
+
+type subscription struct {
+	symbol  string
+	channel string
+}
+
+// wsClient is one connection. Only its writer goroutine writes to the
+// connection; everything else queues messages for it.
+type wsClient struct {
+	conn   *websocket.Conn
+	queue  chan []byte
+	lagged atomic.Bool
+	done   chan struct{}
+
+	mu            sync.Mutex
+	subscriptions map[subscription]*feed
+}
+
+// enqueue queues a message without blocking. If the queue is full the
+// client has fallen behind: nothing more is queued until the writer has
+// caught it up with fresh snapshots.
+func (c *wsClient) enqueue(message []byte) {
+	if c.lagged.Load() {
+		return
+	}
+	select {
+	case c.queue <- message:
+	default:
+		c.lagged.Store(true)
+	}
+}
+
+func (c *wsClient) send(message any) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		Logger.Printf("Failed to encode market data: %s", err)
+		return
+	}
+	c.enqueue(data)
+}
+
+func (s *Server) marketDataSocket(w http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already answered.
+		return
+	}
+
+	client := &wsClient{
+		conn:          conn,
+		queue:         make(chan []byte, wsSendQueue),
+		done:          make(chan struct{}),
+		subscriptions: make(map[subscription]*feed),
+	}
+	go client.write()
+	s.readMarketData(client)
+
+	close(client.done)
+	client.mu.Lock()
+	for sub, f := range client.subscriptions {
+		f.unsubscribe(client, sub.channel)
+	}
+	// So a writer catching up doesn't subscribe it again.
+	client.subscriptions = nil
+	client.mu.Unlock()
+}
+
+// readMarketData handles the client's requests until it goes away.
+func (s *Server) readMarketData(client *wsClient) {
+	conn := client.conn
+	conn.SetReadLimit(wsMaxMessage)
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		var req MarketDataRequest
+		if err := conn.ReadJSON(&req); err != nil {
+			var syntaxErr *json.SyntaxError
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+				client.send(StatusMessage{Type: "error", Message: "Invalid JSON: " + err.Error()})
+				continue
+			}
+			return
+		}
+		if err := s.handleMarketDataRequest(client, req); err != nil {
+			client.send(StatusMessage{Type: "error", Channel: req.Channel, Symbol: req.Symbol, Message: err.Error()})
+		}
+	}
+}
+
+func (s *Server) handleMarketDataRequest(client *wsClient, req MarketDataRequest) error {
+	if req.Channel != BookChannel && req.Channel != TradesChannel {
+		return fmt.Errorf("unknown channel %q, use %q or %q", req.Channel, BookChannel, TradesChannel)
+	}
+	f, ok := s.marketData.feed(req.Symbol)
+	if !ok {
+		return fmt.Errorf("unknown symbol %q", req.Symbol)
+	}
+	sub := subscription{symbol: req.Symbol, channel: req.Channel}
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	switch req.Op {
+	case "subscribe":
+		if _, ok := client.subscriptions[sub]; ok {
+			return fmt.Errorf("already subscribed to %s %s", req.Symbol, req.Channel)
+		}
+		client.send(StatusMessage{Type: "subscribed", Channel: req.Channel, Symbol: req.Symbol})
+		if err := f.subscribe(client, req.Channel); err != nil {
+			return err
+		}
+		client.subscriptions[sub] = f
+	case "unsubscribe":
+		if _, ok := client.subscriptions[sub]; !ok {
+			return fmt.Errorf("not subscribed to %s %s", req.Symbol, req.Channel)
+		}
+		f.unsubscribe(client, req.Channel)
+		delete(client.subscriptions, sub)
+		client.send(StatusMessage{Type: "unsubscribed", Channel: req.Channel, Symbol: req.Symbol})
+	default:
+		return fmt.Errorf("unknown op %q, use subscribe or unsubscribe", req.Op)
+	}
+	return nil
+}
+
+// write sends queued messages and pings until the client goes away. A
+// client that fell behind loses what was queued and gets a snapshot of
+// each of its channels instead.
+func (c *wsClient) write() {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case message := <-c.queue:
+			if err := c.writeMessage(websocket.TextMessage, message); err != nil {
+				return
+			}
+			if c.lagged.Load() {
+				c.catchUp()
+			}
+		case <-ticker.C:
+			if err := c.writeMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-c.done:
+			c.writeMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			return
+		}
+	}
+}
+
+func (c *wsClient) writeMessage(messageType int, data []byte) error {
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	return c.conn.WriteMessage(messageType, data)
+}
+
+// catchUp drops the queue and resubscribes the client to everything.
+func (c *wsClient) catchUp() {
+	for len(c.queue) > 0 {
+		<-c.queue
+	}
+	c.lagged.Store(false)
+	c.send(StatusMessage{Type: "lagged", Message: "updates were dropped; snapshots follow"})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for sub, f := range c.subscriptions {
+		if err := f.subscribe(c, sub.channel); err != nil {
+			c.send(StatusMessage{Type: "error", Channel: sub.channel, Symbol: sub.symbol, Message: err.Error()})
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsMessage holds any market data message.
+type wsMessage struct {
+	Type    string         `json:"type"`
+	Channel string         `json:"channel"`
+	Symbol  string         `json:"symbol"`
+	Message string         `json:"message"`
+	Seq     uint64         `json:"seq"`
+	Bids    []LevelMessage `json:"bids"`
+	Asks    []LevelMessage `json:"asks"`
+	Changes []LevelMessage `json:"changes"`
+	Trades  []TradeMessage `json:"trades"`
+}
+
+func dialMarketData(t *testing.T, ts *httptest.Server) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws/marketdata", nil)
+	if err != nil {
+		t.Fatalf("tests - dial failed. expected=%v, got=%v", nil, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func request(t *testing.T, conn *websocket.Conn, op, channel, symbol string) {
+	t.Helper()
+	if err := conn.WriteJSON(MarketDataRequest{Op: op, Channel: channel, Symbol: symbol}); err != nil {
+		t.Fatalf("tests - request failed. expected=%v, got=%v", nil, err)
+	}
+}
+
+// expect reads the next message and checks its type and channel.
+func expect(t *testing.T, conn *websocket.Conn, typ, channel string) wsMessage {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var message wsMessage
+	if err := conn.ReadJSON(&message); err != nil {
+		t.Fatalf("tests - read failed. expected=%s %s, got=%v", typ, channel, err)
+	}
+	if message.Type != typ || message.Channel != channel {
+		t.Fatalf("tests - wrong message. expected=%s %s, got=%+v", typ, channel, message)
+	}
+	return message
+}
+
+func TestMarketDataBookSnapshotAndUpdates(t *testing.T) {
+	ts, _ := newTestServer(t, nil, nil, nil)
+	postOrder(t, ts, "buy", 40, 3)
+
+	conn := dialMarketData(t, ts)
+	request(t, conn, "subscribe", BookChannel, "BTC-USD")
+	expect(t, conn, "subscribed", BookChannel)
+	snapshot := expect(t, conn, "snapshot", BookChannel)
+	if len(snapshot.Bids) != 1 || snapshot.Bids[0].Price != 40 || snapshot.Bids[0].Volume != 3 || len(snapshot.Asks) != 0 {
+		t.Fatalf("tests - snapshot should hold the book. expected=%v, got=%+v", "bid 40x3", snapshot)
+	}
+
+	postOrder(t, ts, "sell", 40, 1)
+	update := expect(t, conn, "update", BookChannel)
+	if len(update.Changes) != 1 || update.Changes[0] != (LevelMessage{Side: "buy", Price: 40, Volume: 2}) {
+		t.Fatalf("tests - update should hold the changed level. expected=%v, got=%+v", "buy 40x2", update.Changes)
+	}
+	if update.Seq <= snapshot.Seq {
+		t.Fatalf("tests - update should follow the snapshot. expected=>%d, got=%d", snapshot.Seq, update.Seq)
+	}
+}
+
+func TestMarketDataTradesAndUnsubscribe(t *testing.T) {
+	ts, _ := newTestServer(t, nil, nil, nil)
+	postOrder(t, ts, "sell", 42, 1)
+	postOrder(t, ts, "buy", 42, 1)
+
+	conn := dialMarketData(t, ts)
+	request(t, conn, "subscribe", BookChannel, "BTC-USD")
+	expect(t, conn, "subscribed", BookChannel)
+	expect(t, conn, "snapshot", BookChannel)
+	request(t, conn, "subscribe", TradesChannel, "BTC-USD")
+	expect(t, conn, "subscribed", TradesChannel)
+	if snapshot := expect(t, conn, "snapshot", TradesChannel); len(snapshot.Trades) != 1 || snapshot.Trades[0].Price != 42 {
+		t.Fatalf("tests - snapshot should hold the latest trades. expected=%v, got=%+v", "one at 42", snapshot.Trades)
+	}
+
+	// Only the trades channel is left to hear about the next trade.
+	request(t, conn, "unsubscribe", BookChannel, "BTC-USD")
+	expect(t, conn, "unsubscribed", BookChannel)
+	postOrder(t, ts, "sell", 43, 2)
+	postOrder(t, ts, "buy", 43, 2)
+	if update := expect(t, conn, "update", TradesChannel); len(update.Trades) != 1 || update.Trades[0].Size != 2 {
+		t.Fatalf("tests - update should hold the new trade. expected=%v, got=%+v", "2 at 43", update.Trades)
+	}
+}
+
+func TestMarketDataRejectsBadRequests(t *testing.T) {
+	ts, _ := newTestServer(t, nil, nil, nil)
+	conn := dialMarketData(t, ts)
+
+	for _, req := range []MarketDataRequest{
+		{Op: "subscribe", Channel: "quotes", Symbol: "BTC-USD"},
+		{Op: "subscribe", Channel: BookChannel, Symbol: "NOPE"},
+		{Op: "unsubscribe", Channel: BookChannel, Symbol: "BTC-USD"},
+		{Op: "watch", Channel: BookChannel, Symbol: "BTC-USD"},
+	} {
+		request(t, conn, req.Op, req.Channel, req.Symbol)
+		if message := expect(t, conn, "error", req.Channel); message.Message == "" {
+			t.Fatalf("tests - %+v should be refused with a reason. expected=%v, got=%+v", req, "a message", message)
+		}
+	}
+
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"op": 1}`))
+	expect(t, conn, "error", "")
+}
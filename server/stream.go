@@ -0,0 +1,194 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"limit-order-book/engine"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// /api/stream sends every trade and level change of every book, or of the
+// books named by ?symbol=A,B, as Server-Sent Events. Events are numbered
+// across all books. The latest streamBuffer of them are kept, so a client
+// reconnecting with Last-Event-ID (or ?last_event_id= where headers can't
+// be set) gets what it missed. If that is no longer kept it gets a reset
+// event and the stream carries on from the oldest event still held.
+const (
+	streamBuffer    = 10000
+	streamHeartbeat = 15 * time.Second
+	streamRetry     = 2 * time.Second
+)
+
+// TradeEvent is the data of a "trade" event.
+type TradeEvent struct {
+	Symbol string `json:"symbol"`
+	Seq    uint64 `json:"seq"`
+	TradeMessage
+}
+
+// LevelEvent is the data of a "level" event: the new volume at a price,
+// zero once the level is gone.
+type LevelEvent struct {
+	Symbol string `json:"symbol"`
+	Seq    uint64 `json:"seq"`
+	LevelMessage
+}
+
+type streamEvent struct {
+	id     uint64
+	kind   string
+	symbol string
+	data   []byte
+}
+
+// eventRing holds the latest events. Readers don't get their own queue:
+// they wait for the next event and read everything after the last one
+// they sent, so a slow reader only holds itself up.
+type eventRing struct {
+	mu     sync.Mutex
+	events []streamEvent
+	start  int // index of the oldest event
+	count  int
+	next   uint64
+	notify chan struct{}
+}
+
+func newEventRing(size int) *eventRing {
+	return &eventRing{
+		events: make([]streamEvent, size),
+		// Ids carry on from the last run, roughly, so an id from before a
+		// restart is just too old rather than taken for a new one.
+		next:   uint64(time.Now().UnixMicro()),
+		notify: make(chan struct{}),
+	}
+}
+
+func (r *eventRing) append(kind, symbol string, data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	event := streamEvent{id: r.next, kind: kind, symbol: symbol, data: data}
+	r.next++
+	if r.count < len(r.events) {
+		r.events[(r.start+r.count)%len(r.events)] = event
+		r.count++
+	} else {
+		r.events[r.start] = event
+		r.start = (r.start + 1) % len(r.events)
+	}
+
+	close(r.notify)
+	r.notify = make(chan struct{})
+}
+
+// last is the id of the latest event, or one before the next.
+func (r *eventRing) last() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.next - 1
+}
+
+// since returns the events after id, false if some of them are no longer
+// kept, the id to read on from, and a channel closed when the next event
+// comes in.
+func (r *eventRing) since(id uint64) ([]streamEvent, bool, uint64, <-chan struct{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	oldest := r.next - uint64(r.count)
+	complete := id+1 >= oldest && id < r.next
+	skip := 0
+	if complete {
+		skip = int(id + 1 - oldest)
+	}
+
+	events := make([]streamEvent, 0, r.count-skip)
+	for i := skip; i < r.count; i++ {
+		events = append(events, r.events[(r.start+i)%len(r.events)])
+	}
+	return events, complete, r.next - 1, r.notify
+}
+
+// record adds the trades and level changes of one command to the stream.
+func (f *feed) record(seq uint64, changes []engine.LevelChange, trades []engine.Trade) {
+	for _, t := range tradeMessages(trades) {
+		data, _ := json.Marshal(TradeEvent{Symbol: f.symbol, Seq: seq, TradeMessage: t})
+		f.events.append("trade", f.symbol, data)
+	}
+	for _, c := range changes {
+		data, _ := json.Marshal(LevelEvent{Symbol: f.symbol, Seq: seq, LevelMessage: LevelMessage{Side: sideName(c.Side), Price: c.Price, Volume: c.Volume}})
+		f.events.append("level", f.symbol, data)
+	}
+}
+
+func (s *Server) stream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming_unsupported", "Streaming unsupported")
+		return
+	}
+
+	var symbols []string
+	if param := r.URL.Query().Get("symbol"); param != "" {
+		symbols = strings.Split(param, ",")
+		for _, symbol := range symbols {
+			if _, ok := s.marketData.feed(symbol); !ok {
+				writeUnknownSymbol(w, symbol)
+				return
+			}
+		}
+	}
+
+	last := s.marketData.events.last()
+	resume := r.Header.Get("Last-Event-ID")
+	if resume == "" {
+		resume = r.URL.Query().Get("last_event_id")
+	}
+	if resume != "" {
+		id, err := strconv.ParseUint(resume, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_last_event_id", "Invalid Last-Event-ID")
+			return
+		}
+		last = id
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// Keeps nginx from buffering the stream.
+	w.Header().Set("X-Accel-Buffering", "no")
+	fmt.Fprintf(w, "retry: %d\n\n", streamRetry.Milliseconds())
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		events, complete, upTo, wait := s.marketData.events.since(last)
+		if !complete {
+			fmt.Fprintf(w, "event: reset\ndata: {\"message\": \"events after %d are no longer kept\"}\n\n", last)
+		}
+		last = upTo
+		for _, event := range events {
+			if symbols != nil && !slices.Contains(symbols, event.symbol) {
+				continue
+			}
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.id, event.kind, event.data)
+		}
+		flusher.Flush()
+
+		select {
+		case <-wait:
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type sseEvent struct {
+	id   uint64
+	kind string
+	data string
+}
+
+// openStream connects to /api/stream, resuming after lastEventID unless it
+// is empty.
+func openStream(t *testing.T, ts *httptest.Server, lastEventID string) (*bufio.Reader, func()) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/stream?symbol=BTC-USD", nil)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("tests - stream failed. expected=%d, got=%v %v", http.StatusOK, resp, err)
+	}
+	return bufio.NewReader(resp.Body), func() { resp.Body.Close() }
+}
+
+// nextEvent reads up to the next event, skipping the retry hint and
+// heartbeats.
+func nextEvent(t *testing.T, r *bufio.Reader) sseEvent {
+	t.Helper()
+	read := make(chan sseEvent, 1)
+	go func() {
+		var event sseEvent
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				close(read)
+				return
+			}
+			line = strings.TrimSuffix(line, "\n")
+			switch {
+			case line == "" && event.kind != "":
+				read <- event
+				return
+			case strings.HasPrefix(line, "id: "):
+				event.id, _ = strconv.ParseUint(strings.TrimPrefix(line, "id: "), 10, 64)
+			case strings.HasPrefix(line, "event: "):
+				event.kind = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				event.data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+	}()
+
+	select {
+	case event, ok := <-read:
+		if !ok {
+			t.Fatalf("tests - stream ended. expected=%v, got=%v", "an event", nil)
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatalf("tests - no event. expected=%v, got=%v", "an event", nil)
+	}
+	return sseEvent{}
+}
+
+func TestStreamResumesAfterLastEventID(t *testing.T) {
+	ts, _ := newTestServer(t, nil, nil, nil)
+	stream, stop := openStream(t, ts, "")
+
+	postOrder(t, ts, "sell", 40, 2)
+	first := nextEvent(t, stream)
+	var level LevelEvent
+	json.Unmarshal([]byte(first.data), &level)
+	if first.kind != "level" || level.Symbol != "BTC-USD" || level.Price != 40 || level.Volume != 2 {
+		t.Fatalf("tests - first event should be the new level. expected=%v, got=%+v", "sell 40x2", first)
+	}
+	stop()
+
+	// Missed while disconnected.
+	postOrder(t, ts, "buy", 40, 1)
+	stream, stop = openStream(t, ts, strconv.FormatUint(first.id, 10))
+	defer stop()
+
+	trade := nextEvent(t, stream)
+	var event TradeEvent
+	json.Unmarshal([]byte(trade.data), &event)
+	if trade.kind != "trade" || event.Price != 40 || event.Size != 1 || trade.id <= first.id {
+		t.Fatalf("tests - resumed stream should start with the missed trade. expected=%v, got=%+v", "trade 40x1", trade)
+	}
+	next := nextEvent(t, stream)
+	json.Unmarshal([]byte(next.data), &level)
+	if next.kind != "level" || level.Volume != 1 || next.id <= trade.id {
+		t.Fatalf("tests - missed level change should follow. expected=%v, got=%+v", "sell 40x1", next)
+	}
+}
+
+func TestStreamResetsWhenEventsAreGone(t *testing.T) {
+	ts, _ := newTestServer(t, nil, nil, nil)
+	postOrder(t, ts, "sell", 40, 2)
+
+	stream, stop := openStream(t, ts, "1")
+	defer stop()
+	if event := nextEvent(t, stream); event.kind != "reset" {
+		t.Fatalf("tests - too old an id should get a reset. expected=%s, got=%+v", "reset", event)
+	}
+	if event := nextEvent(t, stream); event.kind != "level" {
+		t.Fatalf("tests - stream should carry on from the oldest event. expected=%s, got=%+v", "level", event)
+	}
+}
+
+func TestStreamRejectsBadParameters(t *testing.T) {
+	ts, _ := newTestServer(t, nil, nil, nil)
+
+	if code := getError(t, ts, "/api/stream?symbol=NOPE", http.StatusNotFound); code != "unknown_symbol" {
+		t.Fatalf("tests - unknown symbol should be refused. expected=%s, got=%s", "unknown_symbol", code)
+	}
+	if code := getError(t, ts, "/api/stream?last_event_id=x", http.StatusBadRequest); code != "invalid_last_event_id" {
+		t.Fatalf("tests - invalid id should be refused. expected=%s, got=%s", "invalid_last_event_id", code)
+	}
+}
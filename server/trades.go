@@ -0,0 +1,141 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"limit-order-book/engine"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultTradeLimit = 100
+	maxTradeLimit     = 1000
+)
+
+// TradesResponse is a page of trade history. NextCursor, if set, gets the
+// next page when passed as ?cursor= along with the same filters.
+type TradesResponse struct {
+	Symbol     string         `json:"symbol"`
+	Trades     []TradeMessage `json:"trades"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+// encodeCursor and decodeCursor turn a trade's place in the paging order
+// into an opaque string and back.
+func encodeCursor(c engine.TradeCursor) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatUint(c.Seq, 10)))
+}
+
+func decodeCursor(s string) (*engine.TradeCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	seq, err := strconv.ParseUint(string(data), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &engine.TradeCursor{Seq: seq}, nil
+}
+
+// tradeHistory serves GET /api/trades from storage:
+//
+//	?symbol=S&from=T&to=T&min_price=P&max_price=P&order_id=ID&sort=asc|desc&limit=N&cursor=C
+//
+// from and to are RFC 3339 times, from inclusive and to exclusive.
+func (s *Server) tradeHistory(w http.ResponseWriter, r *http.Request) {
+	if s.trades == nil {
+		writeError(w, http.StatusNotImplemented, "not_implemented", engine.ErrNoTradeHistory.Error())
+		return
+	}
+
+	query := r.URL.Query()
+	q := engine.TradeQuery{Symbol: query.Get("symbol"), Limit: defaultTradeLimit}
+	if q.Symbol == "" {
+		q.Symbol = s.defaultSymbol()
+	}
+	if _, ok := s.exchange.Book(q.Symbol); !ok {
+		writeUnknownSymbol(w, q.Symbol)
+		return
+	}
+
+	invalid := func(param, message string) {
+		writeError(w, http.StatusBadRequest, "invalid_"+param, message)
+	}
+	var err error
+	if v := query.Get("from"); v != "" {
+		if q.From, err = time.Parse(time.RFC3339Nano, v); err != nil {
+			invalid("from", "Invalid from, use an RFC 3339 time")
+			return
+		}
+	}
+	if v := query.Get("to"); v != "" {
+		if q.To, err = time.Parse(time.RFC3339Nano, v); err != nil {
+			invalid("to", "Invalid to, use an RFC 3339 time")
+			return
+		}
+	}
+	if v := query.Get("min_price"); v != "" {
+		if q.MinPrice, err = strconv.Atoi(v); err != nil || q.MinPrice <= 0 {
+			invalid("min_price", "Invalid min_price, use a positive price")
+			return
+		}
+	}
+	if v := query.Get("max_price"); v != "" {
+		if q.MaxPrice, err = strconv.Atoi(v); err != nil || q.MaxPrice <= 0 {
+			invalid("max_price", "Invalid max_price, use a positive price")
+			return
+		}
+	}
+	if v := query.Get("order_id"); v != "" {
+		if q.OrderID, err = uuid.Parse(v); err != nil {
+			invalid("order_id", "Invalid order_id")
+			return
+		}
+	}
+	switch query.Get("sort") {
+	case "", "asc":
+	case "desc":
+		q.Descending = true
+	default:
+		invalid("sort", "Invalid sort, use 'asc' or 'desc'")
+		return
+	}
+	if v := query.Get("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit <= 0 || q.Limit > maxTradeLimit {
+			invalid("limit", fmt.Sprintf("Invalid limit, use 1 to %d", maxTradeLimit))
+			return
+		}
+	}
+	if v := query.Get("cursor"); v != "" {
+		if q.After, err = decodeCursor(v); err != nil {
+			invalid("cursor", "Invalid cursor")
+			return
+		}
+	}
+
+	// One more than asked tells whether there is a next page.
+	limit := q.Limit
+	q.Limit++
+	trades, err := s.trades.QueryTrades(q)
+	if err != nil {
+		writeEngineError(w, fmt.Errorf("%w: trades: %w", engine.ErrStorage, err))
+		return
+	}
+
+	response := TradesResponse{Symbol: q.Symbol}
+	if len(trades) > limit {
+		trades = trades[:limit]
+		last := trades[limit-1]
+		response.NextCursor = encodeCursor(engine.TradeCursor{Seq: last.Seq})
+	}
+	response.Trades = tradeMessages(trades)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"limit-order-book/engine"
+	"limit-order-book/storage"
+
+	"github.com/google/uuid"
+)
+
+// newHistoryServer serves a book stored as JSON, which keeps a trade
+// history, after trading once at each price from 40 to 44. It returns the
+// buy order of each trade.
+func newHistoryServer(t *testing.T) (*httptest.Server, []uuid.UUID) {
+	t.Helper()
+	t.Setenv("ORDERBOOK", filepath.Join(t.TempDir(), "orderbook.json"))
+	ts, _ := newTestServer(t, func(symbol string) engine.Storage {
+		return &storage.JsonStorage{Symbol: symbol, Sync: storage.SyncNever}
+	}, &storage.JsonTradeHistory{}, nil)
+
+	var buys []uuid.UUID
+	for price := 40; price < 45; price++ {
+		postOrder(t, ts, "sell", price, 1)
+		buys = append(buys, postOrder(t, ts, "buy", price, 1).Id)
+	}
+	return ts, buys
+}
+
+// tradePrices follows next_cursor from path and returns the price of
+// every trade, and how many pages there were.
+func tradePrices(t *testing.T, ts *httptest.Server, path string) ([]int, int) {
+	t.Helper()
+	var prices []int
+	pages := 0
+	for cursor := ""; ; pages++ {
+		var response TradesResponse
+		page := path
+		if cursor != "" {
+			page += "&cursor=" + cursor
+		}
+		getJSON(t, ts, page, http.StatusOK, &response)
+		for _, trade := range response.Trades {
+			prices = append(prices, trade.Price)
+		}
+		if cursor = response.NextCursor; cursor == "" {
+			return prices, pages + 1
+		}
+	}
+}
+
+func equalPrices(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestTradesPagesWithCursor(t *testing.T) {
+	ts, _ := newHistoryServer(t)
+
+	prices, pages := tradePrices(t, ts, "/api/trades?symbol=BTC-USD&limit=2")
+	if expected := []int{40, 41, 42, 43, 44}; !equalPrices(prices, expected) || pages != 3 {
+		t.Fatalf("tests - pages should hold every trade in order. expected=%v in 3 pages, got=%v in %d", expected, prices, pages)
+	}
+
+	prices, pages = tradePrices(t, ts, "/api/trades?symbol=BTC-USD&sort=desc&limit=4")
+	if expected := []int{44, 43, 42, 41, 40}; !equalPrices(prices, expected) || pages != 2 {
+		t.Fatalf("tests - newest first should page backwards. expected=%v in 2 pages, got=%v in %d", expected, prices, pages)
+	}
+
+	prices, _ = tradePrices(t, ts, "/api/trades?symbol=BTC-USD&limit=5")
+	if len(prices) != 5 {
+		t.Fatalf("tests - a full last page should end the paging. expected=%d, got=%v", 5, prices)
+	}
+}
+
+func TestTradesFilters(t *testing.T) {
+	ts, buys := newHistoryServer(t)
+
+	prices, _ := tradePrices(t, ts, "/api/trades?symbol=BTC-USD&min_price=41&max_price=42&limit=1")
+	if expected := []int{41, 42}; !equalPrices(prices, expected) {
+		t.Fatalf("tests - wrong trades in price range. expected=%v, got=%v", expected, prices)
+	}
+
+	prices, _ = tradePrices(t, ts, "/api/trades?symbol=BTC-USD&order_id="+buys[3].String())
+	if expected := []int{43}; !equalPrices(prices, expected) {
+		t.Fatalf("tests - wrong trades of order. expected=%v, got=%v", expected, prices)
+	}
+
+	var all TradesResponse
+	getJSON(t, ts, "/api/trades?symbol=BTC-USD", http.StatusOK, &all)
+	from := url.QueryEscape(all.Trades[2].Time.Format(time.RFC3339Nano))
+	to := url.QueryEscape(all.Trades[4].Time.Format(time.RFC3339Nano))
+	prices, _ = tradePrices(t, ts, "/api/trades?symbol=BTC-USD&from="+from+"&to="+to)
+	if expected := []int{42, 43}; !equalPrices(prices, expected) {
+		t.Fatalf("tests - wrong trades in [from, to). expected=%v, got=%v", expected, prices)
+	}
+}
+
+func TestTradesRejectsBadParameters(t *testing.T) {
+	ts, _ := newHistoryServer(t)
+
+	for query, expected := range map[string]string{
+		"from=yesterday": "invalid_from",
+		"to=2024-13-01":  "invalid_to",
+		"min_price=0":    "invalid_min_price",
+		"max_price=-1":   "invalid_max_price",
+		"order_id=42":    "invalid_order_id",
+		"sort=up":        "invalid_sort",
+		"limit=0":        "invalid_limit",
+		"limit=1001":     "invalid_limit",
+		"cursor=!!":      "invalid_cursor",
+		"cursor=eA":      "invalid_cursor",
+	} {
+		if code := getError(t, ts, "/api/trades?symbol=BTC-USD&"+query, http.StatusBadRequest); code != expected {
+			t.Fatalf("tests - %s should be refused. expected=%s, got=%s", query, expected, code)
+		}
+	}
+	if code := getError(t, ts, "/api/trades?symbol=NOPE", http.StatusNotFound); code != "unknown_symbol" {
+		t.Fatalf("tests - unknown symbol should be refused. expected=%s, got=%s", "unknown_symbol", code)
+	}
+
+	noHistory, _ := newTestServer(t, nil, nil, nil)
+	if code := getError(t, noHistory, "/api/trades", http.StatusNotImplemented); code != "not_implemented" {
+		t.Fatalf("tests - storage without history should say so. expected=%s, got=%s", "not_implemented", code)
+	}
+}
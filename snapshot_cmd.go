@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"limit-order-book/engine"
+	"limit-order-book/exchange"
+	"limit-order-book/storage"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const snapshotUsage = `usage: limit-order-book snapshot <command> [flags]
+
+commands:
+  take    recover a symbol from its snapshots and journal and snapshot it
+  list    list snapshots
+  verify  check every snapshot's checksum
+  prune   delete all but the newest -keep snapshots of each symbol`
+
+// runSnapshot runs the snapshot subcommand. It works on the files alone,
+// so it can be run against a stopped exchange.
+func runSnapshot(args []string) error {
+	if len(args) == 0 {
+		return errors.New(snapshotUsage)
+	}
+
+	fs := flag.NewFlagSet("snapshot "+args[0], flag.ContinueOnError)
+	dir := fs.String("dir", "snapshots", "snapshot directory")
+	symbol := fs.String("symbol", "", "symbol to work on; empty means every symbol")
+	journalDir := fs.String("journal", "", "journal directory, for take")
+	config := fs.String("config", "", "JSON file with the symbol's instrument spec, for take")
+	keep := fs.Int("keep", 3, "snapshots to keep per symbol, for prune")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "take":
+		return takeSnapshot(*dir, *journalDir, *symbol, *config)
+	case "list":
+		infos, err := storage.ListSnapshots(*dir, *symbol)
+		if err != nil {
+			return err
+		}
+		printSnapshots(infos)
+		return nil
+	case "verify":
+		infos, err := storage.ListSnapshots(*dir, *symbol)
+		if err != nil {
+			return err
+		}
+		printSnapshots(infos)
+		for _, info := range infos {
+			if info.Err != nil {
+				return errors.New("some snapshots failed verification")
+			}
+		}
+		return nil
+	case "prune":
+		pruned, err := storage.PruneSnapshots(*dir, *symbol, *keep)
+		for _, info := range pruned {
+			fmt.Printf("deleted %s\n", info.Path)
+		}
+		return err
+	}
+	return fmt.Errorf("unknown snapshot command %q\n%s", args[0], snapshotUsage)
+}
+
+// takeSnapshot rebuilds a book the way the exchange does on startup,
+// from its latest snapshot and journal, and saves a snapshot of it.
+func takeSnapshot(dir string, journalDir string, symbol string, config string) error {
+	if symbol == "" || journalDir == "" {
+		return errors.New("take needs -symbol and -journal")
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	ob, err := recoverBook(symbol, journalDir, dir, config)
+	if err != nil {
+		return err
+	}
+
+	snapshots := &storage.FileSnapshots{Dir: dir, Symbol: symbol}
+	if err := snapshots.Save(ob.Seq(), ob.ToDTO()); err != nil {
+		return err
+	}
+	fmt.Printf("%s snapshot at seq %d\n", symbol, ob.Seq())
+	return nil
+}
+
+// recoverBook rebuilds a symbol's book from its latest snapshot, if
+// snapshotDir is set, and its journal, without touching storage.
+func recoverBook(symbol string, journalDir string, snapshotDir string, config string) (*engine.OrderBook, error) {
+	instrument, err := instrumentFor(symbol, config)
+	if err != nil {
+		return nil, err
+	}
+
+	journal := &storage.FileJournal{Path: filepath.Join(journalDir, symbol+".journal")}
+	defer journal.Close()
+
+	ob := engine.NewOrderBook()
+	ob.SetInstrument(instrument)
+	ob.AddJournal(journal)
+	if snapshotDir != "" {
+		ob.AddSnapshots(&storage.FileSnapshots{Dir: snapshotDir, Symbol: symbol})
+	}
+	ob.RestoreOrderBook()
+	return ob, nil
+}
+
+// instrumentFor returns the symbol's instrument spec from the config file,
+// or the default one.
+func instrumentFor(symbol string, config string) (engine.Instrument, error) {
+	instrument := engine.DefaultInstrument(symbol)
+	if config == "" {
+		return instrument, nil
+	}
+
+	cfg, err := exchange.LoadConfig(config)
+	if err != nil {
+		return instrument, err
+	}
+	for _, i := range cfg.Symbols {
+		if i.Symbol == symbol {
+			instrument = i.WithDefaults()
+		}
+	}
+	return instrument, nil
+}
+
+func printSnapshots(infos []storage.SnapshotInfo) {
+	for _, info := range infos {
+		status := "ok"
+		if info.Err != nil {
+			status = "BAD: " + info.Err.Error()
+		}
+		fmt.Printf("%-12s %20d %-30s %10d %s\n", info.Symbol, info.Seq, info.Time.Format(time.RFC3339), info.Size, status)
+	}
+}
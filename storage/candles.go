@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+
+	"limit-order-book/engine"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const saveCandleSQL = `
+	INSERT INTO candles (symbol, resolution, start, open, high, low, close, volume, notional, trades)
+	VALUES (%s)
+	ON CONFLICT (symbol, resolution, start) DO UPDATE SET
+	    open = EXCLUDED.open,
+	    high = EXCLUDED.high,
+	    low = EXCLUDED.low,
+	    close = EXCLUDED.close,
+	    volume = EXCLUDED.volume,
+	    notional = EXCLUDED.notional,
+	    trades = EXCLUDED.trades`
+
+func candleRow(c engine.Candle) []any {
+	return []any{c.Symbol, c.Interval, c.Start.UTC(), c.Open, c.High, c.Low, c.Close, c.Volume, c.Notional, c.Trades}
+}
+
+// candleQuerySQL builds the SELECT for q, newest first so LIMIT keeps the
+// latest; scanCandles puts them back in order.
+func candleQuerySQL(q engine.CandleQuery, placeholder func(n int) string) (string, []any) {
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return placeholder(len(args))
+	}
+
+	where := []string{"symbol = " + arg(q.Symbol), "resolution = " + arg(q.Interval)}
+	if !q.From.IsZero() {
+		where = append(where, "start >= "+arg(q.From.UTC()))
+	}
+	if !q.To.IsZero() {
+		where = append(where, "start < "+arg(q.To.UTC()))
+	}
+
+	query := fmt.Sprintf(`
+		SELECT symbol, resolution, start, open, high, low, close, volume, notional, trades
+		FROM candles
+		WHERE %s
+		ORDER BY start DESC`, strings.Join(where, " AND "))
+	if q.Limit > 0 {
+		query += " LIMIT " + arg(q.Limit)
+	}
+	return query, args
+}
+
+func scanCandles(rows tradeRows) ([]engine.Candle, error) {
+	candles := []engine.Candle{}
+	for rows.Next() {
+		var c engine.Candle
+		if err := rows.Scan(&c.Symbol, &c.Interval, &c.Start, &c.Open, &c.High, &c.Low, &c.Close, &c.Volume, &c.Notional, &c.Trades); err != nil {
+			return nil, err
+		}
+		candles = append(candles, c)
+	}
+	slices.Reverse(candles)
+	return candles, rows.Err()
+}
+
+// PostgresCandleStore keeps the candles of every symbol.
+type PostgresCandleStore struct {
+	Database *PostgresDB
+}
+
+func (s *PostgresCandleStore) SaveCandles(candles []engine.Candle) error {
+	query := fmt.Sprintf(saveCandleSQL, "$1, $2, $3, $4, $5, $6, $7, $8, $9, $10")
+	return s.Database.retry(func(ctx context.Context) error {
+		batch := &pgx.Batch{}
+		for _, c := range candles {
+			batch.Queue(query, candleRow(c)...)
+		}
+		return s.Database.SendBatch(ctx, batch).Close()
+	})
+}
+
+func (s *PostgresCandleStore) LoadCandles(q engine.CandleQuery) ([]engine.Candle, error) {
+	query, args := candleQuerySQL(q, func(n int) string { return fmt.Sprintf("$%d", n) })
+
+	var candles []engine.Candle
+	err := s.Database.retry(func(ctx context.Context) error {
+		rows, err := s.Database.Query(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		candles, err = scanCandles(rows)
+		return err
+	})
+	return candles, err
+}
+
+func (s *PostgresCandleStore) DeleteCandles(symbol, interval string) error {
+	return s.Database.retry(func(ctx context.Context) error {
+		_, err := s.Database.Exec(ctx, `DELETE FROM candles WHERE symbol = $1 AND resolution = $2`, symbol, interval)
+		return err
+	})
+}
+
+// SqliteCandleStore keeps the candles of every symbol.
+type SqliteCandleStore struct {
+	Database *sql.DB
+}
+
+func (s *SqliteCandleStore) SaveCandles(candles []engine.Candle) error {
+	tx, err := s.Database.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(fmt.Sprintf(saveCandleSQL, "?, ?, ?, ?, ?, ?, ?, ?, ?, ?"))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, c := range candles {
+		if _, err := stmt.Exec(candleRow(c)...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SqliteCandleStore) LoadCandles(q engine.CandleQuery) ([]engine.Candle, error) {
+	query, args := candleQuerySQL(q, func(int) string { return "?" })
+	rows, err := s.Database.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanCandles(rows)
+}
+
+func (s *SqliteCandleStore) DeleteCandles(symbol, interval string) error {
+	_, err := s.Database.Exec(`DELETE FROM candles WHERE symbol = ? AND resolution = ?`, symbol, interval)
+	return err
+}
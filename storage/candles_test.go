@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"limit-order-book/engine"
+
+	"github.com/google/uuid"
+)
+
+func TestCandlesRebuildFromTradeHistory(t *testing.T) {
+	t.Setenv("TRADES", filepath.Join(t.TempDir(), "orderbook.db"))
+	db := InitSqlite()
+	defer db.Close()
+
+	tradingBook(&SqliteStorage{Database: db, Symbol: "BTC-USD"})
+	history := &SqliteTradeHistory{Database: db}
+	store := &SqliteCandleStore{Database: db}
+	intervals, _ := engine.ParseIntervals("1m,5m")
+
+	start := time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+	expected := []engine.Candle{
+		{Symbol: "BTC-USD", Interval: "5m", Start: start, Open: 40, High: 40, Low: 40, Close: 40, Volume: 2, Notional: 80, Trades: 2},
+		{Symbol: "BTC-USD", Interval: "5m", Start: start.Add(5 * time.Minute), Open: 41, High: 44, Low: 41, Close: 44, Volume: 8, Notional: 340, Trades: 8},
+	}
+	assertCandles := func(name string) {
+		t.Helper()
+		minutes, err := store.LoadCandles(engine.CandleQuery{Symbol: "BTC-USD", Interval: "1m"})
+		if err != nil || len(minutes) != 5 {
+			t.Fatalf("tests - %s: wrong number of 1m candles. expected=%d, got=%d, %v", name, 5, len(minutes), err)
+		}
+		for i, c := range minutes {
+			if c.Open != 40+i || c.Volume != 2 || c.Trades != 2 {
+				t.Fatalf("tests - %s: wrong 1m candle %d. expected=%v, got=%+v", name, i, 40+i, c)
+			}
+		}
+		got, _ := store.LoadCandles(engine.CandleQuery{Symbol: "BTC-USD", Interval: "5m"})
+		if len(got) != len(expected) {
+			t.Fatalf("tests - %s: wrong number of 5m candles. expected=%d, got=%d", name, len(expected), len(got))
+		}
+		for i := range got {
+			if !got[i].Start.Equal(expected[i].Start) {
+				t.Fatalf("tests - %s: wrong 5m start. expected=%s, got=%s", name, expected[i].Start, got[i].Start)
+			}
+			got[i].Start = expected[i].Start
+			if got[i] != expected[i] {
+				t.Fatalf("tests - %s: wrong 5m candle. expected=%+v, got=%+v", name, expected[i], got[i])
+			}
+		}
+	}
+
+	if err := engine.NewCandleAggregator(store, intervals).Rebuild("BTC-USD", history, true); err != nil {
+		t.Fatalf("tests - full rebuild failed. expected=%v, got=%v", nil, err)
+	}
+	assertCandles("full rebuild")
+
+	// Catching up from the latest stored candles doesn't count trades twice.
+	if err := engine.NewCandleAggregator(store, intervals).Rebuild("BTC-USD", history, false); err != nil {
+		t.Fatalf("tests - catch up failed. expected=%v, got=%v", nil, err)
+	}
+	assertCandles("catch up")
+
+	latest, _ := store.LoadCandles(engine.CandleQuery{Symbol: "BTC-USD", Interval: "1m", To: start.Add(8 * time.Minute), Limit: 2})
+	if len(latest) != 2 || latest[0].Open != 42 || latest[1].Open != 43 {
+		t.Fatalf("tests - should load the latest candles before to, oldest first. expected=%v, got=%+v", "42, 43", latest)
+	}
+}
+
+func TestCandlesRebuildKeepsSweepOrder(t *testing.T) {
+	t.Setenv("TRADES", filepath.Join(t.TempDir(), "orderbook.db"))
+	db := InitSqlite()
+	defer db.Close()
+
+	ob := engine.NewOrderBook()
+	ob.SetIDGenerator(&engine.SequentialIDs{Namespace: uuid.New()})
+	ob.AddStorage(&SqliteStorage{Database: db, Symbol: "BTC-USD"})
+	start := time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+	ob.SetClock(engine.FixedClock(start))
+	for _, price := range []int{50, 51, 52} {
+		ob.ProcessOrder(engine.Sell, price, 1)
+	}
+	ob.ProcessOrder(engine.Buy, 52, 3)
+	ob.SetClock(engine.FixedClock(start.Add(time.Minute)))
+	ob.ProcessOrder(engine.Buy, 30, 1)
+	ob.ProcessOrder(engine.Buy, 29, 1)
+	ob.ProcessOrder(engine.Sell, 29, 2)
+
+	store := &SqliteCandleStore{Database: db}
+	intervals, _ := engine.ParseIntervals("1m")
+	if err := engine.NewCandleAggregator(store, intervals).Rebuild("BTC-USD", &SqliteTradeHistory{Database: db}, true); err != nil {
+		t.Fatalf("tests - rebuild failed. expected=%v, got=%v", nil, err)
+	}
+
+	candles, _ := store.LoadCandles(engine.CandleQuery{Symbol: "BTC-USD", Interval: "1m"})
+	if len(candles) != 2 {
+		t.Fatalf("tests - wrong number of candles. expected=%d, got=%d", 2, len(candles))
+	}
+	if c := candles[0]; c.Open != 50 || c.Close != 52 {
+		t.Fatalf("tests - buy sweep should open low and close high. expected=%v, got=%+v", "50 to 52", c)
+	}
+	if c := candles[1]; c.Open != 30 || c.Close != 29 {
+		t.Fatalf("tests - sell sweep should open high and close low. expected=%v, got=%+v", "30 to 29", c)
+	}
+}
@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"limit-order-book/engine"
+)
+
+// FileJournal keeps the command journal of one symbol in a file, one JSON
+// entry per line. Every entry is synced to disk before Append returns.
+type FileJournal struct {
+	Path string
+	file *os.File
+}
+
+func (j *FileJournal) Append(entry *engine.JournalEntry) error {
+	if j.file == nil {
+		file, err := os.OpenFile(j.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return err
+		}
+		j.file = file
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := j.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return j.file.Sync()
+}
+
+// Entries reads the whole journal. A last line without a newline is a
+// write cut short by a crash; it was never acknowledged, so it is dropped
+// and cut off the file before anything is appended after it.
+func (j *FileJournal) Entries() ([]*engine.JournalEntry, error) {
+	file, err := os.Open(j.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []*engine.JournalEntry
+	var offset int64
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				Logger.Printf("Dropping torn entry at the end of %s", j.Path)
+				if err := os.Truncate(j.Path, offset); err != nil {
+					return nil, err
+				}
+			}
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		offset += int64(len(line))
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		var entry engine.JournalEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return nil, fmt.Errorf("%s at byte %d: %w", j.Path, offset-int64(len(line)), err)
+		}
+		entries = append(entries, &entry)
+	}
+}
+
+func (j *FileJournal) Close() error {
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
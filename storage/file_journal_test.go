@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"limit-order-book/engine"
+)
+
+func TestMain(m *testing.M) {
+	Logger = log.New(io.Discard, "", 0)
+	engine.Logger = Logger
+	os.Exit(m.Run())
+}
+
+func TestFileJournalDropsTornEntry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "BTC-USD.journal")
+	journal := &FileJournal{Path: path}
+
+	ob := engine.NewOrderBook()
+	ob.AddJournal(journal)
+	ob.ProcessOrder(engine.Buy, 40, 2)
+	ob.ProcessOrder(engine.Sell, 40, 1)
+	journal.Close()
+
+	file, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	file.WriteString(`{"seq":3,"type":"pla`)
+	file.Close()
+
+	journal = &FileJournal{Path: path}
+	entries, err := journal.Entries()
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("tests - torn entry should be dropped. expected=%d, got=%d (%v)", 2, len(entries), err)
+	}
+
+	replayed := engine.NewOrderBook()
+	replayed.AddJournal(journal)
+	replayed.RestoreOrderBook()
+	replayed.ProcessOrder(engine.Buy, 41, 1)
+	journal.Close()
+
+	entries, err = (&FileJournal{Path: path}).Entries()
+	if err != nil || len(entries) != 3 || entries[2].Seq != 3 {
+		t.Fatalf("tests - new entries should follow the last whole one. expected=%d, got=%d (%v)", 3, len(entries), err)
+	}
+}
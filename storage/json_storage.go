@@ -3,142 +3,645 @@ package storage
 import (
 	"limit-order-book/engine"
 
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
 	"github.com/google/uuid"
 )
 
-type JsonStorage struct {}
+// JsonSync says when JsonStorage syncs its log to disk.
+type JsonSync int
 
-func (j *JsonStorage) InsertLevel(side engine.Side, l *engine.LevelDTO) error {
-	dto, err := j.getDTO()
-	if err != nil {
-		return err
+const (
+	// SyncEveryCommit syncs before a commit returns, so committed
+	// commands survive a power cut.
+	SyncEveryCommit JsonSync = iota
+	// SyncPeriodic syncs on the first commit after SyncInterval has
+	// passed. A power cut can lose what was committed since.
+	SyncPeriodic
+	// SyncNever leaves it to the OS. A crash of the process loses
+	// nothing, a power cut can.
+	SyncNever
+)
+
+const defaultCompactAfter = 10000
+
+// JsonStorage keeps the book of one symbol in its own file, as a log of
+// JSON lines. Each line holds the mutations of one unit of work, so a
+// crash keeps all of a command or none of it. Once CompactAfter lines
+// have been appended, or after a reset, the log is rewritten as a single
+// line holding the whole book, renamed over the old one.
+//
+// Trades are also appended, one per line, to a trade file next to the log
+// once their unit of work is in the log. Compaction leaves them there and
+// only notes how many there are, so it never rewrites the trade history.
+// Trades the log holds but the trade file lost in a crash are appended
+// again when the log is replayed.
+type JsonStorage struct {
+	Symbol       string
+	Sync         JsonSync
+	SyncInterval time.Duration
+	// CompactAfter is 10000 if not set.
+	CompactAfter int
+	// Writer marks the storage of the process that appends to the log.
+	// Its RestoreOrderBook repairs what a crash left behind; anyone
+	// else's only reads, since to them a torn line may be a write in
+	// progress.
+	Writer bool
+
+	file       *os.File
+	tradeFile  *os.File
+	book       *engine.OrderBookDTO // what the log adds up to, with every trade
+	lines      int
+	tradeLines int // trades in the trade file
+	lastSync   time.Time
+
+	seq     uint64
+	open    bool
+	pending []engine.Mutation
+}
+
+// jsonRecord is one line of the log: the whole book, written by
+// compaction, or the mutations of one unit of work. A compacted book
+// holds no trades; Trades says how many the trade file held then.
+type jsonRecord struct {
+	Book      *engine.OrderBookDTO `json:"book,omitempty"`
+	Trades    int                  `json:"trades,omitempty"`
+	Mutations []engine.Mutation    `json:"mutations,omitempty"`
+}
+
+func (j *JsonStorage) Begin(seq uint64) error {
+	if j.open {
+		return errors.New("unit of work already open")
 	}
-	dto.Levels[side][l.Price] = l
-	return j.WriteDTOToJson(dto)
+	j.seq, j.open, j.pending = seq, true, nil
+	return nil
 }
 
-func (j *JsonStorage) InsertTrade(t *engine.Trade) error {
-	dto, err := j.getDTO()
-	if err != nil {
-		return err
+func (j *JsonStorage) Commit() error {
+	mutations := j.pending
+	j.open, j.pending = false, nil
+	return j.append(mutations)
+}
+
+func (j *JsonStorage) Rollback() error {
+	j.open, j.pending = false, nil
+	return nil
+}
+
+// write adds m to the open unit of work, or appends it on its own.
+func (j *JsonStorage) write(m engine.Mutation) error {
+	m.Seq = j.seq
+	if j.open {
+		j.pending = append(j.pending, m)
+		return nil
 	}
-	dto.Trades = append(dto.Trades, *t)
-	return j.WriteDTOToJson(dto)
+	return j.append([]engine.Mutation{m})
+}
+
+func (j *JsonStorage) ResetOrderBook() error {
+	return j.write(engine.Mutation{Op: engine.OpReset})
+}
+
+func (j *JsonStorage) InsertLevel(side engine.Side, l *engine.LevelDTO) error {
+	return j.write(engine.Mutation{Op: engine.OpInsertLevel, Side: side, Level: l})
+}
+
+func (j *JsonStorage) InsertTrade(t *engine.Trade) error {
+	trade := *t
+	return j.write(engine.Mutation{Op: engine.OpInsertTrade, Trade: &trade})
 }
 
 func (j *JsonStorage) InsertOrder(o *engine.OrderDTO) error {
-	dto, err := j.getDTO()
+	return j.write(engine.Mutation{Op: engine.OpInsertOrder, Order: o})
+}
+
+func (j *JsonStorage) DeleteOrder(o *engine.OrderDTO) error {
+	return j.write(engine.Mutation{Op: engine.OpDeleteOrder, Order: o})
+}
+
+func (j *JsonStorage) UpdateOrder(o *engine.OrderDTO) error {
+	return j.write(engine.Mutation{Op: engine.OpUpdateOrder, Order: o})
+}
+
+func (j *JsonStorage) InsertStopOrder(o *engine.OrderDTO) error {
+	return j.write(engine.Mutation{Op: engine.OpInsertStopOrder, Order: o})
+}
+
+func (j *JsonStorage) DeleteStopOrder(o *engine.OrderDTO) error {
+	return j.write(engine.Mutation{Op: engine.OpDeleteStopOrder, Order: o})
+}
+
+// RestoreOrderBook replays the log from disk, so it also sees what other
+// JsonStorages of the symbol wrote. Unless j is the Writer it leaves the
+// files as they are; the writer repairs them at the latest when it opens
+// the log to append.
+func (j *JsonStorage) RestoreOrderBook() (*engine.OrderBook, error) {
+	book, _, _, err := j.replay(j.Writer)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	dto.Orders[o.Id] = o
+	return book.ToOrderBook(), nil
+}
 
-	return j.WriteDTOToJson(dto)
+// WriteBatch appends the mutations of many commands as one record.
+func (j *JsonStorage) WriteBatch(mutations []engine.Mutation) error {
+	return j.append(mutations)
 }
 
-func (j *JsonStorage) DeleteOrder(ob *engine.OrderBookDTO, o *engine.OrderDTO) error {
-	dto, err := j.getDTO()
+// append writes one unit of work to the log, then applies it to the book.
+func (j *JsonStorage) append(mutations []engine.Mutation) error {
+	if len(mutations) == 0 {
+		return nil
+	}
+	if err := j.load(); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(jsonRecord{Mutations: mutations})
 	if err != nil {
 		return err
 	}
+	if _, err := j.file.Write(append(data, '\n')); err != nil {
+		j.close()
+		return err
+	}
+	if err := j.sync(); err != nil {
+		j.close()
+		return err
+	}
+
+	reset := false
+	for _, m := range mutations {
+		applyJsonMutation(j.book, m)
+		reset = reset || m.Op == engine.OpReset
+	}
+	j.lines++
+
+	if err := j.appendTrades(reset); err != nil {
+		j.close()
+		return err
+	}
+
+	// A reset makes everything before it moot.
+	if mutations[0].Op == engine.OpReset || j.lines >= j.compactAfter() {
+		if err := j.compact(); err != nil {
+			Logger.Printf("Failed to compact %s, still appending: %s", j.getFilename(), err)
+		}
+	}
+	return nil
+}
+
+func (j *JsonStorage) sync() error {
+	switch j.Sync {
+	case SyncNever:
+		return nil
+	case SyncPeriodic:
+		if time.Since(j.lastSync) < j.SyncInterval {
+			return nil
+		}
+	}
+	j.lastSync = time.Now()
+	return j.file.Sync()
+}
 
-	delete(dto.Orders, o.Id)
-	parentLevel := dto.Levels[o.Side][o.Price]
-	if parentLevel != nil {
-		parentLevel.Count--
-		if parentLevel.Count <= 0 {
-			delete(dto.Levels[o.Side], o.Price)
+// appendTrades copies the trades the log has and the trade file lacks to
+// it, after replacing it with an empty one on a reset. It is not synced:
+// until the log is compacted, replaying it restores what a crash lost.
+func (j *JsonStorage) appendTrades(reset bool) error {
+	if reset {
+		path := j.getTradesFilename()
+		if err := writeFileAtomic(path, nil); err != nil {
+			return err
+		}
+		j.tradeFile.Close()
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			j.tradeFile = nil
+			return err
 		}
+		j.tradeFile, j.tradeLines = file, 0
+	}
+	if j.tradeLines == len(j.book.Trades) {
+		return nil
 	}
 
-	return j.WriteDTOToJson(dto)
+	var buf bytes.Buffer
+	for _, t := range j.book.Trades[j.tradeLines:] {
+		data, err := json.Marshal(t)
+		if err != nil {
+			return err
+		}
+		buf.Write(append(data, '\n'))
+	}
+	if _, err := j.tradeFile.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	j.tradeLines = len(j.book.Trades)
+	return nil
 }
 
-func (j *JsonStorage) UpdateOrder(ob *engine.OrderBookDTO, o *engine.OrderDTO) error {
-	return j.DeleteOrder(ob, o)
+func (j *JsonStorage) compactAfter() int {
+	if j.CompactAfter <= 0 {
+		return defaultCompactAfter
+	}
+	return j.CompactAfter
 }
 
-func (j *JsonStorage) WriteDTOToJson(dto *engine.OrderBookDTO) error {
-	filename := j.getFilename()
-	data, err := json.MarshalIndent(dto, "", "  ")
+// load replays the log and opens it for appending, the first time it is
+// needed or after a failed write.
+func (j *JsonStorage) load() error {
+	if j.file != nil {
+		return nil
+	}
+
+	book, lines, legacy, err := j.replay(true)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(j.getFilename(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	tradeFile, err := os.OpenFile(j.getTradesFilename(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
 	if err != nil {
+		file.Close()
 		return err
 	}
-	return os.WriteFile(filename, data, 0644)
+	// Replaying brought the trade file in line with the log.
+	j.file, j.tradeFile, j.book, j.lines, j.tradeLines = file, tradeFile, book, lines, len(book.Trades)
+
+	if legacy {
+		Logger.Printf("Converting %s to a log", j.getFilename())
+		if err := j.compact(); err != nil {
+			// Appending to the old format would corrupt it.
+			j.close()
+			return err
+		}
+	}
+	return nil
+}
+
+// close drops the open log, so the next write replays it from disk.
+func (j *JsonStorage) close() {
+	if j.file != nil {
+		j.file.Close()
+	}
+	if j.tradeFile != nil {
+		j.tradeFile.Close()
+	}
+	j.file, j.tradeFile, j.book = nil, nil, nil
 }
 
-func (j *JsonStorage) getDTO() (*engine.OrderBookDTO, error) {
-	filename := j.getFilename()
-	data, err := os.ReadFile(filename)
+// compact replaces the log with one line holding the whole book but its
+// trades. The trade file is synced first, since the log will no longer
+// hold them, and the new log before it is renamed over the old one, so a
+// crash leaves one or the other.
+func (j *JsonStorage) compact() error {
+	if err := j.tradeFile.Sync(); err != nil {
+		return err
+	}
+	book := *j.book
+	book.Trades = nil
+	data, err := json.Marshal(jsonRecord{Book: &book, Trades: j.tradeLines})
 	if err != nil {
-		return nil, err
+		return err
+	}
+	path := j.getFilename()
+	if err := writeFileAtomic(path, append(data, '\n')); err != nil {
+		return err
 	}
 
-	var dto *engine.OrderBookDTO
-	err = json.Unmarshal(data, &dto)
+	j.file.Close()
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
 	if err != nil {
-		return &engine.OrderBookDTO{
-			Levels: map[engine.Side]map[int]*engine.LevelDTO{engine.Buy: {}, engine.Sell: {}},
-			Orders: make(map[uuid.UUID]*engine.OrderDTO),
-			Trades: []engine.Trade{},
-		}, nil
+		j.file = nil
+		j.close()
+		return err
 	}
-	return dto, nil
+	j.file, j.lines = file, 0
+	return nil
 }
 
-func (j *JsonStorage) getFilename() string {
-	orderBookFile := os.Getenv("ORDERBOOK")
-	if orderBookFile == "" {
-		orderBookFile = "/tmp/orderbook.json"
+// replay reads the log and the trade file into a book. A last line
+// without a newline is a write cut short by a crash; it was never
+// acknowledged, so it is dropped, and with repair cut off the file. Only
+// the writer may repair: to anyone else the line may be a write in
+// progress. With repair the trade file is also made to hold exactly the
+// book's trades. legacy is set for a file holding a bare book, the format
+// before the log.
+func (j *JsonStorage) replay(repair bool) (book *engine.OrderBookDTO, lines int, legacy bool, err error) {
+	book, stored, lines, legacy, err := j.replayLog(repair)
+	if err != nil {
+		return nil, 0, false, err
 	}
-	return orderBookFile
+	if book.Trades, err = j.replayTrades(stored, book.Trades, repair); err != nil {
+		return nil, 0, false, err
+	}
+	return book, lines, legacy, nil
 }
 
+// replayLog reads the log into a book holding only the trades logged
+// since it was last compacted. stored is how many came before those, in
+// the trade file.
+func (j *JsonStorage) replayLog(repair bool) (book *engine.OrderBookDTO, stored int, lines int, legacy bool, err error) {
+	path := j.getFilename()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return emptyDTO(), 0, 0, false, nil
+	}
+	if err != nil {
+		return nil, 0, 0, false, err
+	}
 
-func (j *JsonStorage) ResetOrderBook() error {
-	filename := j.getFilename()
-	Logger.Printf("Wiping orders from %s\n", filename)
-	err := os.WriteFile(filename, []byte("[]"), 0644)
+	if book, ok := legacyBook(data); ok {
+		return book, 0, 0, true, nil
+	}
+
+	book = emptyDTO()
+	var offset int
+	for len(data) > 0 {
+		end := bytes.IndexByte(data, '\n')
+		if end < 0 {
+			if !repair {
+				break
+			}
+			Logger.Printf("Dropping torn record at the end of %s", path)
+			if err := os.Truncate(path, int64(offset)); err != nil {
+				return nil, 0, 0, false, err
+			}
+			break
+		}
+		line := data[:end]
+		data = data[end+1:]
+		offset += end + 1
+
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var record jsonRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return nil, 0, 0, false, fmt.Errorf("%s at byte %d: %w", path, offset-end-1, err)
+		}
+		if record.Book != nil {
+			// A book compacted before the trade file holds its trades.
+			book, stored = normalizeDTO(record.Book), record.Trades
+		}
+		for _, m := range record.Mutations {
+			applyJsonMutation(book, m)
+			if m.Op == engine.OpReset {
+				stored = 0
+			}
+		}
+		lines++
+	}
+	return book, stored, lines, false, nil
+}
 
+// replayTrades returns the first stored trades of the trade file followed
+// by logged, the trades the log holds. Past the stored ones the file
+// holds those of logged it got before a crash, and maybe trades from
+// before a reset or a torn line; with repair they are cut off and the
+// rest of logged appended.
+func (j *JsonStorage) replayTrades(stored int, logged []engine.Trade, repair bool) ([]engine.Trade, error) {
+	path := j.getTradesFilename()
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	trades, ends, err := readJsonTrades(data)
 	if err != nil {
-		Logger.Printf("Failed to reset OrderBook: %s", err)
-		return err
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(trades) < stored {
+		return nil, fmt.Errorf("%s holds %d trades, %s expects %d", path, len(trades), j.getFilename(), stored)
 	}
 
-	return nil
+	kept := stored
+	for kept < len(trades) && kept-stored < len(logged) && trades[kept].ID == logged[kept-stored].ID {
+		kept++
+	}
+	size := 0
+	if kept > 0 {
+		size = ends[kept-1]
+	}
+	missing := logged[kept-stored:]
+	trades = append(trades[:stored], logged...)
+
+	if repair && (size < len(data) || len(missing) > 0) {
+		if err := repairJsonTrades(path, data[:size], missing); err != nil {
+			return nil, err
+		}
+	}
+	return trades, nil
 }
 
-func (j *JsonStorage) RestoreOrderBook() (*engine.OrderBook, error) {
-	filename := j.getFilename()
-	data, err := os.ReadFile(filename)
-	if err != nil {
-		_, err := os.Create(filename)
+// readJsonTrades reads every whole line of a trade file, with the offset
+// each ends at.
+func readJsonTrades(data []byte) (trades []engine.Trade, ends []int, err error) {
+	offset := 0
+	for {
+		end := bytes.IndexByte(data[offset:], '\n')
+		if end < 0 {
+			return trades, ends, nil
+		}
+		var t engine.Trade
+		if err := json.Unmarshal(data[offset:offset+end], &t); err != nil {
+			return nil, nil, fmt.Errorf("at byte %d: %w", offset, err)
+		}
+		offset += end + 1
+		trades = append(trades, t)
+		ends = append(ends, offset)
+	}
+}
+
+// repairJsonTrades replaces the trade file with its kept lines followed by
+// trades. It is renamed over the old one rather than cut and appended to
+// in place, so a JsonTradeHistory that read the old file sees it is gone.
+func repairJsonTrades(path string, kept []byte, trades []engine.Trade) error {
+	buf := bytes.NewBuffer(kept[:len(kept):len(kept)])
+	for _, t := range trades {
+		data, err := json.Marshal(t)
 		if err != nil {
-			Logger.Fatal(err)
+			return err
 		}
+		buf.Write(append(data, '\n'))
 	}
-	data, err = os.ReadFile(filename)
+	return writeFileAtomic(path, buf.Bytes())
+}
 
-	var dto engine.OrderBookDTO
-	err = json.Unmarshal(data, &dto)
-	if err != nil {
-		return nil, err
+// legacyBook reads a file written before the log: one indented book, or
+// "[]" after a reset.
+func legacyBook(data []byte) (*engine.OrderBookDTO, bool) {
+	data = bytes.TrimSpace(data)
+	if string(data) == "[]" {
+		return emptyDTO(), true
 	}
-	restoredBook := dto.ToOrderBook()
 
-	return restoredBook, nil
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(data, &fields) != nil {
+		return nil, false
+	}
+	if _, ok := fields["levels"]; !ok {
+		return nil, false
+	}
+	var book engine.OrderBookDTO
+	if json.Unmarshal(data, &book) != nil {
+		return nil, false
+	}
+	return normalizeDTO(&book), true
 }
 
-func (j *JsonStorage) DumpOrderBook(ob *engine.OrderBook) error {
-	filename := j.getFilename()
+// applyJsonMutation makes m on book the way PostgresStorage makes it on
+// its tables, so both restore the same book.
+func applyJsonMutation(book *engine.OrderBookDTO, m engine.Mutation) {
+	o := m.Order
+	switch m.Op {
+	case engine.OpReset:
+		*book = *emptyDTO()
 
-	dto := ob.ToDTO()
-	data, err := json.MarshalIndent(dto, "", "  ")
-	if err != nil {
-		return err
+	case engine.OpInsertLevel:
+		book.Levels[m.Side][m.Level.Price] = &engine.LevelDTO{Price: m.Level.Price, Orders: []uuid.UUID{}}
+
+	case engine.OpInsertOrder:
+		order := *o
+		book.Orders[o.Id] = &order
+		if o.PrevID != nil {
+			if prev := book.Orders[*o.PrevID]; prev != nil {
+				prev.NextID = &order.Id
+			}
+		}
+		if level := book.Levels[o.Side][o.Price]; level != nil {
+			level.Orders = append(level.Orders, o.Id)
+			level.Count++
+			level.Volume += o.Remaining
+		}
+
+	case engine.OpDeleteOrder:
+		if level := book.Levels[o.Side][o.Price]; level != nil {
+			level.Orders = slices.DeleteFunc(level.Orders, func(id uuid.UUID) bool { return id == o.Id })
+			level.Count--
+			level.Volume -= o.Remaining
+		}
+		if o.NextID != nil {
+			if next := book.Orders[*o.NextID]; next != nil {
+				next.PrevID = o.PrevID
+			}
+		}
+		if o.PrevID != nil {
+			if prev := book.Orders[*o.PrevID]; prev != nil {
+				prev.NextID = o.NextID
+			}
+		}
+		delete(book.Orders, o.Id)
+		deleteEmptyJsonLevel(book, o.Side, o.Price)
+
+	case engine.OpUpdateOrder:
+		order := book.Orders[o.Id]
+		if order == nil {
+			return
+		}
+		if level := book.Levels[o.Side][o.Price]; level != nil {
+			level.Volume += o.Remaining - order.Remaining
+		}
+		order.Remaining, order.Size, order.Hidden = o.Remaining, o.Size, o.Hidden
+		if o.Remaining <= 0 {
+			if level := book.Levels[o.Side][o.Price]; level != nil {
+				level.Orders = slices.DeleteFunc(level.Orders, func(id uuid.UUID) bool { return id == o.Id })
+			}
+			deleteEmptyJsonLevel(book, o.Side, o.Price)
+		}
+
+	case engine.OpInsertTrade:
+		trade := *m.Trade
+		if trade.Seq == 0 {
+			trade.Seq = nextTradeSeq(book.Trades)
+		}
+		book.Trades = append(book.Trades, trade)
+
+	case engine.OpInsertStopOrder:
+		stop := *o
+		stop.Remaining = stop.Size
+		book.Stops[o.Id] = &stop
+
+	case engine.OpDeleteStopOrder:
+		delete(book.Stops, o.Id)
+	}
+}
+
+// deleteEmptyJsonLevel drops a level once its last order has left it.
+func deleteEmptyJsonLevel(book *engine.OrderBookDTO, side engine.Side, price int) {
+	if level := book.Levels[side][price]; level != nil && len(level.Orders) == 0 {
+		delete(book.Levels[side], price)
+	}
+}
+
+func emptyDTO() *engine.OrderBookDTO {
+	return &engine.OrderBookDTO{
+		Levels: map[engine.Side]map[int]*engine.LevelDTO{engine.Buy: {}, engine.Sell: {}},
+		Orders: make(map[uuid.UUID]*engine.OrderDTO),
+		Stops:  make(map[uuid.UUID]*engine.OrderDTO),
+		Trades: []engine.Trade{},
 	}
+}
+
+// normalizeDTO fills in what JSON leaves out of an empty book.
+func normalizeDTO(book *engine.OrderBookDTO) *engine.OrderBookDTO {
+	empty := emptyDTO()
+	if book.Levels == nil {
+		book.Levels = empty.Levels
+	}
+	for _, side := range []engine.Side{engine.Buy, engine.Sell} {
+		if book.Levels[side] == nil {
+			book.Levels[side] = map[int]*engine.LevelDTO{}
+		}
+	}
+	if book.Orders == nil {
+		book.Orders = empty.Orders
+	}
+	if book.Stops == nil {
+		book.Stops = empty.Stops
+	}
+	if book.Trades == nil {
+		book.Trades = empty.Trades
+	}
+	for i := range book.Trades {
+		if book.Trades[i].Seq == 0 {
+			book.Trades[i].Seq = nextTradeSeq(book.Trades[:i])
+		}
+	}
+	return book
+}
+
+// nextTradeSeq numbers a trade logged before trades had a seq: the one
+// after the trade before it.
+func nextTradeSeq(trades []engine.Trade) uint64 {
+	if len(trades) == 0 {
+		return 1
+	}
+	return trades[len(trades)-1].Seq + 1
+}
+
+func (j *JsonStorage) getFilename() string {
+	orderBookFile := os.Getenv("ORDERBOOK")
+	if orderBookFile == "" {
+		orderBookFile = "/tmp/orderbook.json"
+	}
+	if j.Symbol != "" {
+		ext := filepath.Ext(orderBookFile)
+		orderBookFile = strings.TrimSuffix(orderBookFile, ext) + "-" + j.Symbol + ext
+	}
+	return orderBookFile
+}
 
-	return os.WriteFile(filename, data, 0644)
+// getTradesFilename names the trade file, next to the log.
+func (j *JsonStorage) getTradesFilename() string {
+	return j.getFilename() + ".trades"
 }
@@ -0,0 +1,237 @@
+package storage
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"limit-order-book/engine"
+
+	"github.com/google/uuid"
+)
+
+// jsonTestBook trades a little of everything against storage: partial
+// fills, an iceberg, a stop, a cancel and an amend. The same storage gets
+// the same ids and times on every run.
+func jsonTestBook(storage engine.Storage) *engine.OrderBook {
+	ob := engine.NewOrderBook()
+	ob.SetIDGenerator(&engine.SequentialIDs{})
+	ob.SetClock(engine.FixedClock(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)))
+	ob.AddStorage(storage)
+	ob.ProcessOrder(engine.Buy, 40, 5)
+	ob.ProcessOrder(engine.Buy, 40, 3)
+	ob.ProcessOrder(engine.Buy, 39, 2)
+	ob.ProcessOrder(engine.Sell, 40, 6)
+	ob.PlaceOrder(engine.OrderRequest{Side: engine.Sell, Type: engine.Limit, Price: 42, Size: 10, DisplaySize: 4})
+	ob.ProcessOrder(engine.Buy, 42, 5)
+	ob.PlaceOrder(engine.OrderRequest{Side: engine.Sell, Type: engine.StopLimit, StopPrice: 38, Price: 37, Size: 1})
+	id, _ := ob.ProcessOrder(engine.Sell, 45, 1)
+	ob.CancelOrder(id)
+	id, _ = ob.ProcessOrder(engine.Buy, 38, 4)
+	ob.AmendOrder(id, 38, 2)
+	return ob
+}
+
+func assertRestores(t *testing.T, storage engine.Storage, ob *engine.OrderBook) {
+	t.Helper()
+	restored, err := storage.RestoreOrderBook()
+	if err != nil {
+		t.Fatalf("tests - restore failed. expected=%v, got=%v", nil, err)
+	}
+	if diffs := engine.DiffBooks(ob.ToDTO(), restored.ToDTO()); len(diffs) != 0 {
+		t.Fatalf("tests - restored book should match. expected=%v, got=%v", nil, diffs)
+	}
+	if problems := restored.CheckInvariants(); len(problems) != 0 {
+		t.Fatalf("tests - restored book should be sound. expected=%v, got=%v", nil, problems)
+	}
+}
+
+func TestJsonStorageRestoresWhatSqliteDoes(t *testing.T) {
+	t.Setenv("ORDERBOOK", filepath.Join(t.TempDir(), "orderbook.json"))
+	t.Setenv("TRADES", filepath.Join(t.TempDir(), "orderbook.db"))
+	db := InitSqlite()
+	defer db.Close()
+
+	ob := jsonTestBook(&JsonStorage{Symbol: "BTC-USD"})
+	jsonTestBook(&SqliteStorage{Database: db, Symbol: "BTC-USD"})
+	assertRestores(t, &JsonStorage{Symbol: "BTC-USD"}, ob)
+
+	fromJson, _ := (&JsonStorage{Symbol: "BTC-USD"}).RestoreOrderBook()
+	fromSqlite, _ := (&SqliteStorage{Database: db, Symbol: "BTC-USD"}).RestoreOrderBook()
+	expected, _ := json.Marshal(fromSqlite.ToDTO())
+	got, _ := json.Marshal(fromJson.ToDTO())
+	if string(expected) != string(got) {
+		t.Fatalf("tests - json should restore what sqlite does. expected=%s, got=%s", expected, got)
+	}
+}
+
+func TestJsonStorageCompactsAndResets(t *testing.T) {
+	t.Setenv("ORDERBOOK", filepath.Join(t.TempDir(), "orderbook.json"))
+	storage := &JsonStorage{Symbol: "BTC-USD", Sync: SyncNever, CompactAfter: 3}
+
+	ob := jsonTestBook(storage)
+	data, _ := os.ReadFile(storage.getFilename())
+	if lines := strings.Count(string(data), "\n"); lines > 3 {
+		t.Fatalf("tests - log should have been compacted. expected=<=%d, got=%d", 3, lines)
+	}
+	assertRestores(t, &JsonStorage{Symbol: "BTC-USD"}, ob)
+
+	if err := ob.ResetOrderBook(); err != nil {
+		t.Fatalf("tests - reset failed. expected=%v, got=%v", nil, err)
+	}
+	data, _ = os.ReadFile(storage.getFilename())
+	if lines := strings.Count(string(data), "\n"); lines != 1 {
+		t.Fatalf("tests - reset should leave one line. expected=%d, got=%d", 1, lines)
+	}
+	ob.ProcessOrder(engine.Buy, 40, 1)
+	assertRestores(t, &JsonStorage{Symbol: "BTC-USD"}, ob)
+}
+
+func TestJsonStorageDropsTornRecord(t *testing.T) {
+	t.Setenv("ORDERBOOK", filepath.Join(t.TempDir(), "orderbook.json"))
+	storage := &JsonStorage{Symbol: "BTC-USD"}
+	ob := jsonTestBook(storage)
+
+	// A crash in the middle of a write leaves half a line.
+	file, _ := os.OpenFile(storage.getFilename(), os.O_WRONLY|os.O_APPEND, 0644)
+	file.WriteString(`{"mutations":[{"seq":99,"op":`)
+	file.Close()
+
+	restarted := &JsonStorage{Symbol: "BTC-USD"}
+	assertRestores(t, restarted, ob)
+	// To a reader it may be a write in progress.
+	if data, _ := os.ReadFile(storage.getFilename()); !strings.HasSuffix(string(data), `"op":`) {
+		t.Fatalf("tests - restoring should not cut the log. expected=%q at the end, got=%q", `"op":`, data)
+	}
+	ob.AddStorage(restarted)
+	ob.ProcessOrder(engine.Sell, 50, 1)
+	assertRestores(t, &JsonStorage{Symbol: "BTC-USD"}, ob)
+}
+
+func TestJsonStorageConvertsLegacyFile(t *testing.T) {
+	t.Setenv("ORDERBOOK", filepath.Join(t.TempDir(), "orderbook.json"))
+	ob := jsonTestBook(&engine.NilStorage{})
+
+	// Before the log, the file held the whole book, indented.
+	storage := &JsonStorage{Symbol: "BTC-USD"}
+	data, _ := json.MarshalIndent(ob.ToDTO(), "", "  ")
+	os.WriteFile(storage.getFilename(), data, 0644)
+	assertRestores(t, storage, ob)
+
+	ob.AddStorage(storage)
+	ob.ProcessOrder(engine.Sell, 50, 1)
+	assertRestores(t, &JsonStorage{Symbol: "BTC-USD"}, ob)
+}
+
+func TestJsonStorageCompactsWithoutRewritingTrades(t *testing.T) {
+	t.Setenv("ORDERBOOK", filepath.Join(t.TempDir(), "orderbook.json"))
+	storage := &JsonStorage{Symbol: "BTC-USD", Sync: SyncNever, CompactAfter: 3}
+
+	ob := jsonTestBook(storage)
+	before, _ := os.ReadFile(storage.getTradesFilename())
+	if lines := strings.Count(string(before), "\n"); lines != len(ob.ToDTO().Trades) {
+		t.Fatalf("tests - trade file should hold every trade. expected=%d, got=%d", len(ob.ToDTO().Trades), lines)
+	}
+	log, _ := os.ReadFile(storage.getFilename())
+	var record jsonRecord
+	json.Unmarshal([]byte(strings.SplitN(string(log), "\n", 2)[0]), &record)
+	if record.Book == nil || len(record.Book.Trades) != 0 || record.Trades == 0 {
+		t.Fatalf("tests - compacted log should count trades, not hold them. expected=%v, got=%d in book, %d counted", 0, len(record.Book.Trades), record.Trades)
+	}
+
+	for price := 37; price > 33; price-- {
+		ob.ProcessOrder(engine.Buy, price, 1)
+		ob.ProcessOrder(engine.Sell, price, 1)
+	}
+	after, _ := os.ReadFile(storage.getTradesFilename())
+	if !strings.HasPrefix(string(after), string(before)) || len(after) == len(before) {
+		t.Fatalf("tests - trades should only be appended. expected=%q..., got=%q", before, after)
+	}
+	assertRestores(t, &JsonStorage{Symbol: "BTC-USD"}, ob)
+}
+
+func TestJsonStorageRepairsTradeFile(t *testing.T) {
+	t.Setenv("ORDERBOOK", filepath.Join(t.TempDir(), "orderbook.json"))
+	storage := &JsonStorage{Symbol: "BTC-USD"}
+	ob := jsonTestBook(storage)
+
+	// Trades not yet synced are lost in a crash, and one is torn, but the
+	// log still holds them.
+	data, _ := os.ReadFile(storage.getTradesFilename())
+	first := strings.Index(string(data), "\n") + 1
+	torn := append(data[:first:first], `{"id":`...)
+	os.WriteFile(storage.getTradesFilename(), torn, 0644)
+
+	restarted := &JsonStorage{Symbol: "BTC-USD"}
+	assertRestores(t, restarted, ob)
+	if read, _ := os.ReadFile(storage.getTradesFilename()); string(read) != string(torn) {
+		t.Fatalf("tests - restoring should leave the trade file alone. expected=%q, got=%q", torn, read)
+	}
+
+	assertRestores(t, &JsonStorage{Symbol: "BTC-USD", Writer: true}, ob)
+	repaired, _ := os.ReadFile(storage.getTradesFilename())
+	if string(repaired) != string(data) {
+		t.Fatalf("tests - writer should repair the trade file from the log. expected=%q, got=%q", data, repaired)
+	}
+}
+
+func TestJsonTradeHistoryFollowsTradeFile(t *testing.T) {
+	t.Setenv("ORDERBOOK", filepath.Join(t.TempDir(), "orderbook.json"))
+	storage := &JsonStorage{Symbol: "BTC-USD", Sync: SyncNever}
+	ob := jsonTestBook(storage)
+	history := &JsonTradeHistory{}
+	q := engine.TradeQuery{Symbol: "BTC-USD", Limit: 100}
+
+	trades, _ := history.QueryTrades(q)
+	if len(trades) != len(ob.ToDTO().Trades) {
+		t.Fatalf("tests - history should hold every trade. expected=%d, got=%d", len(ob.ToDTO().Trades), len(trades))
+	}
+
+	ob.ProcessOrder(engine.Sell, 30, 1)
+	made := ob.ToDTO().Trades
+	trades, _ = history.QueryTrades(q)
+	if len(trades) != len(made) || trades[len(trades)-1].ID != made[len(made)-1].ID {
+		t.Fatalf("tests - history should read the trades appended since. expected=%d, got=%d", len(made), len(trades))
+	}
+
+	ob.ResetOrderBook()
+	ob.ProcessOrder(engine.Buy, 40, 1)
+	ob.ProcessOrder(engine.Sell, 40, 1)
+	trades, _ = history.QueryTrades(q)
+	if len(trades) != 1 || trades[0].Seq != 1 {
+		t.Fatalf("tests - history should start over after a reset. expected=%d, got=%+v", 1, trades)
+	}
+}
+
+func TestJsonTradeHistorySeesRepairedTradeFile(t *testing.T) {
+	t.Setenv("ORDERBOOK", filepath.Join(t.TempDir(), "orderbook.json"))
+	storage := &JsonStorage{Symbol: "BTC-USD", Sync: SyncNever}
+	ob := jsonTestBook(storage)
+	made := ob.ToDTO().Trades
+
+	// The trade file lost all but the first trade, and holds one the log
+	// doesn't, such as from before a reset.
+	first, _ := json.Marshal(made[0])
+	stale, _ := json.Marshal(engine.Trade{ID: uuid.New(), Seq: 2, Price: 41, Size: 1})
+	os.WriteFile(storage.getTradesFilename(), []byte(string(first)+"\n"+string(stale)+"\n"), 0644)
+	history := &JsonTradeHistory{}
+	q := engine.TradeQuery{Symbol: "BTC-USD", Limit: 100}
+	if trades, _ := history.QueryTrades(q); len(trades) != 2 {
+		t.Fatalf("tests - history should read the file as it is. expected=%d, got=%d", 2, len(trades))
+	}
+
+	// The repaired file is longer than what was read of the old one.
+	assertRestores(t, &JsonStorage{Symbol: "BTC-USD", Writer: true}, ob)
+	trades, _ := history.QueryTrades(q)
+	if len(trades) != len(made) {
+		t.Fatalf("tests - history should reread the repaired file. expected=%d, got=%d", len(made), len(trades))
+	}
+	for i := range made {
+		if trades[i].ID != made[i].ID {
+			t.Fatalf("tests - history should hold the logged trades. expected=%v, got=%v", made[i].ID, trades[i].ID)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Migrations live in migrations/<dialect>/ as NNNN_name.up.sql and
+// NNNN_name.down.sql. Applied versions are recorded in schema_migrations,
+// each in the same transaction as the migration itself.
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+var migrationName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus is a migration and when it was applied; Applied is zero
+// while it is pending.
+type MigrationStatus struct {
+	Migration
+	Applied time.Time
+}
+
+// migrationDB is what the Migrator needs from a database.
+type migrationDB interface {
+	createTable() error
+	applied() (map[int]time.Time, error)
+	// apply runs script and records version as applied, or as not
+	// applied if up is false, in one transaction.
+	apply(script string, version int, name string, up bool) error
+}
+
+type Migrator struct {
+	db         migrationDB
+	migrations []Migration
+}
+
+func NewPostgresMigrator(db *PostgresDB) (*Migrator, error) {
+	return newMigrator(&pgMigrationDB{db}, "postgres")
+}
+
+func NewSqliteMigrator(db *sql.DB) (*Migrator, error) {
+	return newMigrator(&sqliteMigrationDB{db}, "sqlite")
+}
+
+func newMigrator(db migrationDB, dialect string) (*Migrator, error) {
+	migrations, err := loadMigrations(path.Join("migrations", dialect))
+	if err != nil {
+		return nil, err
+	}
+	if err := db.createTable(); err != nil {
+		return nil, fmt.Errorf("create schema_migrations: %w", err)
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+func loadMigrations(dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := migrationName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("bad migration file name %s", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		data, err := migrationFiles.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d is named both %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// migrateUp applies pending migrations on startup, or stops the program.
+func migrateUp(migrator *Migrator, err error) {
+	if err == nil {
+		var applied []Migration
+		applied, err = migrator.Up()
+		for _, m := range applied {
+			Logger.Printf("Applied migration %04d_%s", m.Version, m.Name)
+		}
+	}
+	if err != nil {
+		Logger.Fatalf("failed to migrate database: %s", err)
+	}
+}
+
+// Status lists every migration, oldest first.
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	applied, err := m.db.applied()
+	if err != nil {
+		return nil, err
+	}
+	status := make([]MigrationStatus, len(m.migrations))
+	for i, migration := range m.migrations {
+		status[i] = MigrationStatus{Migration: migration, Applied: applied[migration.Version]}
+	}
+	return status, nil
+}
+
+// Pending lists the migrations not applied yet, oldest first.
+func (m *Migrator) Pending() ([]Migration, error) {
+	status, err := m.Status()
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, s := range status {
+		if s.Applied.IsZero() {
+			pending = append(pending, s.Migration)
+		}
+	}
+	return pending, nil
+}
+
+// Up applies the pending migrations, oldest first, and returns those it
+// applied. It stops at the first that fails.
+func (m *Migrator) Up() ([]Migration, error) {
+	pending, err := m.Pending()
+	if err != nil {
+		return nil, err
+	}
+	for i, migration := range pending {
+		if err := m.db.apply(migration.Up, migration.Version, migration.Name, true); err != nil {
+			return pending[:i], fmt.Errorf("migration %04d_%s: %w", migration.Version, migration.Name, err)
+		}
+	}
+	return pending, nil
+}
+
+// Down reverts the last steps applied migrations, newest first, and
+// returns those it reverted.
+func (m *Migrator) Down(steps int) ([]Migration, error) {
+	status, err := m.Status()
+	if err != nil {
+		return nil, err
+	}
+	var reverted []Migration
+	for i := len(status) - 1; i >= 0 && len(reverted) < steps; i-- {
+		if status[i].Applied.IsZero() {
+			continue
+		}
+		migration := status[i].Migration
+		if err := m.db.apply(migration.Down, migration.Version, migration.Name, false); err != nil {
+			return reverted, fmt.Errorf("migration %04d_%s: %w", migration.Version, migration.Name, err)
+		}
+		reverted = append(reverted, migration)
+	}
+	return reverted, nil
+}
+
+type pgMigrationDB struct {
+	conn *PostgresDB
+}
+
+func (p *pgMigrationDB) createTable() error {
+	ctx, cancel := p.conn.context()
+	defer cancel()
+	_, err := p.conn.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+		    version INTEGER PRIMARY KEY,
+		    name TEXT NOT NULL,
+		    applied TIMESTAMP NOT NULL DEFAULT NOW()
+		);
+	`)
+	return err
+}
+
+func (p *pgMigrationDB) applied() (map[int]time.Time, error) {
+	ctx, cancel := p.conn.context()
+	defer cancel()
+	rows, err := p.conn.Query(ctx, `SELECT version, applied FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+func (p *pgMigrationDB) apply(script string, version int, name string, up bool) error {
+	// Migrations may rewrite whole tables, so they get no deadline.
+	ctx := context.Background()
+	tx, err := p.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, script); err != nil {
+		return err
+	}
+	if up {
+		_, err = tx.Exec(ctx, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, version, name)
+	} else {
+		_, err = tx.Exec(ctx, `DELETE FROM schema_migrations WHERE version = $1`, version)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+type sqliteMigrationDB struct {
+	db *sql.DB
+}
+
+func (s *sqliteMigrationDB) createTable() error {
+	_, err := s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+		    version INTEGER PRIMARY KEY,
+		    name TEXT NOT NULL,
+		    applied TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);
+	`)
+	return err
+}
+
+func (s *sqliteMigrationDB) applied() (map[int]time.Time, error) {
+	rows, err := s.db.Query(`SELECT version, applied FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+func (s *sqliteMigrationDB) apply(script string, version int, name string, up bool) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(script); err != nil {
+		return err
+	}
+	if up {
+		_, err = tx.Exec(`INSERT INTO schema_migrations (version, name) VALUES (?, ?)`, version, name)
+	} else {
+		_, err = tx.Exec(`DELETE FROM schema_migrations WHERE version = ?`, version)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
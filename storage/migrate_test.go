@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+
+	"limit-order-book/engine"
+)
+
+func TestSqliteMigrationsUpAndDown(t *testing.T) {
+	t.Setenv("TRADES", filepath.Join(t.TempDir(), "orderbook.db"))
+	db := OpenSqlite()
+	defer db.Close()
+
+	migrator, err := NewSqliteMigrator(db)
+	if err != nil {
+		t.Fatalf("tests - migrator failed. expected=%v, got=%v", nil, err)
+	}
+	applied, err := migrator.Up()
+	if err != nil || len(applied) != len(migrator.migrations) {
+		t.Fatalf("tests - up should apply every migration. expected=%d, got=%d, %v", len(migrator.migrations), len(applied), err)
+	}
+	if pending, _ := migrator.Pending(); len(pending) != 0 {
+		t.Fatalf("tests - nothing should be pending after up. expected=%d, got=%d", 0, len(pending))
+	}
+	if applied, _ := migrator.Up(); len(applied) != 0 {
+		t.Fatalf("tests - second up should do nothing. expected=%d, got=%d", 0, len(applied))
+	}
+
+	reverted, err := migrator.Down(len(migrator.migrations))
+	if err != nil || len(reverted) != len(migrator.migrations) {
+		t.Fatalf("tests - down should revert every migration. expected=%d, got=%d, %v", len(migrator.migrations), len(reverted), err)
+	}
+	var tables int
+	db.QueryRow(`SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = 'levels'`).Scan(&tables)
+	if tables != 0 {
+		t.Fatalf("tests - down should drop the tables. expected=%d, got=%d", 0, tables)
+	}
+
+	if _, err := migrator.Up(); err != nil {
+		t.Fatalf("tests - up after down failed. expected=%v, got=%v", nil, err)
+	}
+}
+
+func TestSqliteMigrationsAdoptOldSchema(t *testing.T) {
+	t.Setenv("TRADES", filepath.Join(t.TempDir(), "orderbook.db"))
+	db := OpenSqlite()
+	_, err := db.Exec(`
+		CREATE TABLE levels (
+		    symbol TEXT NOT NULL DEFAULT 'DEFAULT',
+		    side INTEGER NOT NULL,
+		    price INTEGER NOT NULL,
+		    volume INTEGER NOT NULL,
+		    count INTEGER NOT NULL,
+		    PRIMARY KEY(symbol, side, price)
+		);
+		CREATE TABLE level_orders (
+		    symbol TEXT NOT NULL DEFAULT 'DEFAULT',
+		    level_side TEXT NOT NULL,
+		    level_price INTEGER NOT NULL,
+		    order_id TEXT NOT NULL,
+		    PRIMARY KEY(symbol, level_side, level_price, order_id)
+		);
+		CREATE TABLE trades (
+		    id TEXT PRIMARY KEY,
+		    symbol TEXT NOT NULL DEFAULT 'DEFAULT',
+		    buy_order_id TEXT NOT NULL,
+		    sell_order_id TEXT NOT NULL,
+		    price INTEGER NOT NULL,
+		    size INTEGER NOT NULL,
+		    time TEXT NOT NULL,
+		    FOREIGN KEY(buy_order_id) REFERENCES orders(id),
+		    FOREIGN KEY(sell_order_id) REFERENCES orders(id)
+		);
+		CREATE TRIGGER level_orders_after_insert
+		AFTER INSERT ON level_orders
+		BEGIN
+		    UPDATE levels SET count = count + 1
+		    WHERE symbol = NEW.symbol AND side = NEW.level_side AND price = NEW.level_price;
+		END;
+	`)
+	db.Close()
+	if err != nil {
+		t.Fatalf("tests - old schema failed. expected=%v, got=%v", nil, err)
+	}
+
+	db = InitSqlite()
+	defer db.Close()
+
+	var sideType string
+	db.QueryRow(`SELECT type FROM pragma_table_info('level_orders') WHERE name = 'level_side'`).Scan(&sideType)
+	if sideType != "INTEGER" {
+		t.Fatalf("tests - level_side should be rebuilt as INTEGER. expected=%s, got=%s", "INTEGER", sideType)
+	}
+
+	ob := engine.NewOrderBook()
+	ob.AddStorage(&SqliteStorage{Database: db, Symbol: "BTC-USD"})
+	ob.ProcessOrder(engine.Buy, 40, 5)
+	ob.ProcessOrder(engine.Sell, 40, 2)
+
+	report, err := ob.Check()
+	if err != nil || !report.OK() {
+		t.Fatalf("tests - migrated storage should match the book. expected=%v, got=%+v, %v", nil, report, err)
+	}
+}
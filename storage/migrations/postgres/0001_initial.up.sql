@@ -0,0 +1,117 @@
+-- The schema as InitPostgres created it before migrations. Every statement
+-- tolerates a database that already has it, so existing databases are
+-- brought up to date and adopted.
+
+CREATE TABLE IF NOT EXISTS levels (
+    symbol TEXT NOT NULL DEFAULT 'DEFAULT',
+    side INTEGER NOT NULL,
+    price INTEGER NOT NULL,
+    volume INTEGER NOT NULL,
+    count INTEGER NOT NULL,
+    PRIMARY KEY (symbol, side, price)
+);
+
+CREATE TABLE IF NOT EXISTS orders (
+    id TEXT PRIMARY KEY,
+    symbol TEXT NOT NULL DEFAULT 'DEFAULT',
+    side INTEGER NOT NULL,
+    time_in_force INTEGER NOT NULL DEFAULT 0,
+    post_only BOOLEAN NOT NULL DEFAULT FALSE,
+    size INTEGER NOT NULL,
+    remaining INTEGER NOT NULL,
+    display_size INTEGER NOT NULL DEFAULT 0,
+    hidden INTEGER NOT NULL DEFAULT 0,
+    price INTEGER NOT NULL,
+    time TIMESTAMP NOT NULL,
+    next_id TEXT,
+    prev_id TEXT,
+    CONSTRAINT fk_next FOREIGN KEY (next_id) REFERENCES orders(id),
+    CONSTRAINT fk_prev FOREIGN KEY (prev_id) REFERENCES orders(id)
+);
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS time_in_force INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS post_only BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS display_size INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS hidden INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS symbol TEXT NOT NULL DEFAULT 'DEFAULT';
+
+CREATE TABLE IF NOT EXISTS level_orders (
+    symbol TEXT NOT NULL DEFAULT 'DEFAULT',
+    level_side INTEGER NOT NULL,
+    level_price INTEGER NOT NULL,
+    order_id TEXT NOT NULL,
+    PRIMARY KEY (symbol, level_side, level_price, order_id),
+    CONSTRAINT fk_level FOREIGN KEY (symbol, level_side, level_price) REFERENCES levels(symbol, side, price),
+    CONSTRAINT fk_order FOREIGN KEY (order_id) REFERENCES orders(id)
+);
+
+-- Books created before symbols existed keyed levels on (side, price)
+-- alone; move them under the default symbol.
+ALTER TABLE levels ADD COLUMN IF NOT EXISTS symbol TEXT NOT NULL DEFAULT 'DEFAULT';
+ALTER TABLE level_orders ADD COLUMN IF NOT EXISTS symbol TEXT NOT NULL DEFAULT 'DEFAULT';
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1 FROM information_schema.key_column_usage
+        WHERE table_name = 'levels' AND constraint_name = 'levels_pkey' AND column_name = 'symbol'
+    ) THEN
+        ALTER TABLE level_orders DROP CONSTRAINT IF EXISTS fk_level;
+        ALTER TABLE level_orders DROP CONSTRAINT IF EXISTS level_orders_pkey;
+        ALTER TABLE levels DROP CONSTRAINT levels_pkey;
+        ALTER TABLE levels ADD PRIMARY KEY (symbol, side, price);
+        ALTER TABLE level_orders ADD PRIMARY KEY (symbol, level_side, level_price, order_id);
+        ALTER TABLE level_orders ADD CONSTRAINT fk_level
+            FOREIGN KEY (symbol, level_side, level_price) REFERENCES levels(symbol, side, price);
+    END IF;
+END $$;
+
+-- Trades outlive the orders they filled, so they can't reference them.
+CREATE TABLE IF NOT EXISTS trades (
+    id TEXT PRIMARY KEY,
+    symbol TEXT NOT NULL DEFAULT 'DEFAULT',
+    buy_order_id TEXT NOT NULL,
+    sell_order_id TEXT NOT NULL,
+    price INTEGER NOT NULL,
+    size INTEGER NOT NULL,
+    time TIMESTAMP NOT NULL
+);
+ALTER TABLE trades ADD COLUMN IF NOT EXISTS symbol TEXT NOT NULL DEFAULT 'DEFAULT';
+ALTER TABLE trades DROP CONSTRAINT IF EXISTS fk_buy;
+ALTER TABLE trades DROP CONSTRAINT IF EXISTS fk_sell;
+
+CREATE TABLE IF NOT EXISTS stop_orders (
+    id TEXT PRIMARY KEY,
+    symbol TEXT NOT NULL DEFAULT 'DEFAULT',
+    side INTEGER NOT NULL,
+    type INTEGER NOT NULL,
+    time_in_force INTEGER NOT NULL,
+    post_only BOOLEAN NOT NULL,
+    size INTEGER NOT NULL,
+    display_size INTEGER NOT NULL DEFAULT 0,
+    price INTEGER NOT NULL,
+    stop_price INTEGER NOT NULL,
+    time TIMESTAMP NOT NULL
+);
+ALTER TABLE stop_orders ADD COLUMN IF NOT EXISTS display_size INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE stop_orders ADD COLUMN IF NOT EXISTS symbol TEXT NOT NULL DEFAULT 'DEFAULT';
+
+CREATE TABLE IF NOT EXISTS symbols (
+    symbol TEXT PRIMARY KEY,
+    created TIMESTAMP NOT NULL DEFAULT NOW()
+);
+ALTER TABLE symbols ADD COLUMN IF NOT EXISTS tick_size INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE symbols ADD COLUMN IF NOT EXISTS lot_size INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE symbols ADD COLUMN IF NOT EXISTS min_size INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE symbols ADD COLUMN IF NOT EXISTS max_size INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE symbols ADD COLUMN IF NOT EXISTS min_price INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE symbols ADD COLUMN IF NOT EXISTS max_price INTEGER NOT NULL DEFAULT 0;
+
+-- Level volume and count are kept up to date by the application. Databases
+-- created from the old postgres_schema.sql also had triggers doing it,
+-- which counted every change twice.
+DROP TRIGGER IF EXISTS level_orders_after_insert ON level_orders;
+DROP TRIGGER IF EXISTS level_orders_after_delete ON level_orders;
+DROP TRIGGER IF EXISTS orders_after_update_remaining ON orders;
+DROP FUNCTION IF EXISTS level_orders_after_insert();
+DROP FUNCTION IF EXISTS level_orders_after_delete();
+DROP FUNCTION IF EXISTS orders_after_update_remaining();
@@ -0,0 +1,5 @@
+-- Trade history is paged by (time, id) within a symbol and filtered by the
+-- orders on either side.
+CREATE INDEX IF NOT EXISTS trades_symbol_time ON trades (symbol, time, id);
+CREATE INDEX IF NOT EXISTS trades_buy_order ON trades (buy_order_id);
+CREATE INDEX IF NOT EXISTS trades_sell_order ON trades (sell_order_id);
@@ -0,0 +1,15 @@
+-- One candle per symbol, interval and start; the interval column is called
+-- resolution because INTERVAL is a type in Postgres. VWAP is notional / volume.
+CREATE TABLE IF NOT EXISTS candles (
+    symbol TEXT NOT NULL,
+    resolution TEXT NOT NULL,
+    start TIMESTAMP NOT NULL,
+    open INTEGER NOT NULL,
+    high INTEGER NOT NULL,
+    low INTEGER NOT NULL,
+    close INTEGER NOT NULL,
+    volume BIGINT NOT NULL,
+    notional BIGINT NOT NULL,
+    trades INTEGER NOT NULL,
+    PRIMARY KEY (symbol, resolution, start)
+);
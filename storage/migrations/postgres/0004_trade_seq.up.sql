@@ -0,0 +1,7 @@
+-- seq numbers each symbol's trades in the order they were made; the trades
+-- of one command share a timestamp, and ids are in no order. Trades
+-- already stored are numbered by time, then id.
+ALTER TABLE trades ADD COLUMN IF NOT EXISTS seq BIGINT NOT NULL DEFAULT 0;
+UPDATE trades SET seq = numbered.seq
+FROM (SELECT id, ROW_NUMBER() OVER (PARTITION BY symbol ORDER BY time, id) AS seq FROM trades) AS numbered
+WHERE trades.id = numbered.id;
@@ -0,0 +1 @@
+ALTER TABLE level_orders DROP COLUMN IF EXISTS seq;
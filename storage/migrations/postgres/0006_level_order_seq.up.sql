@@ -0,0 +1,12 @@
+-- seq keeps the orders of a level in the order they joined it, which is
+-- the order they fill in; rows come back in no fixed order without it.
+-- Orders already stored are numbered by time, then id, since an order
+-- that loses its place is stamped anew.
+ALTER TABLE level_orders ADD COLUMN IF NOT EXISTS seq BIGSERIAL;
+UPDATE level_orders SET seq = numbered.seq
+FROM (
+    SELECT lo.order_id, ROW_NUMBER() OVER (ORDER BY o.time, o.id) AS seq
+    FROM level_orders lo JOIN orders o ON o.id = lo.order_id
+) AS numbered
+WHERE level_orders.order_id = numbered.order_id;
+SELECT setval(pg_get_serial_sequence('level_orders', 'seq'), COALESCE(MAX(seq), 0) + 1, false) FROM level_orders;
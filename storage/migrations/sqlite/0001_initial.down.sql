@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS symbols;
+DROP TABLE IF EXISTS stop_orders;
+DROP TABLE IF EXISTS trades;
+DROP TABLE IF EXISTS level_orders;
+DROP TABLE IF EXISTS orders;
+DROP TABLE IF EXISTS levels;
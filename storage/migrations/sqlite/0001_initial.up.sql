@@ -0,0 +1,80 @@
+-- The schema as InitSqlite created it before migrations.
+
+CREATE TABLE IF NOT EXISTS levels (
+    symbol TEXT NOT NULL DEFAULT 'DEFAULT',
+    side INTEGER NOT NULL,
+    price INTEGER NOT NULL,
+    volume INTEGER NOT NULL,
+    count INTEGER NOT NULL,
+    PRIMARY KEY (symbol, side, price)
+);
+
+CREATE TABLE IF NOT EXISTS orders (
+    id TEXT PRIMARY KEY,
+    symbol TEXT NOT NULL DEFAULT 'DEFAULT',
+    side INTEGER NOT NULL,
+    time_in_force INTEGER NOT NULL DEFAULT 0,
+    post_only INTEGER NOT NULL DEFAULT 0,
+    size INTEGER NOT NULL,
+    remaining INTEGER NOT NULL,
+    display_size INTEGER NOT NULL DEFAULT 0,
+    hidden INTEGER NOT NULL DEFAULT 0,
+    price INTEGER NOT NULL,
+    time TIMESTAMP NOT NULL,
+    next_id TEXT,
+    prev_id TEXT,
+    FOREIGN KEY (next_id) REFERENCES orders(id),
+    FOREIGN KEY (prev_id) REFERENCES orders(id)
+);
+
+CREATE TABLE IF NOT EXISTS level_orders (
+    symbol TEXT NOT NULL DEFAULT 'DEFAULT',
+    level_side INTEGER NOT NULL,
+    level_price INTEGER NOT NULL,
+    order_id TEXT NOT NULL,
+    PRIMARY KEY (symbol, level_side, level_price, order_id),
+    FOREIGN KEY (symbol, level_side, level_price) REFERENCES levels(symbol, side, price),
+    FOREIGN KEY (order_id) REFERENCES orders(id)
+);
+
+CREATE TABLE IF NOT EXISTS trades (
+    id TEXT PRIMARY KEY,
+    symbol TEXT NOT NULL DEFAULT 'DEFAULT',
+    buy_order_id TEXT NOT NULL,
+    sell_order_id TEXT NOT NULL,
+    price INTEGER NOT NULL,
+    size INTEGER NOT NULL,
+    time TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS stop_orders (
+    id TEXT PRIMARY KEY,
+    symbol TEXT NOT NULL DEFAULT 'DEFAULT',
+    side INTEGER NOT NULL,
+    type INTEGER NOT NULL,
+    time_in_force INTEGER NOT NULL,
+    post_only INTEGER NOT NULL,
+    size INTEGER NOT NULL,
+    display_size INTEGER NOT NULL DEFAULT 0,
+    price INTEGER NOT NULL,
+    stop_price INTEGER NOT NULL,
+    time TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS symbols (
+    symbol TEXT PRIMARY KEY,
+    created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    tick_size INTEGER NOT NULL DEFAULT 1,
+    lot_size INTEGER NOT NULL DEFAULT 1,
+    min_size INTEGER NOT NULL DEFAULT 1,
+    max_size INTEGER NOT NULL DEFAULT 0,
+    min_price INTEGER NOT NULL DEFAULT 1,
+    max_price INTEGER NOT NULL DEFAULT 0
+);
+
+-- Level volume and count are kept up to date by the application. Databases
+-- created from the old schema.sql also had triggers doing it, which counted
+-- every change twice.
+DROP TRIGGER IF EXISTS level_orders_after_insert;
+DROP TRIGGER IF EXISTS level_orders_after_delete;
+DROP TRIGGER IF EXISTS orders_after_update_remaining;
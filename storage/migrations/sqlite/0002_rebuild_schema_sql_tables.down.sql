@@ -0,0 +1 @@
+-- The rebuilt tables are the ones 0001 declares; there is nothing to undo.
@@ -0,0 +1,32 @@
+-- Databases created from the old schema.sql declared level_orders.level_side
+-- as TEXT, so it didn't match levels.side, and had trades reference the
+-- orders they filled, which are deleted once filled. Rebuild both tables as
+-- 0001 declares them. Nothing references either table.
+
+CREATE TABLE level_orders_new (
+    symbol TEXT NOT NULL DEFAULT 'DEFAULT',
+    level_side INTEGER NOT NULL,
+    level_price INTEGER NOT NULL,
+    order_id TEXT NOT NULL,
+    PRIMARY KEY (symbol, level_side, level_price, order_id),
+    FOREIGN KEY (symbol, level_side, level_price) REFERENCES levels(symbol, side, price),
+    FOREIGN KEY (order_id) REFERENCES orders(id)
+);
+INSERT INTO level_orders_new (symbol, level_side, level_price, order_id)
+    SELECT symbol, CAST(level_side AS INTEGER), level_price, order_id FROM level_orders ORDER BY rowid;
+DROP TABLE level_orders;
+ALTER TABLE level_orders_new RENAME TO level_orders;
+
+CREATE TABLE trades_new (
+    id TEXT PRIMARY KEY,
+    symbol TEXT NOT NULL DEFAULT 'DEFAULT',
+    buy_order_id TEXT NOT NULL,
+    sell_order_id TEXT NOT NULL,
+    price INTEGER NOT NULL,
+    size INTEGER NOT NULL,
+    time TIMESTAMP NOT NULL
+);
+INSERT INTO trades_new (id, symbol, buy_order_id, sell_order_id, price, size, time)
+    SELECT id, symbol, buy_order_id, sell_order_id, price, size, time FROM trades ORDER BY rowid;
+DROP TABLE trades;
+ALTER TABLE trades_new RENAME TO trades;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS trades_sell_order;
+DROP INDEX IF EXISTS trades_buy_order;
+DROP INDEX IF EXISTS trades_symbol_time;
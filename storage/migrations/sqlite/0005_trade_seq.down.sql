@@ -0,0 +1 @@
+ALTER TABLE trades DROP COLUMN seq;
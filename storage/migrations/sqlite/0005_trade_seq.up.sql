@@ -0,0 +1,8 @@
+-- seq numbers each symbol's trades in the order they were made; the trades
+-- of one command share a timestamp, and ids are in no order. Trades
+-- already stored are numbered in the order they were restored in: by
+-- time, then rowid.
+ALTER TABLE trades ADD COLUMN seq INTEGER NOT NULL DEFAULT 0;
+UPDATE trades SET seq = numbered.seq
+FROM (SELECT rowid AS row, ROW_NUMBER() OVER (PARTITION BY symbol ORDER BY time, rowid) AS seq FROM trades) AS numbered
+WHERE trades.rowid = numbered.row;
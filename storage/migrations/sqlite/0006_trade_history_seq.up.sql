@@ -0,0 +1,2 @@
+-- Trade history is paged by seq within a symbol.
+CREATE INDEX IF NOT EXISTS trades_symbol_seq ON trades (symbol, seq);
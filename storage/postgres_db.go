@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PostgresConfig says where Postgres is and how patient to be with it.
+type PostgresConfig struct {
+	// DSN is a postgres:// URL or key=value connection string. Pool
+	// settings go in it too: pool_max_conns, pool_min_conns,
+	// pool_max_conn_lifetime, pool_max_conn_idle_time and
+	// pool_health_check_period.
+	DSN string
+	// QueryTimeout bounds every query, or every attempt at a write that
+	// is retried as a whole.
+	QueryTimeout time.Duration
+	// Retries is how many times a transient failure is retried, waiting
+	// RetryBackoff, then twice as long each time.
+	Retries      int
+	RetryBackoff time.Duration
+}
+
+// PostgresConfigFromEnv reads the config from POSTGRES_DSN, or builds the
+// DSN from POSTGRES_USER, POSTGRES_PASSWORD, POSTGRES_HOST, POSTGRES_PORT
+// and POSTGRES_DB, and from POSTGRES_QUERY_TIMEOUT, POSTGRES_RETRIES and
+// POSTGRES_RETRY_BACKOFF.
+func PostgresConfigFromEnv() (PostgresConfig, error) {
+	config := PostgresConfig{
+		DSN:          os.Getenv("POSTGRES_DSN"),
+		QueryTimeout: 5 * time.Second,
+		Retries:      3,
+		RetryBackoff: 100 * time.Millisecond,
+	}
+	if config.DSN == "" {
+		dbPort := os.Getenv("POSTGRES_PORT")
+		if dbPort == "" {
+			dbPort = "5432"
+		}
+		config.DSN = fmt.Sprintf("postgres://%s:%s@%s:%s/%s",
+			os.Getenv("POSTGRES_USER"), os.Getenv("POSTGRES_PASSWORD"), os.Getenv("POSTGRES_HOST"), dbPort, os.Getenv("POSTGRES_DB"))
+	}
+
+	var err error
+	if v := os.Getenv("POSTGRES_QUERY_TIMEOUT"); v != "" {
+		if config.QueryTimeout, err = time.ParseDuration(v); err != nil {
+			return config, fmt.Errorf("POSTGRES_QUERY_TIMEOUT: %w", err)
+		}
+	}
+	if v := os.Getenv("POSTGRES_RETRIES"); v != "" {
+		if config.Retries, err = strconv.Atoi(v); err != nil {
+			return config, fmt.Errorf("POSTGRES_RETRIES: %w", err)
+		}
+	}
+	if v := os.Getenv("POSTGRES_RETRY_BACKOFF"); v != "" {
+		if config.RetryBackoff, err = time.ParseDuration(v); err != nil {
+			return config, fmt.Errorf("POSTGRES_RETRY_BACKOFF: %w", err)
+		}
+	}
+	return config, nil
+}
+
+// PostgresDB is a connection pool, safe for concurrent use. The pool
+// checks idle connections and replaces broken ones, so a dropped
+// connection costs the queries on it, not the process.
+type PostgresDB struct {
+	*pgxpool.Pool
+	config PostgresConfig
+}
+
+// OpenPostgres creates the pool and waits, retrying, until the database
+// answers.
+func OpenPostgres(config PostgresConfig) (*PostgresDB, error) {
+	poolConfig, err := pgxpool.ParseConfig(config.DSN)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := pgxpool.NewWithConfig(context.Background(), poolConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	db := &PostgresDB{Pool: pool, config: config}
+	if err := db.retry(func(ctx context.Context) error { return pool.Ping(ctx) }); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// context returns a context for one query.
+func (db *PostgresDB) context() (context.Context, context.CancelFunc) {
+	if db.config.QueryTimeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), db.config.QueryTimeout)
+}
+
+// retry runs fn, with a fresh deadline each time, until it succeeds, fails
+// for good or runs out of retries. fn must be safe to repeat after a
+// transient failure: a read, or a transaction that didn't commit.
+func (db *PostgresDB) retry(fn func(ctx context.Context) error) error {
+	backoff := db.config.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		ctx, cancel := db.context()
+		err := fn(ctx)
+		cancel()
+		if err == nil || attempt >= db.config.Retries || !isTransient(err) {
+			return err
+		}
+
+		Logger.Printf("Postgres failed, retrying in %s: %s", backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// isTransient tells whether err may go away if the work is tried again:
+// the request never reached the server, or the server gave up on the
+// transaction rather than refusing it.
+func isTransient(err error) bool {
+	if pgconn.SafeToRetry(err) {
+		return true
+	}
+	var connectErr *pgconn.ConnectError
+	if errors.As(err, &connectErr) {
+		return true
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "40001", // serialization_failure
+			"40P01", // deadlock_detected
+			"53300", // too_many_connections
+			"57P01", // admin_shutdown
+			"57P02", // crash_shutdown
+			"57P03": // cannot_connect_now
+			return true
+		}
+		// Class 08 is connection exceptions.
+		return strings.HasPrefix(pgErr.Code, "08")
+	}
+	return false
+}
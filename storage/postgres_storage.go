@@ -1,11 +1,10 @@
 package storage
 
 import (
-	"database/sql"
 	"context"
-	"fmt"
+	"database/sql"
+	"errors"
 	"log"
-	"os"
 
 	"limit-order-book/engine"
 
@@ -15,251 +14,216 @@ import (
 
 var Logger *log.Logger
 
+// PostgresStorage persists the order book of one symbol. Every table
+// carries a symbol column so several books can share a database.
 type PostgresStorage struct {
-	Database *pgx.Conn
+	Database *PostgresDB
+	Symbol   string
+	tx       pgx.Tx
 }
 
-func (s *PostgresStorage) ResetOrderBook() error {
-	ctx := context.Background()
+// pgxConn is what writes run on: the pool, or the open unit of work.
+// Writes that begin their own transaction get a savepoint inside the unit
+// of work.
+type pgxConn interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
 
-	if _, err := s.Database.Exec(ctx, `DELETE FROM level_orders`); err != nil {
-		return err
-	}
+// write runs fn in a transaction of its own, retried on transient
+// failures, or in a savepoint of the open unit of work. A unit of work
+// that fails is the engine's to roll back, so it is never retried here.
+func (s *PostgresStorage) write(fn func(ctx context.Context, conn pgxConn) error) error {
+	if s.tx != nil {
+		ctx, cancel := s.Database.context()
+		defer cancel()
+		return fn(ctx, s.tx)
+	}
+	return s.Database.retry(func(ctx context.Context) error {
+		return fn(ctx, s.Database)
+	})
+}
 
-	if _, err := s.Database.Exec(ctx, `DELETE FROM levels`); err != nil {
-		return err
+// Begin takes a connection from the pool for the unit of work and keeps
+// it until Commit or Rollback.
+func (s *PostgresStorage) Begin(seq uint64) error {
+	if s.tx != nil {
+		return errors.New("unit of work already open")
 	}
+	return s.Database.retry(func(ctx context.Context) error {
+		tx, err := s.Database.Begin(ctx)
+		if err != nil {
+			return err
+		}
+		s.tx = tx
+		return nil
+	})
+}
 
-	if _, err := s.Database.Exec(ctx, `DELETE FROM orders`); err != nil {
-		return err
+func (s *PostgresStorage) Commit() error {
+	if s.tx == nil {
+		return nil
 	}
+	tx := s.tx
+	s.tx = nil
+	ctx, cancel := s.Database.context()
+	defer cancel()
+	return tx.Commit(ctx)
+}
 
-	if _, err := s.Database.Exec(ctx, `DELETE FROM trades`); err != nil {
-		return err
+func (s *PostgresStorage) Rollback() error {
+	if s.tx == nil {
+		return nil
 	}
+	tx := s.tx
+	s.tx = nil
+	ctx, cancel := s.Database.context()
+	defer cancel()
+	return tx.Rollback(ctx)
+}
 
-	return nil
+func (s *PostgresStorage) ResetOrderBook() error {
+	return s.run(engine.Mutation{Op: engine.OpReset})
 }
 
+// RestoreOrderBook reads the book in one read-only transaction, so it is
+// consistent even while another process writes.
 func (s *PostgresStorage) RestoreOrderBook() (*engine.OrderBook, error) {
-	levelDTO, err := getPostgresLevels(s.Database)
-	if err != nil {
-		Logger.Printf("Error getting levels from db: %s", err)
-		return nil, err
-	}
+	var obDTO engine.OrderBookDTO
+	err := s.Database.retry(func(ctx context.Context) error {
+		tx, err := s.Database.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback(ctx)
 
-	orderDTO, err := getAllPostgresOrders(s.Database)
-	if err != nil {
-		Logger.Printf("Error getting orders from db: %s", err)
-		return nil, err
-	}
+		obDTO.Levels, err = getPostgresLevels(ctx, tx, s.Symbol)
+		if err != nil {
+			Logger.Printf("Error getting levels from db: %s", err)
+			return err
+		}
 
-	tradeDTO, err := getAllPostgresTrades(s.Database)
-	if err != nil {
-		Logger.Printf("Error getting trades from db: %s", err)
-		return nil, err
-	}
+		obDTO.Orders, err = getAllPostgresOrders(ctx, tx, s.Symbol)
+		if err != nil {
+			Logger.Printf("Error getting orders from db: %s", err)
+			return err
+		}
 
+		obDTO.Trades, err = getAllPostgresTrades(ctx, tx, s.Symbol)
+		if err != nil {
+			Logger.Printf("Error getting trades from db: %s", err)
+			return err
+		}
 
-	obDTO := engine.OrderBookDTO {
-		Levels: levelDTO,
-		Orders: orderDTO,
-		Trades: tradeDTO,
+		obDTO.Stops, err = getAllPostgresStopOrders(ctx, tx, s.Symbol)
+		if err != nil {
+			Logger.Printf("Error getting stop orders from db: %s", err)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return obDTO.ToOrderBook(), nil
 }
 
-func InitPostgres() *pgx.Conn {
-	dbUser := os.Getenv("POSTGRES_USER")
-	dbPass := os.Getenv("POSTGRES_PASSWORD")
-	dbName := os.Getenv("POSTGRES_DB")
-	dbHost := os.Getenv("POSTGRES_HOST")
-	dbPort := os.Getenv("POSTGRES_PORT")
-	if dbPort == "" {
-		dbPort = "5432"
-	}
-
-	dbURL := fmt.Sprintf("postgres://%s:%s@%s:%s/%s", dbUser, dbPass, dbHost, dbPort, dbName)
-	ctx := context.Background()
-	db, err := pgx.Connect(ctx, dbURL)
-	if err != nil {
-		Logger.Fatalf("failed to connect to db: %s", err)
-	}
-	_, err = db.Exec(ctx, `
-		CREATE TABLE IF NOT EXISTS levels (
-		    side INTEGER NOT NULL,
-		    price INTEGER NOT NULL,
-		    volume INTEGER NOT NULL,
-		    count INTEGER NOT NULL,
-		    PRIMARY KEY (side, price)
-		);
-	`)
-	if err != nil {
-		Logger.Fatalf("failed to create levels table: %s", err)
-	}
-
-	_, err = db.Exec(ctx, `
-		CREATE TABLE IF NOT EXISTS orders (
-		    id TEXT PRIMARY KEY,
-		    side INTEGER NOT NULL,
-		    size INTEGER NOT NULL,
-		    remaining INTEGER NOT NULL,
-		    price INTEGER NOT NULL,
-		    time TIMESTAMP NOT NULL,
-		    next_id TEXT,
-		    prev_id TEXT,
-		    CONSTRAINT fk_next FOREIGN KEY (next_id) REFERENCES orders(id),
-		    CONSTRAINT fk_prev FOREIGN KEY (prev_id) REFERENCES orders(id)
-		);
-	`)
-	if err != nil {
-		Logger.Fatalf("failed to create orders table: %s", err)
-	}
+// InitPostgres connects to Postgres and applies pending migrations.
+func InitPostgres() *PostgresDB {
+	db := ConnectPostgres()
+	migrateUp(NewPostgresMigrator(db))
+	return db
+}
 
-	_, err = db.Exec(ctx, `
-		CREATE TABLE IF NOT EXISTS level_orders (
-		    level_side INTEGER NOT NULL,
-		    level_price INTEGER NOT NULL,
-		    order_id TEXT NOT NULL,
-		    PRIMARY KEY(level_side, level_price, order_id),
-		    CONSTRAINT fk_level FOREIGN KEY(level_side, level_price) REFERENCES levels(side, price),
-		    CONSTRAINT fk_order FOREIGN KEY(order_id) REFERENCES orders(id)
-		);
-	`)
+// ConnectPostgres opens a pool on the database PostgresConfigFromEnv
+// describes, leaving its schema alone.
+func ConnectPostgres() *PostgresDB {
+	config, err := PostgresConfigFromEnv()
 	if err != nil {
-		Logger.Fatalf("failed to create level_orders table: %s", err)
+		Logger.Fatalf("bad postgres config: %s", err)
 	}
-
-	_, err = db.Exec(ctx, `
-		CREATE TABLE IF NOT EXISTS trades (
-		    id TEXT PRIMARY KEY,
-		    buy_order_id TEXT NOT NULL,
-		    sell_order_id TEXT NOT NULL,
-		    price INTEGER NOT NULL,
-		    size INTEGER NOT NULL,
-		    time TIMESTAMP NOT NULL
-		);
-	`)
-
+	db, err := OpenPostgres(config)
 	if err != nil {
-		Logger.Fatalf("failed to create trades table: %s", err)
+		Logger.Fatalf("failed to connect to db: %s", err)
 	}
-
 	return db
 }
 
-
 func (s *PostgresStorage) InsertLevel(side engine.Side, l *engine.LevelDTO) error {
-	ctx := context.Background()
-	tx, err := s.Database.Begin(ctx)
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback(ctx)
-
-	if _, err := tx.Exec(ctx, `
-		INSERT INTO levels (side, price, volume, count)
-		VALUES ($1, $2, $3, $4)`,
-		side, l.Price, 0, 0, //InsertOrder takes care of updating volume, count
-	); err != nil {
-		return err
-	}
-
-	return tx.Commit(ctx)
+	return s.run(engine.Mutation{Op: engine.OpInsertLevel, Side: side, Level: l})
 }
 
 func (s *PostgresStorage) InsertOrder(o *engine.OrderDTO) error {
-	ctx := context.Background()
-	tx, err := s.Database.Begin(ctx)
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback(ctx)
+	return s.run(engine.Mutation{Op: engine.OpInsertOrder, Order: o})
+}
 
-	if _, err := tx.Exec(ctx, `
-		INSERT INTO orders (id, side, size, remaining, price, time, next_id, prev_id)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
-		o.Id.String(), o.Side, o.Size, o.Remaining, o.Price, o.Time,
-		uuidToString(o.NextID), uuidToString(o.PrevID),
-	); err != nil {
-		return err
-	}
+func (s *PostgresStorage) DeleteOrder(o *engine.OrderDTO) error {
+	return s.run(engine.Mutation{Op: engine.OpDeleteOrder, Order: o})
+}
 
-	if _, err := tx.Exec(ctx,
-		`UPDATE orders SET next_id = $1 WHERE id = $2`,
-		o.Id.String(), uuidToString(o.PrevID),
-	); err != nil {
-		return err
-	}
+func (s *PostgresStorage) UpdateOrder(o *engine.OrderDTO) error {
+	return s.run(engine.Mutation{Op: engine.OpUpdateOrder, Order: o})
+}
 
-	if _, err := tx.Exec(ctx,
-		`INSERT INTO level_orders (level_side, level_price, order_id) VALUES ($1, $2, $3)`,
-		o.Side, o.Price, o.Id,
-	); err != nil {
+func (s *PostgresStorage) InsertTrade(t *engine.Trade) error {
+	if err := s.run(engine.Mutation{Op: engine.OpInsertTrade, Trade: t}); err != nil {
+		Logger.Printf("Error inserting trade: %s", err)
 		return err
 	}
+	return nil
+}
 
-	if _, err := tx.Exec(ctx,
-		`UPDATE levels SET count = count + 1 WHERE side = $1 AND price = $2`,
-		o.Side, o.Price,
-	); err != nil {
+func (s *PostgresStorage) InsertStopOrder(o *engine.OrderDTO) error {
+	if err := s.run(engine.Mutation{Op: engine.OpInsertStopOrder, Order: o}); err != nil {
+		Logger.Printf("Error inserting stop order: %s", err)
 		return err
 	}
+	return nil
+}
 
-	if _, err := tx.Exec(ctx,
-		`UPDATE levels SET volume = volume + $1 WHERE side = $2 AND price = $3`,
-		o.Remaining, o.Side, o.Price,
-	); err != nil {
-		return err
-	}
+func (s *PostgresStorage) DeleteStopOrder(o *engine.OrderDTO) error {
+	return s.run(engine.Mutation{Op: engine.OpDeleteStopOrder, Order: o})
+}
 
-	return tx.Commit(ctx)
+// WriteBatch writes the mutations of many commands in one transaction and
+// one round trip. Trades are copied in with COPY at the end; nothing else
+// in a batch reads them.
+func (s *PostgresStorage) WriteBatch(mutations []engine.Mutation) error {
+	return s.write(func(ctx context.Context, conn pgxConn) error {
+		return s.writeBatch(ctx, conn, mutations)
+	})
 }
 
-func (s *PostgresStorage) DeleteOrder(ob *engine.OrderBookDTO, o *engine.OrderDTO) error {
-	ctx := context.Background()
-	tx, err := s.Database.Begin(ctx)
+func (s *PostgresStorage) writeBatch(ctx context.Context, conn pgxConn, mutations []engine.Mutation) error {
+	tx, err := conn.Begin(ctx)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback(ctx)
 
-	if _, err := tx.Exec(ctx, `DELETE FROM level_orders WHERE order_id = $1`, o.Id.String()); err != nil {
-		return err
-	}
-
-	if _, err := tx.Exec(ctx,
-	       `UPDATE levels SET count = count - 1, volume = volume - $1 WHERE side = $2 AND price = $3`,
-	       o.Remaining, o.Side, o.Price,
-	); err != nil {
-	       return err
-	}
-
-	if _, err := tx.Exec(ctx,
-		`UPDATE orders SET prev_id = $1 WHERE id = $2`,
-		uuidToString(o.NextID), uuidToString(o.NextID),
-	); err != nil {
-		return err
-	}
-
-	if _, err := tx.Exec(ctx, `DELETE FROM orders WHERE id = $1`, o.Id.String()); err != nil {
-		return err
+	batch := &pgx.Batch{}
+	var trades [][]any
+	for _, m := range mutations {
+		if m.Op == engine.OpInsertTrade {
+			trades = append(trades, s.tradeRow(m.Trade))
+			continue
+		}
+		for _, stmt := range s.statements(m) {
+			batch.Queue(stmt.sql, stmt.args...)
+		}
 	}
 
-	row := tx.QueryRow(ctx, `
-		SELECT COUNT(*)
-		FROM level_orders
-		WHERE level_side = $1 AND level_price = $2`, o.Side, o.Price)
-
-	var count int
-	if err := row.Scan(&count); err != nil {
-		return err
+	if batch.Len() > 0 {
+		if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+			return err
+		}
 	}
 
-	if count == 0 {
-		_, err := tx.Exec(ctx, `DELETE FROM levels WHERE side = $1 AND price = $2`, o.Side, o.Price)
-		if err != nil {
+	if len(trades) > 0 {
+		if _, err := tx.CopyFrom(ctx, pgx.Identifier{"trades"},
+			[]string{"id", "symbol", "buy_order_id", "sell_order_id", "price", "size", "time", "seq"},
+			pgx.CopyFromRows(trades),
+		); err != nil {
 			return err
 		}
 	}
@@ -267,103 +231,148 @@ func (s *PostgresStorage) DeleteOrder(ob *engine.OrderBookDTO, o *engine.OrderDT
 	return tx.Commit(ctx)
 }
 
-func (s *PostgresStorage) UpdateOrder(ob *engine.OrderBookDTO, o *engine.OrderDTO) error {
-	ctx := context.Background()
-	tx, err := s.Database.Begin(ctx)
+// run makes one write in a transaction of its own, or in a savepoint of
+// the open unit of work.
+func (s *PostgresStorage) run(m engine.Mutation) error {
+	return s.write(func(ctx context.Context, conn pgxConn) error {
+		tx, err := conn.Begin(ctx)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback(ctx)
 
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback(ctx)
+		for _, stmt := range s.statements(m) {
+			if _, err := tx.Exec(ctx, stmt.sql, stmt.args...); err != nil {
+				return err
+			}
+		}
 
-	row := tx.QueryRow(ctx, `
-	 	SELECT remaining FROM orders
-		WHERE id = $1`,
-		o.Id.String(),
-	)
-	var oldRemaining int
-	if err := row.Scan(&oldRemaining); err != nil {
-		return err
-	}
+		return tx.Commit(ctx)
+	})
+}
 
-	if _, err := tx.Exec(ctx, `
-		UPDATE orders
-		SET remaining = $1
-		WHERE id = $2`,
-		o.Remaining, o.Id.String(),
-	); err != nil {
-		return err
-	}
+type pgStatement struct {
+	sql  string
+	args []any
+}
 
-	if _, err := tx.Exec(ctx,
-		`UPDATE levels SET volume = volume + $1 WHERE side = $2 AND price = $3`,
-		(o.Remaining - oldRemaining), o.Side, o.Price,
-	); err != nil {
-		return err
-	}
+// statements lists the SQL that makes a write. None of them depends on
+// the result of another, so they can be queued in a batch.
+func (s *PostgresStorage) statements(m engine.Mutation) []pgStatement {
+	o := m.Order
+	switch m.Op {
+	case engine.OpReset:
+		return []pgStatement{
+			{`DELETE FROM level_orders WHERE symbol = $1`, []any{s.Symbol}},
+			{`DELETE FROM levels WHERE symbol = $1`, []any{s.Symbol}},
+			{`UPDATE orders SET next_id = NULL, prev_id = NULL WHERE symbol = $1`, []any{s.Symbol}},
+			{`DELETE FROM orders WHERE symbol = $1`, []any{s.Symbol}},
+			{`DELETE FROM trades WHERE symbol = $1`, []any{s.Symbol}},
+			{`DELETE FROM stop_orders WHERE symbol = $1`, []any{s.Symbol}},
+		}
 
-	if o.Remaining <= 0 {
-		if _, err := tx.Exec(ctx, `
-			DELETE FROM level_orders
-			WHERE level_side = $1 AND level_price = $2 AND order_id = $3`,
-			o.Side, o.Price, o.Id.String(),
-		); err != nil {
-			return err
+	case engine.OpInsertLevel:
+		return []pgStatement{{`
+			INSERT INTO levels (symbol, side, price, volume, count)
+			VALUES ($1, $2, $3, 0, 0)`, //InsertOrder takes care of updating volume, count
+			[]any{s.Symbol, m.Side, m.Level.Price},
+		}}
+
+	case engine.OpInsertOrder:
+		return []pgStatement{
+			{`
+			INSERT INTO orders (id, symbol, side, time_in_force, post_only, size, remaining, display_size, hidden, price, time, next_id, prev_id)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`,
+				[]any{o.Id.String(), s.Symbol, o.Side, o.TimeInForce, o.PostOnly, o.Size, o.Remaining, o.DisplaySize, o.Hidden, o.Price, o.Time,
+					uuidToString(o.NextID), uuidToString(o.PrevID)},
+			},
+			{`UPDATE orders SET next_id = $1 WHERE id = $2`, []any{o.Id.String(), uuidToString(o.PrevID)}},
+			{`INSERT INTO level_orders (symbol, level_side, level_price, order_id) VALUES ($1, $2, $3, $4)`,
+				[]any{s.Symbol, o.Side, o.Price, o.Id.String()}},
+			{`UPDATE levels SET count = count + 1, volume = volume + $1 WHERE symbol = $2 AND side = $3 AND price = $4`,
+				[]any{o.Remaining, s.Symbol, o.Side, o.Price}},
 		}
 
-		row := tx.QueryRow(ctx, `
-			SELECT COUNT(*) FROM level_orders
-			WHERE level_side = $1 AND level_price = $2`, o.Side, o.Price)
-		var count int
-		if err := row.Scan(&count); err != nil {
-			return err
+	case engine.OpDeleteOrder:
+		return []pgStatement{
+			{`DELETE FROM level_orders WHERE order_id = $1`, []any{o.Id.String()}},
+			{`UPDATE levels SET count = count - 1, volume = volume - $1 WHERE symbol = $2 AND side = $3 AND price = $4`,
+				[]any{o.Remaining, s.Symbol, o.Side, o.Price}},
+			{`UPDATE orders SET prev_id = $1 WHERE id = $2`, []any{uuidToString(o.PrevID), uuidToString(o.NextID)}},
+			{`UPDATE orders SET next_id = $1 WHERE id = $2`, []any{uuidToString(o.NextID), uuidToString(o.PrevID)}},
+			{`DELETE FROM orders WHERE id = $1`, []any{o.Id.String()}},
+			s.deleteEmptyLevel(o.Side, o.Price),
 		}
-		if count == 0 {
-			if _, err := tx.Exec(ctx, `
-				DELETE FROM levels
-				WHERE side = $1 AND price = $2`,
-				o.Side, o.Price,
-			); err != nil {
-				return err
-			}
+
+	case engine.OpUpdateOrder:
+		// The level's volume moves by the change in remaining, so it is
+		// updated before the order.
+		stmts := []pgStatement{
+			{`
+			UPDATE levels SET volume = volume + $1 - (SELECT remaining FROM orders WHERE id = $2)
+			WHERE symbol = $3 AND side = $4 AND price = $5`,
+				[]any{o.Remaining, o.Id.String(), s.Symbol, o.Side, o.Price}},
+			{`UPDATE orders SET remaining = $1, size = $2, hidden = $3 WHERE id = $4`,
+				[]any{o.Remaining, o.Size, o.Hidden, o.Id.String()}},
 		}
-	}
+		if o.Remaining <= 0 {
+			stmts = append(stmts,
+				pgStatement{`
+				DELETE FROM level_orders
+				WHERE symbol = $1 AND level_side = $2 AND level_price = $3 AND order_id = $4`,
+					[]any{s.Symbol, o.Side, o.Price, o.Id.String()}},
+				s.deleteEmptyLevel(o.Side, o.Price),
+			)
+		}
+		return stmts
 
-	return tx.Commit(ctx)
-}
+	case engine.OpInsertTrade:
+		return []pgStatement{{`
+			INSERT INTO trades (id, symbol, buy_order_id, sell_order_id, price, size, time, seq)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+			s.tradeRow(m.Trade),
+		}}
 
-func (s *PostgresStorage) InsertTrade(t *engine.Trade) error {
-	ctx := context.Background()
-	tx, err := s.Database.Begin(ctx)
+	case engine.OpInsertStopOrder:
+		return []pgStatement{{`
+			INSERT INTO stop_orders (id, symbol, side, type, time_in_force, post_only, size, display_size, price, stop_price, time)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+			[]any{o.Id.String(), s.Symbol, o.Side, o.Type, o.TimeInForce, o.PostOnly, o.Size, o.DisplaySize, o.Price, o.StopPrice, o.Time},
+		}}
 
-	if err != nil {
-		return err
+	case engine.OpDeleteStopOrder:
+		return []pgStatement{{`DELETE FROM stop_orders WHERE id = $1`, []any{o.Id.String()}}}
 	}
-	defer tx.Rollback(ctx)
+	return nil
+}
 
-	if _, err := tx.Exec(ctx, `
-		INSERT INTO trades (id, buy_order_id, sell_order_id, price, size, time)
-		VALUES ($1, $2, $3, $4, $5, $6)`,
-		t.ID, t.BuyOrderID.String(), t.SellOrderID.String(), t.Price, t.Size, t.Time,
-	); err != nil {
-		Logger.Printf("Error inserting trade: %s", err)
-		return err
+// deleteEmptyLevel drops a level once its last order has left it.
+func (s *PostgresStorage) deleteEmptyLevel(side engine.Side, price int) pgStatement {
+	return pgStatement{`
+		DELETE FROM levels
+		WHERE symbol = $1 AND side = $2 AND price = $3
+		  AND NOT EXISTS (
+		      SELECT 1 FROM level_orders
+		      WHERE symbol = $1 AND level_side = $2 AND level_price = $3
+		  )`,
+		[]any{s.Symbol, side, price},
 	}
-
-	return tx.Commit(ctx)
 }
 
-func getPostgresLevels(db *pgx.Conn) (map[engine.Side]map[int]*engine.LevelDTO, error) {
-	ctx := context.Background()
+func (s *PostgresStorage) tradeRow(t *engine.Trade) []any {
+	return []any{t.ID.String(), s.Symbol, t.BuyOrderID.String(), t.SellOrderID.String(), t.Price, t.Size, t.Time, int64(t.Seq)}
+}
 
+func getPostgresLevels(ctx context.Context, db pgx.Tx, symbol string) (map[engine.Side]map[int]*engine.LevelDTO, error) {
 	// Single query joins levels with level_orders
 	rows, err := db.Query(ctx, `
 		SELECT l.side, l.price, l.volume, l.count, lo.order_id
 		FROM levels l
 		LEFT JOIN level_orders lo
-		  ON l.side = lo.level_side AND l.price = lo.level_price
-		ORDER BY l.side, l.price
-	`)
+		  ON l.symbol = lo.symbol AND l.side = lo.level_side AND l.price = lo.level_price
+		WHERE l.symbol = $1
+		ORDER BY l.side, l.price, lo.seq
+	`, symbol)
 	if err != nil {
 		return nil, err
 	}
@@ -408,13 +417,12 @@ func getPostgresLevels(db *pgx.Conn) (map[engine.Side]map[int]*engine.LevelDTO,
 	return book, nil
 }
 
-
-func getAllPostgresOrders(db *pgx.Conn) (map[uuid.UUID]*engine.OrderDTO, error) {
-	ctx := context.Background()
+func getAllPostgresOrders(ctx context.Context, db pgx.Tx, symbol string) (map[uuid.UUID]*engine.OrderDTO, error) {
 	rows, err := db.Query(ctx, `
-		SELECT id, side, size, remaining, price, time, next_id, prev_id
+		SELECT id, side, time_in_force, post_only, size, remaining, display_size, hidden, price, time, next_id, prev_id
 		FROM orders
-	`)
+		WHERE symbol = $1
+	`, symbol)
 	if err != nil {
 		return nil, err
 	}
@@ -427,7 +435,7 @@ func getAllPostgresOrders(db *pgx.Conn) (map[uuid.UUID]*engine.OrderDTO, error)
 		var idStr string
 		var nextID, prevID sql.NullString
 
-		if err := rows.Scan(&idStr, &o.Side, &o.Size, &o.Remaining, &o.Price, &o.Time, &nextID, &prevID); err != nil {
+		if err := rows.Scan(&idStr, &o.Side, &o.TimeInForce, &o.PostOnly, &o.Size, &o.Remaining, &o.DisplaySize, &o.Hidden, &o.Price, &o.Time, &nextID, &prevID); err != nil {
 			return nil, err
 		}
 
@@ -448,12 +456,15 @@ func getAllPostgresOrders(db *pgx.Conn) (map[uuid.UUID]*engine.OrderDTO, error)
 	return orders, nil
 }
 
-func getAllPostgresTrades(db *pgx.Conn) ([]engine.Trade, error) {
-	ctx := context.Background()
+func getAllPostgresTrades(ctx context.Context, db pgx.Tx, symbol string) ([]engine.Trade, error) {
+	// Trades of one command can share a timestamp once truncated to
+	// microseconds; seq keeps them in the order they happened.
 	rows, err := db.Query(ctx, `
-		SELECT id, buy_order_id, sell_order_id, price, size, time
+		SELECT id, buy_order_id, sell_order_id, price, size, time, seq
 		FROM trades
-	`)
+		WHERE symbol = $1
+		ORDER BY seq
+	`, symbol)
 	if err != nil {
 		return nil, err
 	}
@@ -465,7 +476,7 @@ func getAllPostgresTrades(db *pgx.Conn) ([]engine.Trade, error) {
 		var t engine.Trade
 		var buyID, sellID string
 
-		if err := rows.Scan(&t.ID, &buyID, &sellID, &t.Price, &t.Size, &t.Time); err != nil {
+		if err := rows.Scan(&t.ID, &buyID, &sellID, &t.Price, &t.Size, &t.Time, &t.Seq); err != nil {
 			return nil, err
 		}
 
@@ -478,6 +489,86 @@ func getAllPostgresTrades(db *pgx.Conn) ([]engine.Trade, error) {
 	return trades, nil
 }
 
+func getAllPostgresStopOrders(ctx context.Context, db pgx.Tx, symbol string) (map[uuid.UUID]*engine.OrderDTO, error) {
+	rows, err := db.Query(ctx, `
+		SELECT id, side, type, time_in_force, post_only, size, display_size, price, stop_price, time
+		FROM stop_orders
+		WHERE symbol = $1
+	`, symbol)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stops := make(map[uuid.UUID]*engine.OrderDTO)
+
+	for rows.Next() {
+		var o engine.OrderDTO
+		var idStr string
+
+		if err := rows.Scan(&idStr, &o.Side, &o.Type, &o.TimeInForce, &o.PostOnly, &o.Size, &o.DisplaySize, &o.Price, &o.StopPrice, &o.Time); err != nil {
+			return nil, err
+		}
+
+		o.Id = uuid.MustParse(idStr)
+		o.Remaining = o.Size
+
+		stops[o.Id] = &o
+	}
+
+	return stops, nil
+}
+
+// PostgresSymbolStore records the symbols traded on the exchange and
+// their instrument specs.
+type PostgresSymbolStore struct {
+	Database *PostgresDB
+}
+
+func (s *PostgresSymbolStore) LoadSymbols() ([]engine.Instrument, error) {
+	var instruments []engine.Instrument
+	err := s.Database.retry(func(ctx context.Context) error {
+		instruments = nil
+		rows, err := s.Database.Query(ctx, `
+			SELECT symbol, tick_size, lot_size, min_size, max_size, min_price, max_price
+			FROM symbols ORDER BY symbol`)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var i engine.Instrument
+			if err := rows.Scan(&i.Symbol, &i.TickSize, &i.LotSize, &i.MinSize, &i.MaxSize, &i.MinPrice, &i.MaxPrice); err != nil {
+				return err
+			}
+			instruments = append(instruments, i)
+		}
+		return rows.Err()
+	})
+	return instruments, err
+}
+
+// SaveSymbol records a symbol, replacing the spec of one already saved so
+// changes made in the config file stick.
+func (s *PostgresSymbolStore) SaveSymbol(i engine.Instrument) error {
+	return s.Database.retry(func(ctx context.Context) error {
+		_, err := s.Database.Exec(ctx, `
+			INSERT INTO symbols (symbol, tick_size, lot_size, min_size, max_size, min_price, max_price)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT (symbol) DO UPDATE SET
+			    tick_size = EXCLUDED.tick_size,
+			    lot_size = EXCLUDED.lot_size,
+			    min_size = EXCLUDED.min_size,
+			    max_size = EXCLUDED.max_size,
+			    min_price = EXCLUDED.min_price,
+			    max_price = EXCLUDED.max_price`,
+			i.Symbol, i.TickSize, i.LotSize, i.MinSize, i.MaxSize, i.MinPrice, i.MaxPrice,
+		)
+		return err
+	})
+}
+
 func uuidToString(u *uuid.UUID) interface{} {
 	if u == nil {
 		return nil
@@ -0,0 +1,226 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"limit-order-book/engine"
+)
+
+var ErrBadChecksum = errors.New("snapshot checksum mismatch")
+
+const snapshotExt = ".snapshot.json"
+
+// snapshotFile is the on-disk form of a snapshot. Checksum is the SHA-256
+// of Book exactly as written.
+type snapshotFile struct {
+	Symbol   string          `json:"symbol"`
+	Seq      uint64          `json:"seq"`
+	Time     time.Time       `json:"time"`
+	Checksum string          `json:"checksum"`
+	Book     json.RawMessage `json:"book"`
+}
+
+// SnapshotInfo describes one snapshot file. Err is set if it failed
+// verification.
+type SnapshotInfo struct {
+	Symbol string
+	Seq    uint64
+	Time   time.Time
+	Path   string
+	Size   int64
+	Err    error
+}
+
+// FileSnapshots keeps the snapshots of one symbol in Dir, one file per
+// sequence number.
+type FileSnapshots struct {
+	Dir    string
+	Symbol string
+}
+
+// Save writes a snapshot through a temporary file and a rename, so a
+// crash never leaves a half-written snapshot under the final name.
+func (f *FileSnapshots) Save(seq uint64, book *engine.OrderBookDTO) error {
+	data, err := json.Marshal(book)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+
+	file, err := json.Marshal(snapshotFile{
+		Symbol:   f.Symbol,
+		Seq:      seq,
+		Time:     time.Now().UTC(),
+		Checksum: hex.EncodeToString(sum[:]),
+		Book:     data,
+	})
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filepath.Join(f.Dir, snapshotName(f.Symbol, seq)), file)
+}
+
+// Latest loads the newest snapshot that passes verification, skipping
+// any that don't. It returns a nil book if there is none.
+func (f *FileSnapshots) Latest() (uint64, *engine.OrderBookDTO, error) {
+	infos, err := ListSnapshots(f.Dir, f.Symbol)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	for i := len(infos) - 1; i >= 0; i-- {
+		file, err := readSnapshot(infos[i].Path)
+		if err != nil {
+			Logger.Printf("Skipping snapshot %s: %s", infos[i].Path, err)
+			continue
+		}
+
+		var book engine.OrderBookDTO
+		if err := json.Unmarshal(file.Book, &book); err != nil {
+			Logger.Printf("Skipping snapshot %s: %s", infos[i].Path, err)
+			continue
+		}
+		return file.Seq, &book, nil
+	}
+	return 0, nil, nil
+}
+
+// ListSnapshots lists the snapshots in dir, of one symbol or of all
+// symbols if symbol is empty, ordered by symbol and then sequence number.
+// Files are verified as they are listed.
+func ListSnapshots(dir string, symbol string) ([]SnapshotInfo, error) {
+	pattern := "*" + snapshotExt
+	if symbol != "" {
+		pattern = symbol + "-*" + snapshotExt
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, pattern))
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []SnapshotInfo
+	for _, path := range paths {
+		info := SnapshotInfo{Path: path}
+		if stat, err := os.Stat(path); err == nil {
+			info.Size = stat.Size()
+		}
+
+		file, err := readSnapshot(path)
+		if err != nil {
+			info.Err = err
+			info.Symbol, info.Seq = parseSnapshotName(filepath.Base(path))
+		} else {
+			info.Symbol, info.Seq, info.Time = file.Symbol, file.Seq, file.Time
+		}
+		if symbol != "" && info.Symbol != symbol {
+			continue
+		}
+		infos = append(infos, info)
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Symbol != infos[j].Symbol {
+			return infos[i].Symbol < infos[j].Symbol
+		}
+		return infos[i].Seq < infos[j].Seq
+	})
+	return infos, nil
+}
+
+// PruneSnapshots deletes all but the newest keep valid snapshots of each
+// symbol, along with every snapshot that fails verification. It returns
+// the snapshots it deleted.
+func PruneSnapshots(dir string, symbol string, keep int) ([]SnapshotInfo, error) {
+	infos, err := ListSnapshots(dir, symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	var pruned []SnapshotInfo
+	kept := map[string]int{}
+	for i := len(infos) - 1; i >= 0; i-- {
+		info := infos[i]
+		if info.Err == nil && kept[info.Symbol] < keep {
+			kept[info.Symbol]++
+			continue
+		}
+		if err := os.Remove(info.Path); err != nil {
+			return pruned, err
+		}
+		pruned = append(pruned, info)
+	}
+	return pruned, nil
+}
+
+func readSnapshot(path string) (*snapshotFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file snapshotFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(file.Book)
+	if hex.EncodeToString(sum[:]) != file.Checksum {
+		return nil, ErrBadChecksum
+	}
+	return &file, nil
+}
+
+func snapshotName(symbol string, seq uint64) string {
+	return fmt.Sprintf("%s-%020d%s", symbol, seq, snapshotExt)
+}
+
+func parseSnapshotName(name string) (string, uint64) {
+	name = strings.TrimSuffix(name, snapshotExt)
+	i := strings.LastIndex(name, "-")
+	if i < 0 {
+		return name, 0
+	}
+	var seq uint64
+	fmt.Sscanf(name[i+1:], "%d", &seq)
+	return name[:i], seq
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"os"
+	"testing"
+
+	"limit-order-book/engine"
+)
+
+func TestFileSnapshotsSkipCorruptSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	snapshots := &FileSnapshots{Dir: dir, Symbol: "BTC-USD"}
+
+	ob := engine.NewOrderBook()
+	ob.ProcessOrder(engine.Buy, 40, 2)
+	if err := snapshots.Save(1, ob.ToDTO()); err != nil {
+		t.Fatalf("tests - save failed. expected=%v, got=%v", nil, err)
+	}
+	ob.ProcessOrder(engine.Buy, 41, 2)
+	snapshots.Save(2, ob.ToDTO())
+
+	infos, _ := ListSnapshots(dir, "BTC-USD")
+	data, _ := os.ReadFile(infos[1].Path)
+	data[len(data)-10] ^= 1
+	os.WriteFile(infos[1].Path, data, 0644)
+
+	seq, book, err := snapshots.Latest()
+	if err != nil || seq != 1 || len(book.Orders) != 1 {
+		t.Fatalf("tests - corrupt snapshot should be skipped. expected=%d, got=%d (%v)", 1, seq, err)
+	}
+
+	infos, _ = ListSnapshots(dir, "")
+	if len(infos) != 2 || infos[0].Err != nil || infos[1].Err == nil {
+		t.Fatalf("tests - list should flag the corrupt snapshot. expected=%d, got=%+v", 2, infos)
+	}
+}
+
+func TestPruneSnapshots(t *testing.T) {
+	dir := t.TempDir()
+	ob := engine.NewOrderBook()
+	for _, symbol := range []string{"BTC", "BTC-USD"} {
+		snapshots := &FileSnapshots{Dir: dir, Symbol: symbol}
+		for seq := uint64(1); seq <= 4; seq++ {
+			snapshots.Save(seq, ob.ToDTO())
+		}
+	}
+
+	pruned, err := PruneSnapshots(dir, "BTC", 1)
+	if err != nil || len(pruned) != 3 {
+		t.Fatalf("tests - prune should only touch the given symbol. expected=%d, got=%d (%v)", 3, len(pruned), err)
+	}
+
+	PruneSnapshots(dir, "", 2)
+	for symbol, expected := range map[string]uint64{"BTC": 4, "BTC-USD": 3} {
+		infos, _ := ListSnapshots(dir, symbol)
+		if len(infos) == 0 || infos[0].Seq != expected {
+			t.Fatalf("tests - prune should keep the newest snapshots. expected=%d, got=%+v", expected, infos)
+		}
+	}
+}
@@ -0,0 +1,571 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"strings"
+
+	"limit-order-book/engine"
+
+	"github.com/google/uuid"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// SqliteStorage persists the order book of one symbol in a SQLite file,
+// with the same tables as PostgresStorage.
+type SqliteStorage struct {
+	Database *sql.DB
+	Symbol   string
+	tx       *sql.Tx
+}
+
+// InitSqlite opens the database file named by TRADES, creating it if
+// needed, and applies pending migrations.
+func InitSqlite() *sql.DB {
+	db := OpenSqlite()
+	migrateUp(NewSqliteMigrator(db))
+	return db
+}
+
+// OpenSqlite opens the database file named by TRADES, leaving its schema
+// alone.
+func OpenSqlite() *sql.DB {
+	path := os.Getenv("TRADES")
+	if path == "" {
+		path = "/tmp/orderbook.db"
+	}
+
+	db, err := sql.Open("sqlite3", "file:"+path+"?_foreign_keys=on&_journal_mode=WAL&_busy_timeout=5000")
+	if err != nil {
+		Logger.Fatalf("failed to open %s: %s", path, err)
+	}
+	// SQLite allows one writer at a time; a single connection queues
+	// writers here instead of failing them with SQLITE_BUSY.
+	db.SetMaxOpenConns(1)
+
+	return db
+}
+
+func (s *SqliteStorage) Begin(seq uint64) error {
+	if s.tx != nil {
+		return errors.New("unit of work already open")
+	}
+	tx, err := s.Database.Begin()
+	if err != nil {
+		return err
+	}
+	s.tx = tx
+	return nil
+}
+
+func (s *SqliteStorage) Commit() error {
+	if s.tx == nil {
+		return nil
+	}
+	tx := s.tx
+	s.tx = nil
+	return tx.Commit()
+}
+
+func (s *SqliteStorage) Rollback() error {
+	if s.tx == nil {
+		return nil
+	}
+	tx := s.tx
+	s.tx = nil
+	return tx.Rollback()
+}
+
+// write runs a write in the open unit of work, or in a transaction of its
+// own if there is none. The database has a single connection, so writes
+// must never bypass an open unit of work.
+func (s *SqliteStorage) write(fn func(tx *sql.Tx) error) error {
+	if s.tx != nil {
+		return fn(s.tx)
+	}
+
+	tx, err := s.Database.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SqliteStorage) ResetOrderBook() error {
+	return s.write(func(tx *sql.Tx) error {
+		for _, query := range []string{
+			`DELETE FROM level_orders WHERE symbol = ?`,
+			`DELETE FROM levels WHERE symbol = ?`,
+			`UPDATE orders SET next_id = NULL, prev_id = NULL WHERE symbol = ?`,
+			`DELETE FROM orders WHERE symbol = ?`,
+			`DELETE FROM trades WHERE symbol = ?`,
+			`DELETE FROM stop_orders WHERE symbol = ?`,
+		} {
+			if _, err := tx.Exec(query, s.Symbol); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *SqliteStorage) RestoreOrderBook() (*engine.OrderBook, error) {
+	levelDTO, err := getSqliteLevels(s.Database, s.Symbol)
+	if err != nil {
+		Logger.Printf("Error getting levels from db: %s", err)
+		return nil, err
+	}
+
+	orderDTO, err := getAllSqliteOrders(s.Database, s.Symbol)
+	if err != nil {
+		Logger.Printf("Error getting orders from db: %s", err)
+		return nil, err
+	}
+
+	tradeDTO, err := getAllSqliteTrades(s.Database, s.Symbol)
+	if err != nil {
+		Logger.Printf("Error getting trades from db: %s", err)
+		return nil, err
+	}
+
+	stopDTO, err := getAllSqliteStopOrders(s.Database, s.Symbol)
+	if err != nil {
+		Logger.Printf("Error getting stop orders from db: %s", err)
+		return nil, err
+	}
+
+	obDTO := engine.OrderBookDTO{
+		Levels: levelDTO,
+		Orders: orderDTO,
+		Stops:  stopDTO,
+		Trades: tradeDTO,
+	}
+
+	return obDTO.ToOrderBook(), nil
+}
+
+func (s *SqliteStorage) InsertLevel(side engine.Side, l *engine.LevelDTO) error {
+	return s.write(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			INSERT INTO levels (symbol, side, price, volume, count)
+			VALUES (?, ?, ?, 0, 0)`, //InsertOrder takes care of updating volume, count
+			s.Symbol, side, l.Price,
+		)
+		return err
+	})
+}
+
+func (s *SqliteStorage) InsertOrder(o *engine.OrderDTO) error {
+	return s.write(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`
+			INSERT INTO orders (id, symbol, side, time_in_force, post_only, size, remaining, display_size, hidden, price, time, next_id, prev_id)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			o.Id.String(), s.Symbol, o.Side, o.TimeInForce, o.PostOnly, o.Size, o.Remaining, o.DisplaySize, o.Hidden, o.Price, o.Time,
+			uuidToString(o.NextID), uuidToString(o.PrevID),
+		); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(
+			`UPDATE orders SET next_id = ? WHERE id = ?`,
+			o.Id.String(), uuidToString(o.PrevID),
+		); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(
+			`INSERT INTO level_orders (symbol, level_side, level_price, order_id) VALUES (?, ?, ?, ?)`,
+			s.Symbol, o.Side, o.Price, o.Id.String(),
+		); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(
+			`UPDATE levels SET count = count + 1, volume = volume + ? WHERE symbol = ? AND side = ? AND price = ?`,
+			o.Remaining, s.Symbol, o.Side, o.Price,
+		); err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
+
+func (s *SqliteStorage) DeleteOrder(o *engine.OrderDTO) error {
+	return s.write(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM level_orders WHERE order_id = ?`, o.Id.String()); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(
+			`UPDATE levels SET count = count - 1, volume = volume - ? WHERE symbol = ? AND side = ? AND price = ?`,
+			o.Remaining, s.Symbol, o.Side, o.Price,
+		); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(
+			`UPDATE orders SET prev_id = ? WHERE id = ?`,
+			uuidToString(o.PrevID), uuidToString(o.NextID),
+		); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(
+			`UPDATE orders SET next_id = ? WHERE id = ?`,
+			uuidToString(o.NextID), uuidToString(o.PrevID),
+		); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(`DELETE FROM orders WHERE id = ?`, o.Id.String()); err != nil {
+			return err
+		}
+
+		if err := deleteEmptySqliteLevel(tx, s.Symbol, o.Side, o.Price); err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
+
+func (s *SqliteStorage) UpdateOrder(o *engine.OrderDTO) error {
+	return s.write(func(tx *sql.Tx) error {
+		var oldRemaining int
+		if err := tx.QueryRow(`SELECT remaining FROM orders WHERE id = ?`, o.Id.String()).Scan(&oldRemaining); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(`
+			UPDATE orders
+			SET remaining = ?, size = ?, hidden = ?
+			WHERE id = ?`,
+			o.Remaining, o.Size, o.Hidden, o.Id.String(),
+		); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(
+			`UPDATE levels SET volume = volume + ? WHERE symbol = ? AND side = ? AND price = ?`,
+			o.Remaining-oldRemaining, s.Symbol, o.Side, o.Price,
+		); err != nil {
+			return err
+		}
+
+		if o.Remaining <= 0 {
+			if _, err := tx.Exec(`
+				DELETE FROM level_orders
+				WHERE symbol = ? AND level_side = ? AND level_price = ? AND order_id = ?`,
+				s.Symbol, o.Side, o.Price, o.Id.String(),
+			); err != nil {
+				return err
+			}
+
+			if err := deleteEmptySqliteLevel(tx, s.Symbol, o.Side, o.Price); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s *SqliteStorage) InsertTrade(t *engine.Trade) error {
+	return s.write(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`
+			INSERT INTO trades (id, symbol, buy_order_id, sell_order_id, price, size, time, seq)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			t.ID.String(), s.Symbol, t.BuyOrderID.String(), t.SellOrderID.String(), t.Price, t.Size, t.Time, t.Seq,
+		); err != nil {
+			Logger.Printf("Error inserting trade: %s", err)
+			return err
+		}
+		return nil
+	})
+}
+
+func (s *SqliteStorage) InsertStopOrder(o *engine.OrderDTO) error {
+	return s.write(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`
+			INSERT INTO stop_orders (id, symbol, side, type, time_in_force, post_only, size, display_size, price, stop_price, time)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			o.Id.String(), s.Symbol, o.Side, o.Type, o.TimeInForce, o.PostOnly, o.Size, o.DisplaySize, o.Price, o.StopPrice, o.Time,
+		); err != nil {
+			Logger.Printf("Error inserting stop order: %s", err)
+			return err
+		}
+		return nil
+	})
+}
+
+func (s *SqliteStorage) DeleteStopOrder(o *engine.OrderDTO) error {
+	return s.write(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`DELETE FROM stop_orders WHERE id = ?`, o.Id.String())
+		return err
+	})
+}
+
+// WriteBatch writes the mutations of many commands in one transaction,
+// with their trades in multi-row inserts at the end; nothing else in a
+// batch reads them.
+func (s *SqliteStorage) WriteBatch(mutations []engine.Mutation) error {
+	if err := s.Begin(0); err != nil {
+		return err
+	}
+	defer s.Rollback()
+
+	var trades []*engine.Trade
+	for _, m := range mutations {
+		if m.Op == engine.OpInsertTrade {
+			trades = append(trades, m.Trade)
+			continue
+		}
+		if err := m.Apply(s); err != nil {
+			return err
+		}
+	}
+
+	for len(trades) > 0 {
+		n := min(len(trades), sqliteTradesPerInsert)
+		args := make([]any, 0, 8*n)
+		for _, t := range trades[:n] {
+			args = append(args, t.ID.String(), s.Symbol, t.BuyOrderID.String(), t.SellOrderID.String(), t.Price, t.Size, t.Time, t.Seq)
+		}
+		if _, err := s.tx.Exec(`
+			INSERT INTO trades (id, symbol, buy_order_id, sell_order_id, price, size, time, seq)
+			VALUES `+strings.TrimSuffix(strings.Repeat("(?, ?, ?, ?, ?, ?, ?, ?), ", n), ", "),
+			args...,
+		); err != nil {
+			return err
+		}
+		trades = trades[n:]
+	}
+
+	return s.Commit()
+}
+
+// sqliteTradesPerInsert keeps a multi-row insert well under SQLite's limit
+// on statement parameters.
+const sqliteTradesPerInsert = 500
+
+// deleteEmptySqliteLevel drops a level once its last order has left it.
+func deleteEmptySqliteLevel(tx *sql.Tx, symbol string, side engine.Side, price int) error {
+	var count int
+	if err := tx.QueryRow(`
+		SELECT COUNT(*) FROM level_orders
+		WHERE symbol = ? AND level_side = ? AND level_price = ?`,
+		symbol, side, price,
+	).Scan(&count); err != nil {
+		return err
+	}
+
+	if count == 0 {
+		if _, err := tx.Exec(`DELETE FROM levels WHERE symbol = ? AND side = ? AND price = ?`, symbol, side, price); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func getSqliteLevels(db *sql.DB, symbol string) (map[engine.Side]map[int]*engine.LevelDTO, error) {
+	// level_orders rows come back in insertion order, which is the FIFO
+	// order of the level.
+	rows, err := db.Query(`
+		SELECT l.side, l.price, l.volume, l.count, lo.order_id
+		FROM levels l
+		LEFT JOIN level_orders lo
+		  ON l.symbol = lo.symbol AND l.side = lo.level_side AND l.price = lo.level_price
+		WHERE l.symbol = ?
+		ORDER BY l.side, l.price, lo.rowid
+	`, symbol)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	book := map[engine.Side]map[int]*engine.LevelDTO{
+		engine.Buy:  {},
+		engine.Sell: {},
+	}
+
+	for rows.Next() {
+		var side engine.Side
+		var price, volume, count int
+		var orderID sql.NullString
+
+		if err := rows.Scan(&side, &price, &volume, &count, &orderID); err != nil {
+			return nil, err
+		}
+
+		if book[side] == nil {
+			book[side] = make(map[int]*engine.LevelDTO)
+		}
+
+		level, exists := book[side][price]
+		if !exists {
+			level = &engine.LevelDTO{
+				Price:  price,
+				Volume: volume,
+				Count:  count,
+				Orders: []uuid.UUID{},
+			}
+			book[side][price] = level
+		}
+
+		if orderID.Valid {
+			level.Orders = append(level.Orders, uuid.MustParse(orderID.String))
+		}
+	}
+
+	return book, rows.Err()
+}
+
+func getAllSqliteOrders(db *sql.DB, symbol string) (map[uuid.UUID]*engine.OrderDTO, error) {
+	rows, err := db.Query(`
+		SELECT id, side, time_in_force, post_only, size, remaining, display_size, hidden, price, time, next_id, prev_id
+		FROM orders
+		WHERE symbol = ?
+	`, symbol)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orders := make(map[uuid.UUID]*engine.OrderDTO)
+
+	for rows.Next() {
+		var o engine.OrderDTO
+		var idStr string
+		var nextID, prevID sql.NullString
+
+		if err := rows.Scan(&idStr, &o.Side, &o.TimeInForce, &o.PostOnly, &o.Size, &o.Remaining, &o.DisplaySize, &o.Hidden, &o.Price, &o.Time, &nextID, &prevID); err != nil {
+			return nil, err
+		}
+
+		o.Id = uuid.MustParse(idStr)
+
+		if nextID.Valid {
+			nid := uuid.MustParse(nextID.String)
+			o.NextID = &nid
+		}
+		if prevID.Valid {
+			pid := uuid.MustParse(prevID.String)
+			o.PrevID = &pid
+		}
+
+		orders[o.Id] = &o
+	}
+
+	return orders, rows.Err()
+}
+
+func getAllSqliteTrades(db *sql.DB, symbol string) ([]engine.Trade, error) {
+	rows, err := db.Query(`
+		SELECT id, buy_order_id, sell_order_id, price, size, time, seq
+		FROM trades
+		WHERE symbol = ?
+		ORDER BY seq
+	`, symbol)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var trades []engine.Trade
+
+	for rows.Next() {
+		var t engine.Trade
+		var id, buyID, sellID string
+
+		if err := rows.Scan(&id, &buyID, &sellID, &t.Price, &t.Size, &t.Time, &t.Seq); err != nil {
+			return nil, err
+		}
+
+		t.ID = uuid.MustParse(id)
+		t.BuyOrderID = uuid.MustParse(buyID)
+		t.SellOrderID = uuid.MustParse(sellID)
+
+		trades = append(trades, t)
+	}
+
+	return trades, rows.Err()
+}
+
+func getAllSqliteStopOrders(db *sql.DB, symbol string) (map[uuid.UUID]*engine.OrderDTO, error) {
+	rows, err := db.Query(`
+		SELECT id, side, type, time_in_force, post_only, size, display_size, price, stop_price, time
+		FROM stop_orders
+		WHERE symbol = ?
+	`, symbol)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stops := make(map[uuid.UUID]*engine.OrderDTO)
+
+	for rows.Next() {
+		var o engine.OrderDTO
+		var idStr string
+
+		if err := rows.Scan(&idStr, &o.Side, &o.Type, &o.TimeInForce, &o.PostOnly, &o.Size, &o.DisplaySize, &o.Price, &o.StopPrice, &o.Time); err != nil {
+			return nil, err
+		}
+
+		o.Id = uuid.MustParse(idStr)
+		o.Remaining = o.Size
+
+		stops[o.Id] = &o
+	}
+
+	return stops, rows.Err()
+}
+
+// SqliteSymbolStore records the symbols traded on the exchange and their
+// instrument specs.
+type SqliteSymbolStore struct {
+	Database *sql.DB
+}
+
+func (s *SqliteSymbolStore) LoadSymbols() ([]engine.Instrument, error) {
+	rows, err := s.Database.Query(`
+		SELECT symbol, tick_size, lot_size, min_size, max_size, min_price, max_price
+		FROM symbols ORDER BY symbol`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var instruments []engine.Instrument
+	for rows.Next() {
+		var i engine.Instrument
+		if err := rows.Scan(&i.Symbol, &i.TickSize, &i.LotSize, &i.MinSize, &i.MaxSize, &i.MinPrice, &i.MaxPrice); err != nil {
+			return nil, err
+		}
+		instruments = append(instruments, i)
+	}
+	return instruments, rows.Err()
+}
+
+func (s *SqliteSymbolStore) SaveSymbol(i engine.Instrument) error {
+	_, err := s.Database.Exec(`
+		INSERT INTO symbols (symbol, tick_size, lot_size, min_size, max_size, min_price, max_price)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (symbol) DO UPDATE SET
+		    tick_size = excluded.tick_size,
+		    lot_size = excluded.lot_size,
+		    min_size = excluded.min_size,
+		    max_size = excluded.max_size,
+		    min_price = excluded.min_price,
+		    max_price = excluded.max_price`,
+		i.Symbol, i.TickSize, i.LotSize, i.MinSize, i.MaxSize, i.MinPrice, i.MaxPrice,
+	)
+	return err
+}
@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"limit-order-book/engine"
+
+	"github.com/google/uuid"
+)
+
+func TestSqliteStorageRestoresBook(t *testing.T) {
+	t.Setenv("TRADES", filepath.Join(t.TempDir(), "orderbook.db"))
+	db := InitSqlite()
+	defer db.Close()
+
+	ob := engine.NewOrderBook()
+	ob.AddStorage(&SqliteStorage{Database: db, Symbol: "BTC-USD"})
+	ob.ProcessOrder(engine.Buy, 40, 5)
+	ob.ProcessOrder(engine.Buy, 40, 3)
+	ob.ProcessOrder(engine.Buy, 39, 2)
+	ob.ProcessOrder(engine.Sell, 40, 6)
+	ob.PlaceOrder(engine.OrderRequest{Side: engine.Sell, Type: engine.Limit, Price: 42, Size: 10, DisplaySize: 4})
+	ob.PlaceOrder(engine.OrderRequest{Side: engine.Sell, Type: engine.StopLimit, StopPrice: 38, Price: 37, Size: 1})
+	id, _ := ob.ProcessOrder(engine.Sell, 45, 1)
+	ob.CancelOrder(id)
+
+	other := engine.NewOrderBook()
+	other.AddStorage(&SqliteStorage{Database: db, Symbol: "ETH-USD"})
+	other.ProcessOrder(engine.Sell, 40, 1)
+
+	restored, err := (&SqliteStorage{Database: db, Symbol: "BTC-USD"}).RestoreOrderBook()
+	if err != nil {
+		t.Fatalf("tests - restore failed. expected=%v, got=%v", nil, err)
+	}
+
+	expected, _ := json.Marshal(ob.ToDTO())
+	got, _ := json.Marshal(restored.ToDTO())
+	if string(expected) != string(got) {
+		t.Fatalf("tests - restored book should match. expected=%s, got=%s", expected, got)
+	}
+}
+
+func TestSqliteStorageRollsBackUnitOfWork(t *testing.T) {
+	t.Setenv("TRADES", filepath.Join(t.TempDir(), "orderbook.db"))
+	db := InitSqlite()
+	defer db.Close()
+
+	storage := &SqliteStorage{Database: db, Symbol: "BTC-USD"}
+	storage.Begin(1)
+	storage.InsertLevel(engine.Buy, &engine.LevelDTO{Price: 40})
+	storage.InsertOrder(&engine.OrderDTO{Id: uuid.New(), Side: engine.Buy, Price: 40, Size: 1, Remaining: 1})
+	if err := storage.Rollback(); err != nil {
+		t.Fatalf("tests - rollback failed. expected=%v, got=%v", nil, err)
+	}
+
+	restored, err := storage.RestoreOrderBook()
+	if err != nil || len(restored.ToDTO().Orders) != 0 || len(restored.ToDTO().Levels[engine.Buy]) != 0 {
+		t.Fatalf("tests - rolled back writes should not be stored. expected=%d, got=%+v (%v)", 0, restored.ToDTO(), err)
+	}
+
+	// The single connection must be free again for writes of their own.
+	if err := storage.InsertLevel(engine.Buy, &engine.LevelDTO{Price: 40}); err != nil {
+		t.Fatalf("tests - write after rollback failed. expected=%v, got=%v", nil, err)
+	}
+}
+
+func TestSqliteStorageWritesBatches(t *testing.T) {
+	t.Setenv("TRADES", filepath.Join(t.TempDir(), "orderbook.db"))
+	db := InitSqlite()
+	defer db.Close()
+
+	async := engine.NewAsyncStorage(&SqliteStorage{Database: db, Symbol: "BTC-USD"}, engine.AsyncConfig{BatchSize: 1000, FlushInterval: time.Hour})
+	defer async.Close()
+
+	ob := engine.NewOrderBook()
+	ob.AddStorage(async)
+	for i := range 20 {
+		ob.ProcessOrder(engine.Sell, 40+i%3, 2)
+	}
+	ob.ProcessOrder(engine.Buy, 41, 25)
+	ob.ProcessOrder(engine.Buy, 39, 1)
+
+	restored, err := async.RestoreOrderBook()
+	if err != nil {
+		t.Fatalf("tests - restore failed. expected=%v, got=%v", nil, err)
+	}
+
+	expected, _ := json.Marshal(ob.ToDTO())
+	got, _ := json.Marshal(restored.ToDTO())
+	if string(expected) != string(got) || async.DurableSeq() != ob.Seq() {
+		t.Fatalf("tests - batched writes should restore the book. expected=%s, got=%s", expected, got)
+	}
+}
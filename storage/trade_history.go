@@ -0,0 +1,236 @@
+package storage
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"limit-order-book/engine"
+
+	"github.com/google/uuid"
+)
+
+// tradeQuerySQL builds the SELECT for q. placeholder returns the SQL for
+// the nth argument, counting from 1. Paging is keyset on seq, which the
+// trades_symbol_seq index covers.
+func tradeQuerySQL(q engine.TradeQuery, placeholder func(n int) string) (string, []any) {
+	var where []string
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return placeholder(len(args))
+	}
+
+	where = append(where, "symbol = "+arg(q.Symbol))
+	if !q.From.IsZero() {
+		where = append(where, "time >= "+arg(q.From.UTC()))
+	}
+	if !q.To.IsZero() {
+		where = append(where, "time < "+arg(q.To.UTC()))
+	}
+	if q.MinPrice != 0 {
+		where = append(where, "price >= "+arg(q.MinPrice))
+	}
+	if q.MaxPrice != 0 {
+		where = append(where, "price <= "+arg(q.MaxPrice))
+	}
+	if q.OrderID != uuid.Nil {
+		id := q.OrderID.String()
+		where = append(where, fmt.Sprintf("(buy_order_id = %s OR sell_order_id = %s)", arg(id), arg(id)))
+	}
+
+	order, after := "ASC", ">"
+	if q.Descending {
+		order, after = "DESC", "<"
+	}
+	if q.After != nil {
+		where = append(where, fmt.Sprintf("seq %s %s", after, arg(int64(q.After.Seq))))
+	}
+
+	query := fmt.Sprintf(`
+		SELECT id, buy_order_id, sell_order_id, price, size, time, seq
+		FROM trades
+		WHERE %s
+		ORDER BY seq %s
+		LIMIT %s`, strings.Join(where, " AND "), order, arg(q.Limit))
+	return query, args
+}
+
+type tradeRows interface {
+	Next() bool
+	Scan(dest ...any) error
+	Err() error
+}
+
+func scanTrades(rows tradeRows) ([]engine.Trade, error) {
+	trades := []engine.Trade{}
+	for rows.Next() {
+		var t engine.Trade
+		var id, buyID, sellID string
+		if err := rows.Scan(&id, &buyID, &sellID, &t.Price, &t.Size, &t.Time, &t.Seq); err != nil {
+			return nil, err
+		}
+		t.ID = uuid.MustParse(id)
+		t.BuyOrderID = uuid.MustParse(buyID)
+		t.SellOrderID = uuid.MustParse(sellID)
+		trades = append(trades, t)
+	}
+	return trades, rows.Err()
+}
+
+// PostgresTradeHistory queries the trades table of every symbol.
+type PostgresTradeHistory struct {
+	Database *PostgresDB
+}
+
+func (h *PostgresTradeHistory) QueryTrades(q engine.TradeQuery) ([]engine.Trade, error) {
+	query, args := tradeQuerySQL(q, func(n int) string { return fmt.Sprintf("$%d", n) })
+
+	var trades []engine.Trade
+	err := h.Database.retry(func(ctx context.Context) error {
+		rows, err := h.Database.Query(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		trades, err = scanTrades(rows)
+		return err
+	})
+	return trades, err
+}
+
+// SqliteTradeHistory queries the trades table of every symbol.
+type SqliteTradeHistory struct {
+	Database *sql.DB
+}
+
+func (h *SqliteTradeHistory) QueryTrades(q engine.TradeQuery) ([]engine.Trade, error) {
+	query, args := tradeQuerySQL(q, func(int) string { return "?" })
+	rows, err := h.Database.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanTrades(rows)
+}
+
+// JsonTradeHistory answers from each symbol's trade file. It keeps the
+// trades it has read in memory and on every query reads only what was
+// appended since, or the whole file again once it has been replaced.
+type JsonTradeHistory struct {
+	mu    sync.Mutex
+	files map[string]*jsonTradeFile
+}
+
+// jsonTradeFile is what has been read of one trade file: its trades up to
+// offset. The file is kept open so it is known apart from the one that
+// replaces it.
+type jsonTradeFile struct {
+	file   *os.File
+	info   os.FileInfo
+	offset int64
+	trades []engine.Trade
+}
+
+func (h *JsonTradeHistory) QueryTrades(q engine.TradeQuery) ([]engine.Trade, error) {
+	all, err := h.read(q.Symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	// The file holds trades in seq order, so the cursor is found by search.
+	start, end := 0, len(all)
+	if q.After != nil {
+		i, found := slices.BinarySearchFunc(all, q.After.Seq, func(t engine.Trade, seq uint64) int {
+			return cmp.Compare(t.Seq, seq)
+		})
+		if q.Descending {
+			end = i
+		} else if start = i; found {
+			start++
+		}
+	}
+
+	trades := []engine.Trade{}
+	for n := 0; n < end-start && len(trades) < q.Limit; n++ {
+		t := all[start+n]
+		if q.Descending {
+			t = all[end-1-n]
+		}
+		if q.Matches(t) {
+			trades = append(trades, t)
+		}
+	}
+	return trades, nil
+}
+
+// read brings what is held of symbol's trade file up to date and returns
+// its trades.
+func (h *JsonTradeHistory) read(symbol string) ([]engine.Trade, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.files == nil {
+		h.files = make(map[string]*jsonTradeFile)
+	}
+	held := h.files[symbol]
+
+	path := (&JsonStorage{Symbol: symbol}).getTradesFilename()
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		if held != nil {
+			held.file.Close()
+			delete(h.files, symbol)
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The writer only appends to the file, or replaces it whole on a
+	// reset or a repair.
+	if held == nil || !os.SameFile(held.info, info) || info.Size() < held.offset {
+		if held != nil {
+			held.file.Close()
+			delete(h.files, symbol)
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		if info, err = file.Stat(); err != nil {
+			file.Close()
+			return nil, err
+		}
+		held = &jsonTradeFile{file: file, info: info}
+		h.files[symbol] = held
+	}
+	if info.Size() == held.offset {
+		return held.trades, nil
+	}
+
+	data := make([]byte, info.Size()-held.offset)
+	n, err := held.file.ReadAt(data, held.offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	// A line still being written is left for the next read.
+	trades, ends, err := readJsonTrades(data[:n])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	held.trades = append(held.trades, trades...)
+	if len(ends) > 0 {
+		held.offset += int64(ends[len(ends)-1])
+	}
+	return held.trades, nil
+}
@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"limit-order-book/engine"
+
+	"github.com/google/uuid"
+)
+
+// tradingBook makes trades at a few prices and times against storage and
+// returns the id of an order that traded twice.
+func tradingBook(storage engine.Storage) uuid.UUID {
+	ob := engine.NewOrderBook()
+	ob.SetIDGenerator(&engine.SequentialIDs{})
+	ob.AddStorage(storage)
+
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	var big uuid.UUID
+	for i := range 5 {
+		ob.SetClock(engine.FixedClock(start.Add(time.Duration(i) * time.Minute)))
+		ob.ProcessOrder(engine.Sell, 40+i, 1)
+		ob.ProcessOrder(engine.Sell, 40+i, 1)
+		id, _ := ob.ProcessOrder(engine.Buy, 40+i, 2)
+		if i == 2 {
+			big = id
+		}
+	}
+	return big
+}
+
+// pageAll follows the cursor through every page of q.
+func pageAll(t *testing.T, history engine.TradeHistory, q engine.TradeQuery) []engine.Trade {
+	t.Helper()
+	var all []engine.Trade
+	for {
+		trades, err := history.QueryTrades(q)
+		if err != nil {
+			t.Fatalf("tests - query failed. expected=%v, got=%v", nil, err)
+		}
+		all = append(all, trades...)
+		if len(trades) < q.Limit {
+			return all
+		}
+		last := trades[len(trades)-1]
+		q.After = &engine.TradeCursor{Seq: last.Seq}
+	}
+}
+
+func TestTradeHistoryPagesAndFilters(t *testing.T) {
+	t.Setenv("TRADES", filepath.Join(t.TempDir(), "orderbook.db"))
+	t.Setenv("ORDERBOOK", filepath.Join(t.TempDir(), "orderbook.json"))
+	db := InitSqlite()
+	defer db.Close()
+
+	order := tradingBook(&SqliteStorage{Database: db, Symbol: "BTC-USD"})
+	tradingBook(&JsonStorage{Symbol: "BTC-USD"})
+
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for name, history := range map[string]engine.TradeHistory{
+		"sqlite": &SqliteTradeHistory{Database: db},
+		"json":   &JsonTradeHistory{},
+	} {
+		all := pageAll(t, history, engine.TradeQuery{Symbol: "BTC-USD", Limit: 3})
+		if len(all) != 10 {
+			t.Fatalf("tests - %s should page through every trade. expected=%d, got=%d", name, 10, len(all))
+		}
+		for i, trade := range all {
+			if trade.Seq != uint64(i+1) {
+				t.Fatalf("tests - %s pages should be in the order trades were made. expected=%d, got=%d", name, i+1, trade.Seq)
+			}
+		}
+
+		desc := pageAll(t, history, engine.TradeQuery{Symbol: "BTC-USD", Descending: true, Limit: 4})
+		slices.Reverse(desc)
+		if !slices.EqualFunc(all, desc, func(a, b engine.Trade) bool { return a.ID == b.ID }) {
+			t.Fatalf("tests - %s newest first should be the reverse. expected=%v, got=%v", name, all, desc)
+		}
+
+		filtered := pageAll(t, history, engine.TradeQuery{
+			Symbol:   "BTC-USD",
+			From:     start.Add(time.Minute),
+			To:       start.Add(4 * time.Minute),
+			MinPrice: 42,
+			Limit:    1,
+		})
+		if len(filtered) != 4 || filtered[0].Price != 42 || filtered[3].Price != 43 {
+			t.Fatalf("tests - %s should filter by time and price. expected=%d, got=%+v", name, 4, filtered)
+		}
+
+		byOrder := pageAll(t, history, engine.TradeQuery{Symbol: "BTC-USD", OrderID: order, Limit: 10})
+		if len(byOrder) != 2 || byOrder[0].BuyOrderID != order || byOrder[1].BuyOrderID != order {
+			t.Fatalf("tests - %s should filter by order. expected=%d, got=%+v", name, 2, byOrder)
+		}
+	}
+}
@@ -161,6 +161,7 @@ const IndexHTML = `<!doctype html>
 	<div class="side-by-side">
 		<div>
 			<h1>Limit Order Book</h1>
+			<p class="lead">{{.Symbol}}{{range .Symbols}} · <a href="/?symbol={{.}}">{{.}}</a>{{end}}</p>
 		</div>
 
 		<div>
@@ -295,7 +296,7 @@ const IndexHTML = `<!doctype html>
 			const price = parseInt(document.getElementById('price').value);
 			const size = parseInt(document.getElementById('size').value);
 
-			const resp = await fetch('/api/order', {
+			const resp = await fetch('/api/{{.Symbol}}/order', {
 				method: 'POST',
 				headers: {'Content-Type': 'application/json'},
 				body: JSON.stringify({side, price, size})
@@ -311,7 +312,7 @@ const IndexHTML = `<!doctype html>
 		wipe.addEventListener('click', async () => {
 		  if (!confirm("Are you sure you want to wipe all orders?")) return;
 
-		  const resp = await fetch('/api/wipe', {
+		  const resp = await fetch('/api/{{.Symbol}}/wipe', {
 		    method: 'POST'
 		  });
 